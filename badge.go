@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/restic/calens/changelog"
+)
+
+// Badge is the data for a shields.io endpoint badge, see
+// https://shields.io/badges/endpoint-badge
+type Badge struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Label         string `json:"label"`
+	Message       string `json:"message"`
+	Color         string `json:"color"`
+}
+
+// runBadge implements the "badge" command: it writes the JSON data for a
+// shields.io badge showing either the latest release ("latest") or the number
+// of unreleased changes ("pending", the default).
+func runBadge(args []string) {
+	kind := "pending"
+	if len(args) > 1 {
+		die("usage: calens badge [latest|pending]")
+	}
+	if len(args) == 1 {
+		kind = args[0]
+	}
+
+	releases := readReleases(opts.InputDir)
+
+	var badge Badge
+	switch kind {
+	case "latest":
+		badge = latestBadge(releases)
+	case "pending":
+		badge = pendingBadge(releases)
+	default:
+		die("unknown badge %q, valid badges: latest, pending", kind)
+	}
+
+	writeOutput(func(wr io.Writer) error {
+		enc := json.NewEncoder(wr)
+		enc.SetIndent("", "  ")
+		return enc.Encode(badge)
+	})
+}
+
+// latestBadge returns a badge for the newest release with a release date.
+func latestBadge(releases []changelog.Release) Badge {
+	badge := Badge{
+		SchemaVersion: 1,
+		Label:         "release",
+		Message:       "none",
+		Color:         "lightgrey",
+	}
+
+	for _, rel := range releases {
+		if rel.Date == nil {
+			continue
+		}
+
+		badge.Message = fmt.Sprintf("%v (%v)", rel.Version, rel.Date.Format("2006-01-02"))
+		badge.Color = "blue"
+		break
+	}
+
+	return badge
+}
+
+// pendingBadge returns a badge with the number of unreleased changes.
+func pendingBadge(releases []changelog.Release) Badge {
+	var pending []changelog.Release
+	for _, rel := range releases {
+		if rel.Date == nil {
+			pending = append(pending, rel)
+		}
+	}
+
+	// drafts and entries which are not rendered are not counted
+	count := 0
+	for _, list := range readEntries(pending) {
+		count += len(filterEntries(list))
+	}
+
+	badge := Badge{
+		SchemaVersion: 1,
+		Label:         "next release",
+		Message:       fmt.Sprintf("%d changes pending", count),
+		Color:         "orange",
+	}
+
+	switch count {
+	case 0:
+		badge.Message = "no changes pending"
+		badge.Color = "brightgreen"
+	case 1:
+		badge.Message = "1 change pending"
+	}
+
+	return badge
+}
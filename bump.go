@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"text/template"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/restic/calens/changelog"
+)
+
+// bumpRule replaces the matches of pattern in file with the rendered
+// replace template, see BumpConfig.
+type bumpRule struct {
+	file    string
+	pattern *regexp.Regexp
+	replace *template.Template
+}
+
+// bumpRules are the rules from the bump section of the config.
+var bumpRules []bumpRule
+
+// runBump implements the "bump" command: it updates the version in the files
+// configured in the bump section of the config, either to the version given
+// in args or to the next version computed from the unreleased entries.
+func runBump(args []string) {
+	if len(args) > 1 {
+		die("usage: calens bump [VERSION]")
+	}
+
+	if len(bumpRules) == 0 {
+		die("no files to update, configure them in the bump section of the config")
+	}
+
+	var version string
+	if len(args) == 1 {
+		rel, err := opts.ParseVersion(args[0])
+		if err != nil {
+			die("invalid version %v: %v", args[0], err)
+		}
+		version = rel.Version
+	} else {
+		var err error
+		version, err = nextVersion(readReleases(opts.InputDir), now())
+		if err != nil {
+			die("%v", err)
+		}
+	}
+
+	err := bumpFiles(version)
+	if err != nil {
+		die("%v", err)
+	}
+}
+
+// nextVersion returns the version for the unreleased entries. With semver,
+// the major version is incremented if an entry is marked as breaking, the
+// minor version if there are changes or enhancements, the patch version
+// otherwise. With calver, the version is the year and month of now, with the
+// micro component incremented if there already is a release this month.
+// With ordinal versioning, the release number is incremented.
+func nextVersion(releases []changelog.Release, now time.Time) (string, error) {
+	var latest, unreleased []changelog.Release
+	for _, rel := range releases {
+		switch {
+		case rel.Date == nil:
+			unreleased = append(unreleased, rel)
+		case latest == nil:
+			latest = []changelog.Release{rel}
+		}
+	}
+
+	// drafts and entries which are not rendered don't decide the version
+	var entries []changelog.Entry
+	for _, list := range readEntries(unreleased) {
+		entries = append(entries, filterEntries(list)...)
+	}
+
+	if len(entries) == 0 {
+		return "", errors.New("no unreleased entries found")
+	}
+
+	switch opts.Versioning {
+	case "semver":
+	case "calver":
+		next := []int{now.Year(), int(now.Month())}
+		if latest != nil {
+			cur, _ := changelog.ParseCalVer(latest[0].Version)
+			if len(cur) >= 2 && cur[0] == next[0] && cur[1] == next[1] {
+				micro := 1
+				if len(cur) > 2 {
+					micro = cur[2] + 1
+				}
+				next = append(next, micro)
+			}
+		}
+
+		version := fmt.Sprintf("%d.%02d", next[0], next[1])
+		if len(next) > 2 {
+			version += fmt.Sprintf(".%d", next[2])
+		}
+		return version, nil
+	case "ordinal":
+		if latest == nil {
+			return "1", nil
+		}
+
+		cur, err := strconv.ParseUint(latest[0].Version, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("unable to parse latest version %v: %v", latest[0].Version, err)
+		}
+		return strconv.FormatUint(cur+1, 10), nil
+	default:
+		return "", fmt.Errorf("unable to compute the next version for versioning scheme %v, pass the version", opts.Versioning)
+	}
+
+	if latest == nil {
+		return "0.1.0", nil
+	}
+
+	cur, err := semver.NewVersion(latest[0].Version)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse latest version %v: %v", latest[0].Version, err)
+	}
+
+	next := cur.IncPatch()
+	for _, e := range entries {
+		switch {
+		case e.Breaking:
+			return cur.IncMajor().String(), nil
+		case e.Type == "Change" || e.Type == "Enhancement":
+			next = cur.IncMinor()
+		}
+	}
+
+	return next.String(), nil
+}
+
+// bumpFiles applies all bumpRules for version.
+func bumpFiles(version string) error {
+	for _, rule := range bumpRules {
+		buf, err := ioutil.ReadFile(rule.file)
+		if err != nil {
+			return fmt.Errorf("bump: %v", err)
+		}
+
+		var replace bytes.Buffer
+		err = rule.replace.Execute(&replace, struct{ Version string }{version})
+		if err != nil {
+			return fmt.Errorf("bump %v: %v", rule.file, err)
+		}
+
+		var result []byte
+		if rule.pattern == nil {
+			result = append(replace.Bytes(), '\n')
+		} else {
+			if !rule.pattern.Match(buf) {
+				return fmt.Errorf("bump %v: pattern %q not found", rule.file, rule.pattern)
+			}
+			result = rule.pattern.ReplaceAll(buf, replace.Bytes())
+		}
+
+		if bytes.Equal(result, buf) {
+			continue
+		}
+
+		fi, err := os.Stat(rule.file)
+		if err != nil {
+			return fmt.Errorf("bump: %v", err)
+		}
+
+		err = ioutil.WriteFile(rule.file, result, fi.Mode())
+		if err != nil {
+			return fmt.Errorf("bump: %v", err)
+		}
+
+		fmt.Printf("updated %v to %v\n", rule.file, version)
+	}
+
+	return nil
+}
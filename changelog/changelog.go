@@ -0,0 +1,104 @@
+// Package changelog reads the changelog directories of calens: it lists the
+// releases, parses and validates the entry files and classifies their links.
+// The calens command is built on it, other programs can use it to process
+// the entries without rendering a changelog.
+package changelog
+
+import "strings"
+
+// Options configures how releases and entries are read. The fields
+// correspond to the options of calens with the same name, the zero value
+// reads the entries without any of the optional checks.
+type Options struct {
+	// Recursive also reads entry files in subdirectories of the release
+	// directories.
+	Recursive bool
+
+	// Latin1 reads entry files which are not valid UTF-8 as Latin-1
+	// instead of rejecting them.
+	Latin1 bool
+
+	// Versioning is the name of the versioning scheme, see
+	// LookupVersionScheme.
+	Versioning string
+
+	// Unreleased is the pending bucket whose entries are read as the
+	// unreleased changes, "unreleased" if empty.
+	Unreleased string
+
+	// MergeDuplicates merges the entries of several directories for the
+	// same version instead of returning an error.
+	MergeDuplicates bool
+
+	// SortBy orders the entries of each type by "id" (the default),
+	// "title" or "file", see SortEntries.
+	SortBy string
+
+	MaxParagraphs    int
+	MaxBodyLength    int
+	MaxTitleLength   int
+	TitlePunctuation string
+	TitleCase        string
+	TitleCaseKeep    []string
+	RequireBody      []string
+
+	// GitLabURLs, BitbucketURLs, GerritURLs, RedmineURLs and JiraURL are
+	// the base URLs of self-hosted forges, see ForgeRules.
+	GitLabURLs    []string
+	BitbucketURLs []string
+	GerritURLs    []string
+	RedmineURLs   []string
+	JiraURL       string
+	JiraProjects  []string
+
+	// LinkRules is the table used by ClassifyURL, the first matching rule
+	// wins. If it is nil, the rules for the known forges are used.
+	LinkRules []LinkRule
+
+	// TitleRules are checked by Validate.
+	TitleRules []TitleRule
+
+	// Warn is called for each file or directory which is ignored, if set.
+	Warn func(path, reason string)
+
+	// Filter reports whether an entry file is read, all files are read if
+	// it is nil.
+	Filter func(filename string) bool
+
+	// DefaultRepo returns the GitHub repository (owner/name) that shorthand
+	// references like "#123" in the link block refer to. Without it, such
+	// references are not expanded.
+	DefaultRepo func() (string, error)
+
+	// Assign adds the entry files directly in the changelog dir to the
+	// releases read from it, e.g. according to the git history. Without
+	// it, these files are ignored.
+	Assign func(dir string, releases []Release) ([]Release, error)
+}
+
+// warn calls the Warn hook, if set.
+func (o *Options) warn(path, reason string) {
+	if o.Warn != nil {
+		o.Warn(path, reason)
+	}
+}
+
+// Capitalize returns a string with the first letter in upper case.
+func Capitalize(text string) string {
+	if text == "" {
+		return text
+	}
+
+	first, rest := text[0:1], text[1:]
+	return strings.ToUpper(first) + rest
+}
+
+// containsString reports whether list contains s.
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
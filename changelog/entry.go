@@ -0,0 +1,887 @@
+package changelog
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Entry describes a change.
+type Entry struct {
+	Type       string
+	TypeShort  string
+	TypeEmoji  string
+	Title      string
+	Paragraphs []string
+	URLs       []*url.URL
+	Issues     []string
+	IssueURLs  []*url.URL
+	PRs        []string
+	PRURLs     []*url.URL
+	OtherURLs  []*url.URL
+	PrimaryID  int64
+	PrimaryURL *url.URL
+
+	// References lists all links of the entry with their classification in
+	// a form which does not depend on the forge, see Reference.
+	References []Reference
+
+	// Advisories lists the IDs of the linked GitHub security advisories
+	// (e.g. GHSA-xxxx-xxxx-xxxx), AdvisoryURLs the links.
+	Advisories   []string
+	AdvisoryURLs []*url.URL
+
+	// Visibility is "internal" for entries which are only rendered with
+	// --internal, and empty for all other entries.
+	Visibility string
+
+	// Breaking is set for breaking changes, marked with an exclamation mark
+	// after the type (e.g. "Change!: ...") or in the front matter.
+	Breaking bool
+
+	// Draft is set for entries which are parsed and linted but not rendered,
+	// marked with the file name prefix "draft-" or in the front matter.
+	Draft bool
+
+	// Severity is set in the front matter.
+	Severity string
+
+	// Affected lists the ranges of versions affected by a security issue,
+	// set in the front matter, see AffectedRange.
+	Affected []AffectedRange
+
+	// Audience is the audience of the entry (e.g. "user" or "developer"),
+	// set with a line like "Audience: user" or in the front matter. Entries
+	// without an audience are rendered for all audiences.
+	Audience string
+
+	// Authors lists the names from the Co-authored-by trailers and the front
+	// matter of the entry as well as the users thanked in the text (like
+	// "Thanks to @user").
+	Authors []string
+
+	// Mentions lists the users mentioned anywhere in the text (like "@user"),
+	// in order and without duplicates.
+	Mentions []Mention
+
+	// CVEs lists the CVE IDs mentioned in Security entries.
+	CVEs []CVE
+
+	// Refs contains the IDs of the links in the custom categories defined in
+	// the links section of the config, by category. The links are also
+	// listed in OtherURLs.
+	Refs map[string][]string
+
+	// Component is the component given in the title line after the type,
+	// e.g. "backup" for "Bugfix(backup): Handle symlinks".
+	Component string
+
+	// Components lists the components of the entry: the component from the
+	// title line, the components from the front matter and those taken from
+	// the labels of the referenced issues and pull requests (see
+	// --label-prefix).
+	Components []string
+
+	// File is the name of the file the entry was read from.
+	File string
+
+	// Order is the number from the order prefix of the file name (like
+	// "01_"), 0 if there is none.
+	Order int
+}
+
+// Mention is a GitHub user mentioned in an entry, together with the link to
+// the profile.
+type Mention struct {
+	Name string
+	URL  string
+}
+
+// CVE is a CVE ID mentioned in an entry, together with links to the
+// vulnerability databases.
+type CVE struct {
+	ID       string
+	NVDURL   string
+	MITREURL string
+}
+
+// EntryTypePriority contains the list of valid types, order is priority in the changelog.
+var EntryTypePriority = map[string]int{
+	"Security":    1,
+	"Bugfix":      2,
+	"Change":      3,
+	"Enhancement": 4,
+}
+
+// EntryTypeAbbreviation contains the shortened entry types for the overview.
+var EntryTypeAbbreviation = map[string]string{
+	"Security":    "Sec",
+	"Bugfix":      "Fix",
+	"Change":      "Chg",
+	"Enhancement": "Enh",
+}
+
+// EntryTypeEmoji contains an emoji (or another decoration like a badge) for
+// each entry type, available to templates as .TypeEmoji.
+var EntryTypeEmoji = map[string]string{
+	"Security":    "🔒",
+	"Bugfix":      "🐛",
+	"Change":      "🔄",
+	"Enhancement": "✨",
+}
+
+// EntryTypeAliases maps aliases (in lower case) which are accepted as the
+// type prefix of an entry to the entry type, e.g. for contributors used to
+// conventional commit prefixes.
+var EntryTypeAliases = map[string]string{
+	"fix":  "Bugfix",
+	"bug":  "Bugfix",
+	"feat": "Enhancement",
+	"sec":  "Security",
+}
+
+// resolveTypeAlias returns the entry type for typ if it is an alias (see
+// EntryTypeAliases), or typ otherwise.
+func resolveTypeAlias(typ string) string {
+	if name, ok := EntryTypeAliases[strings.ToLower(typ)]; ok {
+		return name
+	}
+	return typ
+}
+
+// EntryTypes returns the names of all valid entry types, ordered by priority.
+func EntryTypes() []string {
+	var types []string
+	for typ := range EntryTypePriority {
+		types = append(types, typ)
+	}
+
+	sort.Slice(types, func(i, j int) bool {
+		if EntryTypePriority[types[i]] == EntryTypePriority[types[j]] {
+			return types[i] < types[j]
+		}
+		return EntryTypePriority[types[i]] < EntryTypePriority[types[j]]
+	})
+
+	return types
+}
+
+// SortEntries sorts list by the priority of the entry type (as defined in
+// EntryTypePriority). Entries of the same type are sorted by the order
+// prefix of the file name (like "01_"), then as set with SortBy. Otherwise,
+// the original order is kept.
+func (o *Options) SortEntries(list []Entry) {
+	sort.SliceStable(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.Type != b.Type {
+			return EntryTypePriority[a.Type] < EntryTypePriority[b.Type]
+		}
+
+		// entries with an order prefix come first
+		if a.Order != b.Order {
+			if a.Order == 0 || b.Order == 0 {
+				return b.Order == 0
+			}
+			return a.Order < b.Order
+		}
+
+		switch o.SortBy {
+		case "title":
+			return strings.ToLower(a.Title) < strings.ToLower(b.Title)
+		case "file":
+			// keep the order of the file names
+			return false
+		default:
+			return a.PrimaryID < b.PrimaryID
+		}
+	})
+}
+
+// Punctuation contains the characters that calens does not allow as the
+// last character in the title by default, see TitlePunctuation.
+const Punctuation = ".!?"
+
+// TitleRule requires the titles of all entries to match Pattern, Message is
+// reported for entries which don't.
+type TitleRule struct {
+	Pattern *regexp.Regexp
+	Message string
+}
+
+// Validate returns an error if the entry is invalid in any way. Problems with
+// the title line are returned as *ErrInvalidTitle or *ErrUnknownType.
+func (o *Options) Validate(e Entry) error {
+	if e.Type == "" {
+		return &ErrInvalidTitle{Line: 1, Reason: "entry title does not have a prefix, example: Bugfix: restore old behavior"}
+	}
+
+	if e.Title == "" {
+		return &ErrInvalidTitle{Line: 1, Reason: "entry does not have a title"}
+	}
+
+	if e.Visibility != "" && e.Visibility != "internal" {
+		return fmt.Errorf("invalid visibility %q, valid values: internal", e.Visibility)
+	}
+
+	for _, r := range e.Affected {
+		if r.Introduced == "" {
+			return errors.New("affected: no introduced version set")
+		}
+	}
+
+	err := checkLinks(e)
+	if err != nil {
+		return err
+	}
+
+	lastChar := e.Title[len(e.Title)-1]
+	if strings.ContainsAny(string(lastChar), o.TitlePunctuation) {
+		return &ErrInvalidTitle{Line: 1, Reason: fmt.Sprintf("title ends with punctuation, e.g. a character out of %q", o.TitlePunctuation)}
+	}
+
+	for _, rule := range o.TitleRules {
+		if !rule.Pattern.MatchString(e.Title) {
+			return &ErrInvalidTitle{Line: 1, Reason: rule.Message}
+		}
+	}
+
+	if _, ok := EntryTypePriority[e.Type]; !ok {
+		return &ErrUnknownType{Line: 1, Type: e.Type, Valid: EntryTypes()}
+	}
+
+	if o.MaxTitleLength > 0 && len(e.Type)+len(e.Title)+1 > o.MaxTitleLength {
+		return &ErrInvalidTitle{Line: 1, Reason: fmt.Sprintf("title is too long (max %d characters)", o.MaxTitleLength)}
+	}
+
+	return o.lintBody(e)
+}
+
+// RequiredLinks maps entry types to the kinds of links an entry of this type
+// needs at least one of: "issue", "pr", "advisory", "other" (any other link)
+// or "url" (any link at all). An empty list means that no link is required.
+// Types which are not listed need an issue or a pull request.
+var RequiredLinks = map[string][]string{}
+
+// checkLinks checks that the entry has the links required for its type.
+func checkLinks(e Entry) error {
+	kinds, ok := RequiredLinks[e.Type]
+	if !ok {
+		if e.PrimaryID == 0 {
+			return errors.New("primary issue ID not found")
+		}
+		return nil
+	}
+
+	if len(kinds) == 0 {
+		return nil
+	}
+
+	for _, kind := range kinds {
+		switch kind {
+		case "issue":
+			ok = len(e.Issues) > 0
+		case "pr":
+			ok = len(e.PRs) > 0
+		case "advisory":
+			ok = len(e.Advisories) > 0
+		case "other":
+			ok = len(e.OtherURLs) > 0
+		case "url":
+			ok = len(e.URLs) > 0
+		}
+
+		if ok {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("entries of type %v need a link to one of: %v", e.Type, strings.Join(kinds, ", "))
+}
+
+// lintBody checks the structure of the entry body against the configured
+// limits.
+func (o *Options) lintBody(e Entry) error {
+	if o.MaxParagraphs > 0 && len(e.Paragraphs) > o.MaxParagraphs {
+		return fmt.Errorf("entry has %d paragraphs (max %d)", len(e.Paragraphs), o.MaxParagraphs)
+	}
+
+	if o.MaxBodyLength > 0 {
+		length := 0
+		for _, par := range e.Paragraphs {
+			length += utf8.RuneCountInString(par)
+		}
+
+		if length > o.MaxBodyLength {
+			return fmt.Errorf("entry body is too long, %d characters (max %d)", length, o.MaxBodyLength)
+		}
+	}
+
+	for _, typ := range o.RequireBody {
+		if strings.EqualFold(typ, e.Type) && len(e.Paragraphs) == 0 {
+			return fmt.Errorf("entries of type %v need at least one paragraph describing the change", e.Type)
+		}
+	}
+
+	return nil
+}
+
+// scopeRegexp matches an entry type with a component like "Bugfix(backup)".
+var scopeRegexp = regexp.MustCompile(`^([^()]+)\(([^()]+)\)$`)
+
+// SplitTypePrefix splits the type prefix of a title line like
+// "Change(backup)!" into the type, the component and whether the marker for
+// breaking changes is set.
+func SplitTypePrefix(prefix string) (typ, component string, breaking bool) {
+	typ = strings.TrimSpace(prefix)
+	if strings.HasSuffix(typ, "!") {
+		typ, breaking = strings.TrimSuffix(typ, "!"), true
+	}
+
+	if scope := scopeRegexp.FindStringSubmatch(typ); scope != nil {
+		typ, component = scope[1], strings.TrimSpace(scope[2])
+	}
+
+	return typ, component, breaking
+}
+
+// coAuthorRegexp matches a Co-authored-by trailer, the first submatch is the
+// name without the email address.
+var coAuthorRegexp = regexp.MustCompile(`(?i)^co-authored-by:\s*(.*?)\s*(?:<[^>]*>)?$`)
+
+// thanksRegexp matches phrases like "Thanks to @user and @other", the
+// mentions are extracted with mentionRegexp.
+var thanksRegexp = regexp.MustCompile(`(?i)\bthanks?(?:\s+(?:go(?:es)?\s+)?to)?\s+@[\w-]+(?:(?:\s*,\s*|\s+and\s+|\s*&\s*)@[\w-]+)*`)
+
+// mentionRegexp matches a mention of a user like "@user".
+var mentionRegexp = regexp.MustCompile(`@[\w-]+`)
+
+// userMentionRegexp matches a mention of a GitHub user in the text, the first
+// submatch is the user name. Email addresses and paths are not matched.
+var userMentionRegexp = regexp.MustCompile(`(?:^|[^\w.@/-])@([A-Za-z0-9][A-Za-z0-9-]*)`)
+
+// findMentions returns the users mentioned in the paragraphs, in order and
+// without duplicates (ignoring case).
+func findMentions(paragraphs []string) (mentions []Mention) {
+	seen := make(map[string]bool)
+	for _, par := range paragraphs {
+		for _, m := range userMentionRegexp.FindAllStringSubmatch(par, -1) {
+			name := m[1]
+			if seen[strings.ToLower(name)] {
+				continue
+			}
+			seen[strings.ToLower(name)] = true
+
+			mentions = append(mentions, Mention{
+				Name: name,
+				URL:  "https://github.com/" + name,
+			})
+		}
+	}
+	return mentions
+}
+
+// listItemRegexp matches the marker of a Markdown list item at the start of
+// a line, like "- ", "* " or "1. ".
+var listItemRegexp = regexp.MustCompile(`^(?:[-*+]|\d+[.)])\s+`)
+
+// isBlockLine reports whether line is part of a blockquote or a table, which
+// are kept on lines of their own.
+func isBlockLine(line string) bool {
+	return strings.HasPrefix(line, ">") || strings.HasPrefix(line, "|")
+}
+
+// draftPrefix marks entry files as drafts, see Entry.Draft.
+const draftPrefix = "draft-"
+
+// orderPrefixRegexp matches a numeric prefix like "01_" of an entry file
+// name, which pins the order of the entry within its type.
+var orderPrefixRegexp = regexp.MustCompile(`^(\d+)_`)
+
+// splitOrderPrefix returns the number from the order prefix of the entry
+// file name (0 if there is none) and the name without the prefix.
+func splitOrderPrefix(name string) (order int, rest string) {
+	m := orderPrefixRegexp.FindStringSubmatch(name)
+	if m == nil {
+		return 0, name
+	}
+
+	order, err := strconv.Atoi(m[1])
+	if err != nil || order == 0 {
+		return 0, name
+	}
+
+	return order, name[len(m[0]):]
+}
+
+// visibilityRegexp matches the line setting the visibility of an entry.
+var visibilityRegexp = regexp.MustCompile(`(?i)^visibility:\s*(\S+)$`)
+
+// audienceRegexp matches the line setting the audience of an entry.
+var audienceRegexp = regexp.MustCompile(`(?i)^audience:\s*(\S+)$`)
+
+// ParseFile reads and validates the entry in filename.
+func (o *Options) ParseFile(filename string) (e Entry, err error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return Entry{}, fmt.Errorf("unable to open %v: %v", filename, err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	return o.ParseEntry(filename, f)
+}
+
+// utf8BOM is the byte order mark some editors on Windows write at the start
+// of UTF-8 files.
+var utf8BOM = []byte("\ufeff")
+
+// NormalizeText strips a UTF-8 byte order mark from buf and converts CRLF
+// and CR line endings to LF.
+func NormalizeText(buf []byte) []byte {
+	buf = bytes.TrimPrefix(buf, utf8BOM)
+	buf = bytes.Replace(buf, []byte("\r\n"), []byte("\n"), -1)
+	return bytes.Replace(buf, []byte("\r"), []byte("\n"), -1)
+}
+
+// invalidEncoding returns an *ErrInvalidEncoding for the first invalid
+// UTF-8 sequence in buf.
+func invalidEncoding(filename string, buf []byte) error {
+	err := &ErrInvalidEncoding{File: filename, Line: 1}
+	for err.Offset < len(buf) {
+		r, size := utf8.DecodeRune(buf[err.Offset:])
+		if r == utf8.RuneError && size <= 1 {
+			break
+		}
+		if r == '\n' {
+			err.Line++
+		}
+		err.Offset += size
+	}
+	return err
+}
+
+// latin1ToUTF8 converts buf from Latin-1 (ISO 8859-1) to UTF-8.
+func latin1ToUTF8(buf []byte) []byte {
+	runes := make([]rune, len(buf))
+	for i, b := range buf {
+		runes[i] = rune(b)
+	}
+	return []byte(string(runes))
+}
+
+// paragraphBuilder splits the lines of a text into paragraphs. Paragraphs are
+// separated by empty lines, their lines are joined. Verbatim sections (in
+// ```) are kept as they are, list items, blockquotes and table rows are kept
+// on lines of their own.
+type paragraphBuilder struct {
+	paragraphs []string
+	sect       string
+	verbatim   bool // inside verbatim section
+}
+
+// add adds the next line of the text.
+func (b *paragraphBuilder) add(line string) {
+	trimmedText := strings.TrimSpace(line)
+
+	if !b.verbatim && strings.HasPrefix(trimmedText, "```") {
+		// start new paragraph
+		b.finish()
+		b.sect = trimmedText
+		b.verbatim = true
+		return
+	}
+
+	// ignore new lines inside verbatim section
+	if !b.verbatim && trimmedText == "" {
+		b.finish()
+		return
+	}
+
+	if b.verbatim {
+		if b.sect != "" {
+			b.sect += "\n"
+		}
+		b.sect += line
+	} else {
+		switch {
+		case b.sect == "":
+		case listItemRegexp.MatchString(trimmedText):
+			// keep list items on lines of their own, including the
+			// indentation for nested lists
+			b.sect += "\n" + strings.Repeat(" ", len(line)-len(strings.TrimLeft(line, " ")))
+		case isBlockLine(trimmedText) || strings.HasPrefix(b.sect[strings.LastIndex(b.sect, "\n")+1:], "|"):
+			// keep blockquotes and table rows on lines of their own,
+			// lines following a blockquote continue it
+			b.sect += "\n"
+		default:
+			b.sect += " "
+		}
+		b.sect += trimmedText
+	}
+
+	if b.verbatim && trimmedText == "```" {
+		b.verbatim = false
+	}
+}
+
+// finish ends the current paragraph and returns all paragraphs.
+func (b *paragraphBuilder) finish() []string {
+	if b.sect != "" {
+		b.paragraphs = append(b.paragraphs, b.sect)
+	}
+	b.sect = ""
+
+	return b.paragraphs
+}
+
+// ParseEntry reads and validates the entry from rd, filename is used in
+// error messages. Byte order marks and Windows line endings are accepted.
+// Files which are not valid UTF-8 are rejected with *ErrInvalidEncoding, or
+// read as Latin-1 with Latin1.
+func (o *Options) ParseEntry(filename string, rd io.Reader) (e Entry, err error) {
+	buf, err := ioutil.ReadAll(rd)
+	if err != nil {
+		return Entry{}, fmt.Errorf("unable to read %v: %v", filename, err)
+	}
+
+	if !utf8.Valid(buf) {
+		if !o.Latin1 {
+			return Entry{}, invalidEncoding(filename, buf)
+		}
+		buf = latin1ToUTF8(buf)
+	}
+
+	sc := bufio.NewScanner(bytes.NewReader(NormalizeText(buf)))
+	if !sc.Scan() {
+		return Entry{}, fmt.Errorf("unable to read first line from %v", filename)
+	}
+
+	titleLine := 1
+	if strings.TrimSpace(sc.Text()) == frontMatterDelimiter {
+		var block []string
+		for {
+			if !sc.Scan() {
+				return Entry{}, fmt.Errorf("file %v: front matter is not terminated by %q", filename, frontMatterDelimiter)
+			}
+			titleLine++
+
+			if strings.TrimSpace(sc.Text()) == frontMatterDelimiter {
+				break
+			}
+			block = append(block, sc.Text())
+		}
+
+		fm, err := parseFrontMatter(block)
+		if err != nil {
+			return Entry{}, fmt.Errorf("file %v: invalid front matter: %v", filename, err)
+		}
+		fm.apply(&e)
+
+		if !sc.Scan() {
+			return Entry{}, fmt.Errorf("unable to read title line from %v", filename)
+		}
+		titleLine++
+	}
+
+	title := sc.Text()
+	data := strings.SplitN(title, ": ", 2)
+	if len(data) == 2 {
+		var breaking bool
+		e.Type, e.Component, breaking = SplitTypePrefix(Capitalize(data[0]))
+		e.Breaking = e.Breaking || breaking
+		if e.Component != "" && !containsString(e.Components, e.Component) {
+			e.Components = append(e.Components, e.Component)
+		}
+		e.Type = resolveTypeAlias(e.Type)
+		e.TypeShort = EntryTypeAbbreviation[e.Type]
+		e.TypeEmoji = EntryTypeEmoji[e.Type]
+		data = data[1:]
+	}
+	e.Title = strings.TrimSpace(data[0])
+
+	var body paragraphBuilder
+	titleCont := true // indented lines directly after the title continue it
+	for sc.Scan() {
+		if sc.Err() != nil {
+			return Entry{}, fmt.Errorf("unable to read lines from %v: %v", filename, sc.Err())
+		}
+
+		trimmedText := strings.TrimSpace(sc.Text())
+		if titleCont {
+			if trimmedText != "" && strings.TrimLeft(sc.Text(), " \t") != sc.Text() {
+				e.Title += " " + trimmedText
+				continue
+			}
+			titleCont = false
+		}
+
+		if !body.verbatim {
+			if data := coAuthorRegexp.FindStringSubmatch(trimmedText); data != nil {
+				e.Authors = append(e.Authors, data[1])
+				continue
+			}
+
+			if data := visibilityRegexp.FindStringSubmatch(trimmedText); data != nil {
+				e.Visibility = strings.ToLower(data[1])
+				continue
+			}
+
+			if data := audienceRegexp.FindStringSubmatch(trimmedText); data != nil {
+				e.Audience = strings.ToLower(data[1])
+				continue
+			}
+		}
+
+		body.add(sc.Text())
+	}
+
+	e.Title = o.applyTitleCase(Capitalize(e.Title))
+
+	if body.verbatim {
+		return Entry{}, fmt.Errorf("unmatched verbatim tag in %v", filename)
+	}
+
+	text := body.finish()
+	if len(text) > 0 {
+		links := text[len(text)-1]
+		text = text[:len(text)-1]
+
+		sc = bufio.NewScanner(strings.NewReader(links))
+		sc.Split(bufio.ScanWords)
+		for sc.Scan() {
+			link := sc.Text()
+			if expanded, ok := o.expandReference(link); ok {
+				link = expanded
+			}
+
+			url, err := url.Parse(link)
+			if err != nil {
+				return Entry{}, fmt.Errorf("file %v: unable to parse url %q: %v", filename, link, err)
+			}
+			e.URLs = append(e.URLs, url)
+		}
+	}
+
+	// add references mentioned in the title which are not in the link block
+	for _, link := range o.textReferences(e.Title) {
+		url, err := url.Parse(link)
+		if err != nil {
+			return Entry{}, fmt.Errorf("file %v: unable to parse url %q: %v", filename, link, err)
+		}
+
+		found := false
+		for _, u := range e.URLs {
+			found = found || u.String() == url.String()
+		}
+
+		if !found {
+			e.URLs = append(e.URLs, url)
+		}
+	}
+
+	for _, par := range text {
+		e.Paragraphs = append(e.Paragraphs, Capitalize(strings.TrimSpace(par)))
+	}
+
+	err = o.extractIDs(e.URLs, &e)
+	if err != nil {
+		return Entry{}, fmt.Errorf("file %v: %v", filename, err)
+	}
+
+	for _, par := range e.Paragraphs {
+		for _, thanks := range thanksRegexp.FindAllString(par, -1) {
+			e.Authors = append(e.Authors, mentionRegexp.FindAllString(thanks, -1)...)
+		}
+	}
+	e.Mentions = findMentions(e.Paragraphs)
+
+	if e.Type == "Security" {
+		for _, id := range findCVEs(e) {
+			e.CVEs = append(e.CVEs, CVE{
+				ID:       id,
+				NVDURL:   "https://nvd.nist.gov/vuln/detail/" + id,
+				MITREURL: "https://www.cve.org/CVERecord?id=" + id,
+			})
+		}
+	}
+
+	err = o.Validate(e)
+	switch err := err.(type) {
+	case nil:
+	case *ErrInvalidTitle:
+		err.File, err.Line = filename, titleLine
+		return Entry{}, err
+	case *ErrUnknownType:
+		err.File, err.Line = filename, titleLine
+		return Entry{}, err
+	default:
+		return Entry{}, fmt.Errorf("file %v: %v", filename, err)
+	}
+
+	e.File = filename
+	var name string
+	e.Order, name = splitOrderPrefix(filepath.Base(filename))
+	if strings.HasPrefix(name, draftPrefix) {
+		e.Draft = true
+	}
+
+	return e, nil
+}
+
+// parseID parses the number of an issue or pull request ID.
+func parseID(str string) (int64, error) {
+	val, err := strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unable to parse issue/PR ID %q: %v", str, err)
+	}
+	return val, nil
+}
+
+// extractIDs extracts all issue and pull request IDs from the urls and adds
+// all urls to the references of e.
+func (o *Options) extractIDs(urls []*url.URL, e *Entry) (err error) {
+	for _, url := range urls {
+		kind, id := o.ClassifyURL(url)
+		e.References = append(e.References, newReference(url, kind, id))
+
+		switch kind {
+		case kindIssue:
+			e.Issues = append(e.Issues, id)
+			e.IssueURLs = append(e.IssueURLs, url)
+
+			if e.PrimaryID == 0 {
+				e.PrimaryID, err = parseID(numericID(id))
+				if err != nil {
+					return err
+				}
+				e.PrimaryURL = url
+			}
+		case kindPullRequest:
+			e.PRs = append(e.PRs, id)
+			e.PRURLs = append(e.PRURLs, url)
+
+			if e.PrimaryID == 0 {
+				e.PrimaryID, err = parseID(numericID(id))
+				if err != nil {
+					return err
+				}
+				e.PrimaryURL = url
+			}
+		case kindAdvisory:
+			e.Advisories = append(e.Advisories, id)
+			e.AdvisoryURLs = append(e.AdvisoryURLs, url)
+		case "":
+			e.OtherURLs = append(e.OtherURLs, url)
+		default:
+			if e.Refs == nil {
+				e.Refs = make(map[string][]string)
+			}
+			e.Refs[kind] = append(e.Refs[kind], id)
+			e.OtherURLs = append(e.OtherURLs, url)
+		}
+	}
+
+	return nil
+}
+
+// WalkEntries calls fn for each entry of all releases in dir, one entry at a
+// time and without keeping the entries in memory. The releases are visited
+// in the order returned by ReadReleases, the entries in the order of the
+// files. Walking stops at the first error returned by fn or by parsing an
+// entry, which is then returned.
+func (o *Options) WalkEntries(dir string, fn func(Release, Entry) error) error {
+	releases, err := o.ReadReleases(dir)
+	if err != nil {
+		return err
+	}
+
+	return o.WalkReleases(releases, fn)
+}
+
+// WalkReleases calls fn for each entry of releases, see WalkEntries. Only
+// the files accepted by the Filter hook are read.
+func (o *Options) WalkReleases(releases []Release, fn func(Release, Entry) error) error {
+	for _, rel := range releases {
+		list := rel.Files
+		if list == nil {
+			var err error
+			list, err = o.Files(rel.Path)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, file := range list {
+			if o.Filter != nil && !o.Filter(file) {
+				continue
+			}
+
+			e, err := o.ParseFile(file)
+			if err != nil {
+				return err
+			}
+
+			err = fn(rel, e)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// ReadEntries returns the entries of releases by version, sorted with
+// SortEntries.
+func (o *Options) ReadEntries(releases []Release) (map[string][]Entry, error) {
+	entries := make(map[string][]Entry)
+
+	err := o.WalkReleases(releases, func(rel Release, e Entry) error {
+		entries[rel.Version] = append(entries[rel.Version], e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, list := range entries {
+		o.SortEntries(list)
+	}
+
+	return entries, nil
+}
+
+// cveRegex matches CVE IDs like "CVE-2024-1234".
+var cveRegex = regexp.MustCompile(`\bCVE-\d{4}-\d{4,}\b`)
+
+// findCVEs returns all distinct CVE IDs mentioned in the entry.
+func findCVEs(e Entry) (ids []string) {
+	text := []string{e.Title}
+	text = append(text, e.Paragraphs...)
+	for _, u := range e.URLs {
+		text = append(text, u.String())
+	}
+
+	seen := make(map[string]struct{})
+	for _, id := range cveRegex.FindAllString(strings.Join(text, "\n"), -1) {
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+		ids = append(ids, id)
+	}
+
+	return ids
+}
@@ -0,0 +1,105 @@
+package changelog
+
+import (
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+func TestFindCVEs(t *testing.T) {
+	e := Entry{
+		Title:      "Fix CVE-2024-12345 in the REST backend",
+		Paragraphs: []string{"Also fixes CVE-2023-9999 and CVE-2024-12345."},
+		URLs:       []*url.URL{parseURL(t, "https://nvd.nist.gov/vuln/detail/CVE-2024-0001")},
+	}
+
+	want := []string{"CVE-2024-12345", "CVE-2023-9999", "CVE-2024-0001"}
+	if diff := deep.Equal(want, findCVEs(e)); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestSplitOrderPrefix(t *testing.T) {
+	var tests = []struct {
+		name  string
+		order int
+		rest  string
+	}{
+		{"issue-1234", 0, "issue-1234"},
+		{"01_issue-1234", 1, "issue-1234"},
+		{"12_draft-foo", 12, "draft-foo"},
+		{"00_issue-1", 0, "00_issue-1"},
+		{"1234", 0, "1234"},
+	}
+
+	for _, test := range tests {
+		order, rest := splitOrderPrefix(test.name)
+		if order != test.order || rest != test.rest {
+			t.Errorf("%v: want %d %q, got %d %q", test.name, test.order, test.rest, order, rest)
+		}
+	}
+
+	filename := filepath.Join(t.TempDir(), "03_draft-issue-1")
+	err := ioutil.WriteFile(filename, []byte("Bugfix: Fix crash\n\nhttps://github.com/restic/restic/issues/1\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o := &Options{}
+	e, err := o.ParseFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Order != 3 || !e.Draft {
+		t.Errorf("wrong entry: order %d, draft %v", e.Order, e.Draft)
+	}
+}
+
+func TestFindMentions(t *testing.T) {
+	paragraphs := []string{
+		"Reported by @alice, fixed with help from @Bob-Smith (see @alice's comment).",
+		"Mail jane@example.com or see https://example.com/@carol and @bob-smith.",
+	}
+
+	want := []Mention{
+		{Name: "alice", URL: "https://github.com/alice"},
+		{Name: "Bob-Smith", URL: "https://github.com/Bob-Smith"},
+	}
+	if diff := deep.Equal(want, findMentions(paragraphs)); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestLintBody(t *testing.T) {
+	e := Entry{
+		Type:       "Change",
+		Title:      "Foo",
+		Paragraphs: []string{"First paragraph.", "Second paragraph."},
+	}
+
+	if err := (&Options{MaxParagraphs: 2}).lintBody(e); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err := (&Options{MaxParagraphs: 1}).lintBody(e); err == nil {
+		t.Error("expected error for too many paragraphs")
+	}
+
+	if err := (&Options{MaxBodyLength: 20}).lintBody(e); err == nil {
+		t.Error("expected error for too long body")
+	}
+
+	o := &Options{RequireBody: []string{"Change", "Security"}}
+	e.Paragraphs = nil
+	if err := o.lintBody(e); err == nil {
+		t.Error("expected error for missing body")
+	}
+
+	e.Type = "Bugfix"
+	if err := o.lintBody(e); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
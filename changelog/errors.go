@@ -0,0 +1,78 @@
+package changelog
+
+import (
+	"fmt"
+	"strings"
+)
+
+// position returns the prefix "file:line: " for error messages, the line is
+// omitted if it is zero and the prefix is empty if file is not set.
+func position(file string, line int) string {
+	switch {
+	case file == "":
+		return ""
+	case line == 0:
+		return file + ": "
+	default:
+		return fmt.Sprintf("%v:%d: ", file, line)
+	}
+}
+
+// ErrInvalidTitle is returned for an entry with an invalid title line.
+type ErrInvalidTitle struct {
+	File string
+	Line int
+
+	// Reason describes what is wrong with the title.
+	Reason string
+}
+
+func (err *ErrInvalidTitle) Error() string {
+	return position(err.File, err.Line) + err.Reason
+}
+
+// ErrUnknownType is returned for an entry with an unknown type.
+type ErrUnknownType struct {
+	File string
+	Line int
+
+	Type string
+
+	// Valid lists the valid entry types.
+	Valid []string
+}
+
+func (err *ErrUnknownType) Error() string {
+	return fmt.Sprintf("%ventry type %q is invalid, valid types: %v", position(err.File, err.Line), err.Type, strings.Join(err.Valid, ", "))
+}
+
+// ErrBadReleaseDir is returned for a release directory which cannot be used,
+// either because the name is invalid or because the directory for a version
+// listed in the releases file (in File at Line) does not exist.
+type ErrBadReleaseDir struct {
+	File string
+	Line int
+
+	// Dir is the name of the directory.
+	Dir string
+
+	// Reason describes what is wrong with the directory.
+	Reason string
+}
+
+func (err *ErrBadReleaseDir) Error() string {
+	return fmt.Sprintf("%vrelease dir %v: %v", position(err.File, err.Line), err.Dir, err.Reason)
+}
+
+// ErrInvalidEncoding is returned for an entry file which is not valid UTF-8.
+type ErrInvalidEncoding struct {
+	File string
+	Line int
+
+	// Offset is the offset of the first invalid byte in the file.
+	Offset int
+}
+
+func (err *ErrInvalidEncoding) Error() string {
+	return fmt.Sprintf("%vinvalid UTF-8 at byte offset %d, save the file as UTF-8 or use --latin1", position(err.File, err.Line), err.Offset)
+}
@@ -0,0 +1,25 @@
+package changelog
+
+import "testing"
+
+func TestErrorMessages(t *testing.T) {
+	var tests = []struct {
+		err  error
+		want string
+	}{
+		{&ErrInvalidTitle{File: "changelog/unreleased/issue-1", Line: 3, Reason: "entry does not have a title"},
+			"changelog/unreleased/issue-1:3: entry does not have a title"},
+		{&ErrUnknownType{Line: 1, Type: "Bug", Valid: []string{"Bugfix", "Change"}},
+			`entry type "Bug" is invalid, valid types: Bugfix, Change`},
+		{&ErrBadReleaseDir{File: "changelog/releases", Dir: "1.0.0", Reason: "does not exist"},
+			"changelog/releases: release dir 1.0.0: does not exist"},
+		{&ErrInvalidEncoding{File: "issue-2", Line: 2, Offset: 17},
+			"issue-2:2: invalid UTF-8 at byte offset 17, save the file as UTF-8 or use --latin1"},
+	}
+
+	for _, test := range tests {
+		if msg := test.err.Error(); msg != test.want {
+			t.Errorf("want message %q, got %q", test.want, msg)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package changelog_test
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/restic/calens/changelog"
+)
+
+// buildScheme accepts build numbers like "b42".
+type buildScheme struct{}
+
+func (buildScheme) Parse(s string) (string, error) {
+	if len(s) < 2 || s[0] != 'b' {
+		return "", fmt.Errorf("%q is not a build number", s)
+	}
+	n, err := strconv.Atoi(s[1:])
+	if err != nil {
+		return "", fmt.Errorf("%q is not a build number", s)
+	}
+	return "b" + strconv.Itoa(n), nil
+}
+
+func (buildScheme) Compare(a, b string) int {
+	x, _ := strconv.Atoi(a[1:])
+	y, _ := strconv.Atoi(b[1:])
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func TestRegisterVersionScheme(t *testing.T) {
+	if _, err := changelog.LookupVersionScheme("build"); err == nil {
+		t.Fatal("expected error for unknown scheme")
+	}
+
+	changelog.RegisterVersionScheme("build", buildScheme{})
+	defer changelog.RegisterVersionScheme("build", nil)
+
+	scheme, err := changelog.LookupVersionScheme("build")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := scheme.Parse("b007")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != "b7" {
+		t.Errorf("wrong version %v", version)
+	}
+
+	if scheme.Compare("b7", "b10") != -1 {
+		t.Errorf("b7 not ordered before b10")
+	}
+}
+
+// writeFiles creates the files in dir, the names are slash-separated paths.
+func writeFiles(t testing.TB, dir string, files map[string]string) {
+	for name, data := range files {
+		filename := filepath.Join(dir, filepath.FromSlash(name))
+		err := os.MkdirAll(filepath.Dir(filename), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(filename, []byte(data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWalkEntries(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"0.9.0_2023-01-01/issue-1": "Bugfix: Fix crash\n\nhttps://github.com/restic/restic/issues/1\n",
+		"1.0.0_2024-01-01/issue-2": "Enhancement: Add zstd\n\nhttps://gitlab.com/group/project/-/merge_requests/2\n",
+		"1.0.0_2024-01-01/issue-3": "Bugfix: Fix backup\n\nhttps://github.com/restic/restic/pull/3\n",
+		"unreleased/issue-4":       "Change: Drop Go 1.14\n\nhttps://github.com/restic/restic/issues/4\n",
+	})
+
+	var o changelog.Options
+	var got []string
+	err := o.WalkEntries(dir, func(rel changelog.Release, e changelog.Entry) error {
+		got = append(got, fmt.Sprintf("%v %v %v", rel.Version, e.Type, e.PrimaryID))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"unreleased Change 4", "1.0.0 Enhancement 2", "1.0.0 Bugfix 3", "0.9.0 Bugfix 1"}
+	if fmt.Sprint(want) != fmt.Sprint(got) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err = o.WalkEntries(dir, func(changelog.Release, changelog.Entry) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Errorf("walk not stopped, err %v after %d calls", err, calls)
+	}
+
+	writeFiles(t, dir, map[string]string{
+		"unreleased/issue-5": "Feature: Add rclone\n\nhttps://github.com/restic/restic/issues/5\n",
+	})
+
+	err = o.WalkEntries(dir, func(changelog.Release, changelog.Entry) error { return nil })
+	var e *changelog.ErrUnknownType
+	if !errors.As(err, &e) || e.Type != "Feature" || filepath.Base(e.File) != "issue-5" {
+		t.Errorf("wrong error %v", err)
+	}
+}
@@ -0,0 +1,268 @@
+package changelog
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// The kinds of URLs recognized by ClassifyURL.
+const (
+	kindIssue       = "issue"
+	kindPullRequest = "pr"
+	kindAdvisory    = "advisory"
+)
+
+// The patterns for the paths of issues, pull requests (or merge requests)
+// and security advisories on the known forges, see ForgeRules. The first
+// submatch is the ID.
+const (
+	githubIssuePath       = `/.*/.*/issues/(\d+)`
+	githubPullRequestPath = `/.*/.*/pull/(\d+)`
+	githubAdvisoryPath    = `/[^/]+/[^/]+/security/advisories/(GHSA(?:-[0-9a-z]{4}){3})$`
+
+	gitlabIssuePath        = `/.+/-/issues/(\d+)`
+	gitlabMergeRequestPath = `/.+/-/merge_requests/(\d+)`
+
+	bitbucketIssuePath       = `/.+/issues/(\d+)`
+	bitbucketPullRequestPath = `/.+/pull-requests/(\d+)`
+
+	azureWorkItemPath    = `/[^/]+/[^/]+/_workitems/edit/(\d+)`
+	azurePullRequestPath = `/[^/]+/[^/]+/_git/[^/]+/pullrequest/(\d+)`
+
+	gerritChangePath = `(?:/[^/]+)*/c/.+/\+/(\d+)`
+
+	sourcehutTicketPath = `/~[^/]+/[^/]+/(\d+)`
+	sourcehutPatchPath  = `/~[^/]+/[^/]+/patches/(\d+)`
+
+	redmineIssuePath = `(?:/.*)?/issues/(\d+)$`
+
+	jiraIssuePath = `(?:/.*)?/browse/([A-Z][A-Z0-9_]+-\d+)$`
+)
+
+var (
+	shorthandRegexp = regexp.MustCompile(`^(?:#|GH-)(\d+)$`)
+
+	jiraKeyRegexp  = regexp.MustCompile(`^([A-Z][A-Z0-9_]+)-\d+$`)
+	jiraTextRegexp = regexp.MustCompile(`(?:^|[^\w-])([A-Z][A-Z0-9_]+-\d+)(?:$|[^\w-])`)
+)
+
+// LinkRule classifies the URLs matching Pattern as Kind, see
+// Options.LinkRules. The pattern is matched against the host (in lower case)
+// and path of the URL, the first submatch is the ID.
+type LinkRule struct {
+	Pattern *regexp.Regexp
+	Kind    string
+}
+
+// forgeRule returns the rule classifying the URLs below base which match path
+// as kind. Both base and path are regexps, base matches the host and an
+// optional path prefix.
+func forgeRule(base, path, kind string) LinkRule {
+	return LinkRule{Pattern: regexp.MustCompile("^" + base + path), Kind: kind}
+}
+
+// baseURLPattern returns the pattern for forgeRule matching the URLs below
+// base, ignoring the scheme.
+func baseURLPattern(base string) (string, error) {
+	b, err := url.Parse(base)
+	if err != nil || b.Host == "" {
+		return "", fmt.Errorf("invalid base URL %q", base)
+	}
+
+	return regexp.QuoteMeta(strings.ToLower(b.Host) + strings.TrimSuffix(b.Path, "/")), nil
+}
+
+// knownForgeRules returns the rules for the forges which are always
+// recognized.
+func knownForgeRules() []LinkRule {
+	return []LinkRule{
+		forgeRule(`github\.com`, githubIssuePath, kindIssue),
+		forgeRule(`github\.com`, githubPullRequestPath, kindPullRequest),
+		forgeRule(`github\.com`, githubAdvisoryPath, kindAdvisory),
+		forgeRule(`gitlab\.com`, gitlabIssuePath, kindIssue),
+		forgeRule(`gitlab\.com`, gitlabMergeRequestPath, kindPullRequest),
+		forgeRule(`bitbucket\.org`, bitbucketIssuePath, kindIssue),
+		forgeRule(`bitbucket\.org`, bitbucketPullRequestPath, kindPullRequest),
+		forgeRule(`dev\.azure\.com`, azureWorkItemPath, kindIssue),
+		forgeRule(`dev\.azure\.com`, azurePullRequestPath, kindPullRequest),
+		forgeRule(`todo\.sr\.ht`, sourcehutTicketPath, kindIssue),
+		forgeRule(`lists\.sr\.ht`, sourcehutPatchPath, kindPullRequest),
+		forgeRule(`[^/]*-review\.googlesource\.com`, gerritChangePath, kindPullRequest),
+	}
+}
+
+// defaultLinkRules is used by ClassifyURL if Options.LinkRules is nil.
+var defaultLinkRules = knownForgeRules()
+
+// ForgeRules returns the rules for all known forges and the self-hosted
+// forges configured in o.
+func (o *Options) ForgeRules() ([]LinkRule, error) {
+	list := knownForgeRules()
+
+	for _, forge := range []struct {
+		bases []string
+		paths map[string]string
+	}{
+		{o.GitLabURLs, map[string]string{kindIssue: gitlabIssuePath, kindPullRequest: gitlabMergeRequestPath}},
+		{o.BitbucketURLs, map[string]string{kindIssue: bitbucketIssuePath, kindPullRequest: bitbucketPullRequestPath}},
+		{o.GerritURLs, map[string]string{kindPullRequest: gerritChangePath}},
+		{o.RedmineURLs, map[string]string{kindIssue: redmineIssuePath}},
+	} {
+		for _, base := range forge.bases {
+			pattern, err := baseURLPattern(base)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, kind := range []string{kindIssue, kindPullRequest} {
+				if path, ok := forge.paths[kind]; ok {
+					list = append(list, forgeRule(pattern, path, kind))
+				}
+			}
+		}
+	}
+
+	if o.JiraURL != "" {
+		pattern, err := baseURLPattern(o.JiraURL)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, forgeRule(pattern, jiraIssuePath, kindIssue))
+	}
+
+	return list, nil
+}
+
+// isJiraKey reports whether s is a Jira issue key like "PROJ-1234" for one
+// of JiraProjects (or any project if none is set).
+func (o *Options) isJiraKey(s string) bool {
+	if o.JiraURL == "" {
+		return false
+	}
+
+	data := jiraKeyRegexp.FindStringSubmatch(s)
+	if data == nil {
+		return false
+	}
+
+	if len(o.JiraProjects) == 0 {
+		return true
+	}
+
+	for _, project := range o.JiraProjects {
+		if project == data[1] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// jiraURL returns the URL for the Jira issue key.
+func (o *Options) jiraURL(key string) string {
+	return strings.TrimSuffix(o.JiraURL, "/") + "/browse/" + key
+}
+
+// expandReference returns the URL for a reference in the link block which is
+// not a URL itself, like a Jira issue key or a shorthand reference like
+// "#1234" or "GH-1234" to an issue in the repository returned by the
+// DefaultRepo hook.
+func (o *Options) expandReference(ref string) (string, bool) {
+	if data := shorthandRegexp.FindStringSubmatch(ref); data != nil && o.DefaultRepo != nil {
+		if repo, err := o.DefaultRepo(); err == nil {
+			return fmt.Sprintf("https://github.com/%v/issues/%v", repo, data[1]), true
+		}
+	}
+
+	if o.isJiraKey(ref) {
+		return o.jiraURL(ref), true
+	}
+
+	return "", false
+}
+
+// textReferences returns the URLs for all Jira issue keys mentioned in text.
+// Only the keys of JiraProjects are recognized, so that words like "UTF-8"
+// or "SHA-256" are not taken for issue keys. Without JiraProjects, text is
+// not scanned at all.
+func (o *Options) textReferences(text string) (urls []string) {
+	if len(o.JiraProjects) == 0 {
+		return nil
+	}
+
+	for _, data := range jiraTextRegexp.FindAllStringSubmatch(text, -1) {
+		if o.isJiraKey(data[1]) {
+			urls = append(urls, o.jiraURL(data[1]))
+		}
+	}
+	return urls
+}
+
+// numericID returns the number at the end of the issue or pull request id,
+// e.g. 1234 for "PROJ-1234".
+func numericID(id string) string {
+	i := len(id)
+	for i > 0 && id[i-1] >= '0' && id[i-1] <= '9' {
+		i--
+	}
+	return id[i:]
+}
+
+// ClassifyURL returns whether u links to an issue, a pull request (or merge
+// request), a security advisory or a custom kind of link, and the ID, using
+// the first matching rule in LinkRules. For all other URLs, kind is empty.
+func (o *Options) ClassifyURL(u *url.URL) (kind, id string) {
+	rules := o.LinkRules
+	if rules == nil {
+		rules = defaultLinkRules
+	}
+
+	for _, rule := range rules {
+		if data := rule.Pattern.FindStringSubmatch(strings.ToLower(u.Host) + u.Path); data != nil {
+			return rule.Kind, data[1]
+		}
+	}
+
+	return "", ""
+}
+
+// Reference is a link of an entry together with its classification, so
+// templates can render the links the same way for all forges.
+type Reference struct {
+	// Kind is "issue", "pr", "advisory", a custom kind from the links
+	// section of the config, or "other" for links which are not classified.
+	Kind string
+
+	// ID is the ID of the issue, pull request or advisory, and empty for
+	// other links.
+	ID string
+
+	URL string
+
+	// Text is the usual display text for the reference, e.g. "#123" for a
+	// GitHub issue, "!7" for a GitLab merge request, "PROJ-1" for a Jira
+	// issue, and the URL for other links.
+	Text string
+}
+
+// newReference returns the reference for the link u classified as kind with
+// id, see ClassifyURL.
+func newReference(u *url.URL, kind, id string) Reference {
+	ref := Reference{Kind: kind, ID: id, URL: u.String(), Text: id}
+
+	switch {
+	case kind == "":
+		ref.Kind = "other"
+		ref.Text = u.String()
+	case numericID(id) != id:
+		// IDs like Jira keys or advisory IDs are used as they are
+	case kind == kindPullRequest && strings.Contains(u.Path, "/merge_requests/"):
+		ref.Text = "!" + id
+	case kind == kindIssue || kind == kindPullRequest:
+		ref.Text = "#" + id
+	}
+
+	return ref
+}
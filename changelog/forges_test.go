@@ -0,0 +1,101 @@
+package changelog
+
+import (
+	"net/url"
+	"testing"
+)
+
+func parseURL(t testing.TB, s string) *url.URL {
+	u, err := url.Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func forgeOptions(t testing.TB, o *Options) *Options {
+	rules, err := o.ForgeRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.LinkRules = rules
+	return o
+}
+
+func TestClassifyURL(t *testing.T) {
+	o := forgeOptions(t, &Options{
+		GitLabURLs:    []string{"https://git.example.com/gitlab"},
+		BitbucketURLs: []string{"https://bitbucket.example.com"},
+		GerritURLs:    []string{"https://review.example.org"},
+		RedmineURLs:   []string{"https://redmine.example.com"},
+	})
+
+	var tests = []struct {
+		URL  string
+		Kind string
+		ID   string
+	}{
+		{"https://github.com/restic/restic/issues/12345", kindIssue, "12345"},
+		{"https://github.com/restic/restic/pull/666", kindPullRequest, "666"},
+		{"https://github.com/restic/restic/security/advisories/GHSA-r5gh-p3vq-w6x2", kindAdvisory, "GHSA-r5gh-p3vq-w6x2"},
+		{"https://github.com/restic/restic/security/advisories", "", ""},
+		{"https://gitlab.com/group/sub/project/-/issues/42", kindIssue, "42"},
+		{"https://gitlab.com/group/project/-/merge_requests/7", kindPullRequest, "7"},
+		{"https://git.example.com/gitlab/group/project/-/merge_requests/8", kindPullRequest, "8"},
+		{"https://git.example.com/other/group/project/-/merge_requests/8", "", ""},
+		{"https://gitlab.example.org/group/project/-/issues/1", "", ""},
+		{"https://bitbucket.org/owner/repo/issues/12/some-title", kindIssue, "12"},
+		{"https://bitbucket.org/owner/repo/pull-requests/34", kindPullRequest, "34"},
+		{"https://bitbucket.example.com/projects/PROJ/repos/repo/pull-requests/56/overview", kindPullRequest, "56"},
+		{"https://bitbucket.example.org/projects/PROJ/repos/repo/pull-requests/56", "", ""},
+		{"https://dev.azure.com/org/project/_workitems/edit/1234", kindIssue, "1234"},
+		{"https://dev.azure.com/org/project/_git/repo/pullrequest/56", kindPullRequest, "56"},
+		{"https://dev.azure.com/org/project/_git/repo/commit/abc", "", ""},
+		{"https://todo.sr.ht/~sircmpwn/hare/42", kindIssue, "42"},
+		{"https://lists.sr.ht/~sircmpwn/hare-dev/patches/1234", kindPullRequest, "1234"},
+		{"https://lists.sr.ht/~sircmpwn/hare-dev/%3C20230101.abc@example.com%3E", "", ""},
+		{"https://go-review.googlesource.com/c/go/+/123456", kindPullRequest, "123456"},
+		{"https://review.example.org/c/openstack/nova/+/789/3", kindPullRequest, "789"},
+		{"https://review.example.org/gerrit/c/project/+/12", kindPullRequest, "12"},
+		{"https://review.example.org/q/status:open", "", ""},
+		{"https://redmine.example.com/issues/1234", kindIssue, "1234"},
+		{"https://redmine.example.com/issues/1234#note-5", kindIssue, "1234"},
+		{"https://redmine.example.com/projects/foo/issues/new", "", ""},
+		{"https://forum.restic.net/t/getting-last-successful-backup-time/531", "", ""},
+	}
+
+	for _, test := range tests {
+		t.Run("", func(t *testing.T) {
+			kind, id := o.ClassifyURL(parseURL(t, test.URL))
+			if kind != test.Kind || id != test.ID {
+				t.Errorf("%v: want %q %q, got %q %q", test.URL, test.Kind, test.ID, kind, id)
+			}
+		})
+	}
+}
+
+func TestNewReference(t *testing.T) {
+	o := forgeOptions(t, &Options{JiraURL: "https://jira.example.com"})
+
+	var tests = []struct {
+		URL  string
+		Kind string
+		Text string
+	}{
+		{"https://github.com/restic/restic/issues/12", "issue", "#12"},
+		{"https://github.com/restic/restic/pull/34", "pr", "#34"},
+		{"https://gitlab.com/group/project/-/merge_requests/7", "pr", "!7"},
+		{"https://jira.example.com/browse/PROJ-1", "issue", "PROJ-1"},
+		{"https://github.com/restic/restic/security/advisories/GHSA-r5gh-p3vq-w6x2", "advisory", "GHSA-r5gh-p3vq-w6x2"},
+		{"https://forum.restic.net/t/531", "other", "https://forum.restic.net/t/531"},
+	}
+
+	for _, test := range tests {
+		u := parseURL(t, test.URL)
+		kind, id := o.ClassifyURL(u)
+		ref := newReference(u, kind, id)
+		if ref.Kind != test.Kind || ref.Text != test.Text || ref.URL != test.URL {
+			t.Errorf("%v: want %v %q, got %+v", test.URL, test.Kind, test.Text, ref)
+		}
+	}
+}
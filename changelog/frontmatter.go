@@ -0,0 +1,87 @@
+package changelog
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// frontMatterDelimiter starts and ends the optional front matter at the
+// beginning of an entry file.
+const frontMatterDelimiter = "---"
+
+// FrontMatter is the structured metadata of an entry, given in YAML between
+// two lines "---" before the title line.
+type FrontMatter struct {
+	Author     string   `yaml:"author"`
+	Authors    []string `yaml:"authors"`
+	Component  string   `yaml:"component"`
+	Components []string `yaml:"components"`
+	Breaking   bool     `yaml:"breaking"`
+	Draft      bool     `yaml:"draft"`
+	Severity   string   `yaml:"severity"`
+	Audience   string   `yaml:"audience"`
+	Visibility string   `yaml:"visibility"`
+
+	Affected []AffectedRange `yaml:"affected"`
+}
+
+// AffectedRange is a range of versions affected by a security issue, given
+// in the front matter of an entry with "affected:". Without Fixed, the range
+// ends with the release containing the entry.
+type AffectedRange struct {
+	Introduced string `yaml:"introduced"`
+	Fixed      string `yaml:"fixed"`
+}
+
+// parseFrontMatter decodes the front matter in lines, unknown keys are
+// rejected.
+func parseFrontMatter(lines []string) (fm FrontMatter, err error) {
+	dec := yaml.NewDecoder(bytes.NewReader([]byte(strings.Join(lines, "\n"))))
+	dec.KnownFields(true)
+
+	err = dec.Decode(&fm)
+	if err != nil && err != io.EOF {
+		return FrontMatter{}, err
+	}
+
+	return fm, nil
+}
+
+// apply sets the fields of e from the front matter.
+func (fm FrontMatter) apply(e *Entry) {
+	if fm.Author != "" {
+		e.Authors = append(e.Authors, fm.Author)
+	}
+	e.Authors = append(e.Authors, fm.Authors...)
+
+	if fm.Component != "" {
+		e.Components = append(e.Components, fm.Component)
+	}
+	e.Components = append(e.Components, fm.Components...)
+
+	e.Breaking = e.Breaking || fm.Breaking
+	e.Draft = fm.Draft
+	e.Severity = fm.Severity
+	e.Audience = strings.ToLower(fm.Audience)
+	e.Visibility = strings.ToLower(fm.Visibility)
+	e.Affected = fm.Affected
+}
+
+// TitleLineIndex returns the index of the title line in the lines of an entry
+// file, which follows the front matter if there is one.
+func TitleLineIndex(lines []string) int {
+	if len(lines) == 0 || strings.TrimSpace(lines[0]) != frontMatterDelimiter {
+		return 0
+	}
+
+	for i := 1; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) == frontMatterDelimiter {
+			return i + 1
+		}
+	}
+
+	return 0
+}
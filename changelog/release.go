@@ -0,0 +1,677 @@
+package changelog
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Files lists all entry files in dir, sorted by name. Symlinks are
+// followed. Subdirectories are only read with Recursive.
+func (o *Options) Files(dir string) ([]string, error) {
+	return o.listFiles(dir, o.Recursive, nil)
+}
+
+// listFiles lists the files in dir, and with recursive the files in all
+// subdirectories. The list parents contains the directories above dir, to
+// detect loops of symlinked directories.
+func (o *Options) listFiles(dir string, recursive bool, parents []os.FileInfo) ([]string, error) {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read dir: %v", err)
+	}
+
+	for _, parent := range parents {
+		if os.SameFile(parent, fi) {
+			o.warn(dir, "symlink loop")
+			return nil, nil
+		}
+	}
+	parents = append(parents, fi)
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error opening dir: %v", err)
+	}
+
+	names, err := d.Readdirnames(-1)
+	if err != nil {
+		_ = d.Close()
+		return nil, fmt.Errorf("error listing dir %v: %v", dir, err)
+	}
+
+	err = d.Close()
+	if err != nil {
+		return nil, fmt.Errorf("error closing dir: %v", err)
+	}
+
+	sort.Strings(names)
+
+	var files []string
+	for _, name := range names {
+		if IsReservedName(name) {
+			o.warn(filepath.Join(dir, name), "reserved file name")
+			continue
+		}
+
+		// skip dot files
+		if strings.HasPrefix(name, ".") {
+			o.warn(filepath.Join(dir, name), "hidden file")
+			continue
+		}
+
+		path := filepath.Join(dir, name)
+		fi, err := os.Stat(path)
+		if err != nil {
+			o.warn(path, "broken symlink")
+			continue
+		}
+
+		if fi.IsDir() {
+			if !recursive {
+				o.warn(path, "directory")
+				continue
+			}
+
+			list, err := o.listFiles(path, recursive, parents)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, list...)
+			continue
+		}
+
+		files = append(files, path)
+	}
+
+	return files, nil
+}
+
+// The names of the files calens uses itself in the changelog dir, next to
+// the release metadata files.
+const (
+	// ReleasesFile is the optional index file which lists all releases
+	// explicitly, see ReadReleases.
+	ReleasesFile = "releases"
+
+	// HistoryLockFile records the checksums of the released versions.
+	HistoryLockFile = "history.lock"
+
+	// DeferLog records all entries moved by the defer command.
+	DeferLog = "deferred.log"
+)
+
+// DeferredPrefix is the name prefix of the directories which hold entries
+// deferred to a later release, e.g. "unreleased-next".
+const DeferredPrefix = "unreleased-"
+
+// IsReservedName reports whether name is one of the files calens uses itself
+// in the changelog dir or a release directory, which are never entries: the
+// template, the releases file, the history lock, the defer log and the
+// release metadata files.
+func IsReservedName(name string) bool {
+	switch name {
+	case "TEMPLATE", ReleasesFile, HistoryLockFile, DeferLog, releaseMetaFile, releaseNotesFile, releaseIntroFile:
+		return true
+	default:
+		return false
+	}
+}
+
+// Release is one release, with an optional release date.
+type Release struct {
+	// Path is the directory the entries of the release are read from.
+	Path    string
+	Version string
+	Date    *time.Time
+
+	// Codename, Summary and Yanked are set in the releases file or in the
+	// notes.yml file in the release directory, see ReleaseNotes.
+	Codename string
+	Summary  string
+	Yanked   bool
+
+	// Intro contains the paragraphs of the _intro.md file in the release
+	// directory.
+	Intro []string
+
+	// Scheme is the versioning scheme the version was parsed with, it is
+	// nil for versions used verbatim (e.g. from the releases file).
+	Scheme VersionScheme
+
+	// Files lists the entry files for this release if they are not read
+	// from the directory in Path.
+	Files []string
+}
+
+// ReleaseSlice allows sorting a slice of releases by the release date
+// with Go < 1.8
+type ReleaseSlice []Release
+
+// Len is the number of elements in the collection.
+func (s ReleaseSlice) Len() int {
+	return len(s)
+}
+
+// Less reports whether the element with
+// index i should sort before the element with index j.
+func (s ReleaseSlice) Less(i, j int) bool {
+	a, b := s[i], s[j]
+
+	// unreleased changes are listed first
+	if a.Version == "unreleased" || b.Version == "unreleased" {
+		return a.Version == "unreleased" && b.Version != "unreleased"
+	}
+
+	// releases without a date are listed first
+	if (a.Date == nil) != (b.Date == nil) {
+		return a.Date == nil
+	}
+
+	if a.Date != nil && !a.Date.Equal(*b.Date) {
+		return b.Date.Before(*a.Date)
+	}
+
+	// releases from the same day or both without a date are ordered by
+	// version, newest first
+	if a.Scheme != nil && b.Scheme != nil {
+		return a.Scheme.Compare(a.Version, b.Version) > 0
+	}
+
+	return false
+}
+
+// Swap swaps the elements with indexes i and j.
+func (s ReleaseSlice) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+var versionRegex = regexp.MustCompile(`^([^_]+)(?:_(\d{4}-\d{2}-\d{2}))?$`)
+
+// releaseDateRegex matches the optional release date in the releases file.
+var releaseDateRegex = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+
+// unreleasedDir returns the name of the directory of the pending bucket set
+// with Unreleased.
+func (o *Options) unreleasedDir() string {
+	name := strings.TrimPrefix(o.Unreleased, DeferredPrefix)
+	if name == "" || name == "unreleased" {
+		return "unreleased"
+	}
+	return DeferredPrefix + name
+}
+
+// readReleasesFile parses the releases index file in dir. Each non-empty line
+// which does not start with # contains a version string, an optional release
+// date (format YYYY-MM-DD) and an optional codename, separated by whitespace.
+// The versions are listed newest first and are used verbatim, the order of
+// the lines is kept. The entries for a version are read from the subdir
+// named like the version, or the version followed by an underscore and the
+// date. Versions without such a subdir have no entries. The unreleased
+// changes are included even if the file does not list them. If the file does
+// not exist, ok is false.
+func (o *Options) readReleasesFile(dir string) (result []Release, ok bool, err error) {
+	filename := filepath.Join(dir, ReleasesFile)
+	buf, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, true, fmt.Errorf("unable to read %v: %v", filename, err)
+	}
+
+	seen := make(map[string]struct{})
+	sc := bufio.NewScanner(strings.NewReader(string(buf)))
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		rel := Release{
+			Version: fields[0],
+		}
+
+		if _, ok := seen[rel.Version]; ok {
+			return nil, true, fmt.Errorf("%v:%d: duplicate version %v", filename, line, rel.Version)
+		}
+		seen[rel.Version] = struct{}{}
+
+		candidates := []string{rel.Version}
+		if rel.Version == "unreleased" {
+			candidates = []string{o.unreleasedDir()}
+		}
+		fields = fields[1:]
+		if len(fields) > 0 && releaseDateRegex.MatchString(fields[0]) {
+			t, err := time.Parse("2006-01-02", fields[0])
+			if err != nil {
+				return nil, true, fmt.Errorf("%v:%d: unable to parse date %q: %v", filename, line, fields[0], err)
+			}
+			rel.Date = &t
+			candidates = append(candidates, rel.Version+"_"+fields[0])
+			fields = fields[1:]
+		}
+		rel.Codename = strings.Join(fields, " ")
+
+		for _, name := range candidates {
+			fi, err := os.Stat(filepath.Join(dir, name))
+			if err == nil && fi.IsDir() {
+				rel.Path = filepath.Join(dir, name)
+				break
+			}
+		}
+
+		// versions without a subdir are listed without entries
+		if rel.Path == "" {
+			rel.Path = dir
+			rel.Files = []string{}
+		} else {
+			rel, err = o.readReleaseDetails(rel)
+			if err != nil {
+				return nil, true, err
+			}
+		}
+
+		result = append(result, rel)
+	}
+
+	// the unreleased changes are always included
+	if _, ok := seen["unreleased"]; !ok {
+		path := filepath.Join(dir, o.unreleasedDir())
+		if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+			rel, err := o.readReleaseDetails(Release{Path: path, Version: "unreleased"})
+			if err != nil {
+				return nil, true, err
+			}
+			result = append([]Release{rel}, result...)
+		}
+	}
+
+	if o.Warn != nil {
+		err = o.warnUnlisted(dir, result)
+		if err != nil {
+			return nil, true, err
+		}
+	}
+
+	return result, true, nil
+}
+
+// ParseVersion parses the version string according to the versioning scheme
+// and returns a release without path and date.
+func (o *Options) ParseVersion(s string) (rel Release, err error) {
+	scheme, err := LookupVersionScheme(o.Versioning)
+	if err != nil {
+		return Release{}, err
+	}
+
+	rel.Version, err = scheme.Parse(s)
+	if err != nil {
+		return Release{}, fmt.Errorf("parsing %v returned error: %v", o.Versioning, err)
+	}
+	rel.Scheme = scheme
+
+	return rel, nil
+}
+
+// warnUnlisted warns about all subdirs of dir which are not used by any of
+// the releases.
+func (o *Options) warnUnlisted(dir string, releases []Release) error {
+	used := make(map[string]struct{})
+	for _, rel := range releases {
+		used[filepath.Clean(rel.Path)] = struct{}{}
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("unable to list directory: %v", err)
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if _, ok := used[filepath.Clean(path)]; !entry.IsDir() || ok {
+			continue
+		}
+
+		o.warn(path, "not listed in the releases file")
+	}
+
+	return nil
+}
+
+// ReadReleases lists the directory and parses all releases from the subdir
+// names there. If the directory contains a releases index file, the releases
+// are taken from that file instead (see readReleasesFile). A valid release
+// subdir has the format "x.y.z_YYYY-MM-DD", the underscore and date is
+// optional (for unreleased versions). With CalVer versioning, the version has
+// the format "YYYY.MM[.MICRO]" instead. Subdirs with a release.yml file may
+// have any name, see ReleaseMeta. The entry files directly in dir are passed
+// to the Assign hook. Unless the releases are taken from the releases file,
+// the resulting slice is sorted by the release dates, starting with
+// unreleased versions and continuing with the other versions, newest first.
+func (o *Options) ReadReleases(dir string) (result []Release, err error) {
+	if rels, ok, err := o.readReleasesFile(dir); ok || err != nil {
+		if err == nil && o.Assign != nil {
+			rels, err = o.Assign(dir, rels)
+		}
+		return rels, err
+	}
+
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open dir: %v", err)
+	}
+
+	entries, err := f.Readdir(-1)
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("unable to list directory: %v", err)
+	}
+
+	err = f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("close dir: %v", err)
+	}
+
+	unreleasedDir := o.unreleasedDir()
+	for _, entry := range entries {
+		// release directories may be symlinks
+		if entry.Mode()&os.ModeSymlink != 0 {
+			if fi, err := os.Stat(filepath.Join(dir, entry.Name())); err == nil {
+				entry = fi
+			}
+		}
+
+		if !entry.Mode().IsDir() {
+			if o.Assign == nil && !IsReservedName(entry.Name()) {
+				o.warn(filepath.Join(dir, entry.Name()), "not a directory")
+			}
+			continue
+		}
+
+		// skip dot dirs
+		if strings.HasPrefix(entry.Name(), ".") {
+			o.warn(filepath.Join(dir, entry.Name()), "hidden directory")
+			continue
+		}
+
+		// only the pending bucket selected with Unreleased is read, other
+		// buckets hold entries deferred to a later release or for another
+		// release branch
+		if entry.Name() == "unreleased" && entry.Name() != unreleasedDir {
+			o.warn(filepath.Join(dir, entry.Name()), "pending bucket not selected with --unreleased")
+			continue
+		}
+		if strings.HasPrefix(entry.Name(), DeferredPrefix) && entry.Name() != unreleasedDir {
+			o.warn(filepath.Join(dir, entry.Name()), "entries deferred to a later release")
+			continue
+		}
+
+		if entry.Name() == unreleasedDir {
+			rel, err := o.readReleaseDetails(Release{
+				Path:    filepath.Join(dir, entry.Name()),
+				Version: "unreleased",
+			})
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, rel)
+			continue
+		}
+
+		rel, ok, err := readReleaseMeta(filepath.Join(dir, entry.Name()))
+		if !ok && err == nil {
+			rel, err = o.ParseReleaseDir(filepath.Join(dir, entry.Name()))
+		}
+		if err == nil {
+			rel, err = o.readReleaseDetails(rel)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, rel)
+	}
+
+	result, err = o.mergeDuplicates(result)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.Assign != nil {
+		result, err = o.Assign(dir, result)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Sort(ReleaseSlice(result))
+
+	return result, nil
+}
+
+// releaseMetaFile is the name of the optional file in a release directory
+// which sets the version and date of the release, see ReleaseMeta.
+const releaseMetaFile = "release.yml"
+
+// ReleaseMeta is the content of the release.yml file in a release directory.
+// The version is used verbatim, so it may be any string like "Big Sur
+// Update 3", and the name of the directory does not matter.
+type ReleaseMeta struct {
+	Version string `yaml:"version"`
+	Date    string `yaml:"date"`
+}
+
+// readReleaseMeta returns the release for the directory path from its
+// release.yml file. It returns false if there is no such file.
+func readReleaseMeta(path string) (Release, bool, error) {
+	filename := filepath.Join(path, releaseMetaFile)
+	buf, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return Release{}, false, nil
+	}
+	if err != nil {
+		return Release{}, true, err
+	}
+
+	var meta ReleaseMeta
+	err = yaml.Unmarshal(buf, &meta)
+	if err != nil {
+		return Release{}, true, &ErrBadReleaseDir{File: filename, Dir: path, Reason: fmt.Sprintf("invalid %v: %v", releaseMetaFile, err)}
+	}
+
+	rel := Release{
+		Path:    path,
+		Version: strings.TrimSpace(meta.Version),
+	}
+
+	if rel.Version == "" {
+		return Release{}, true, &ErrBadReleaseDir{File: filename, Dir: path, Reason: "no version set"}
+	}
+
+	if meta.Date != "" {
+		t, err := time.Parse("2006-01-02", meta.Date)
+		if err != nil {
+			return Release{}, true, &ErrBadReleaseDir{File: filename, Dir: path, Reason: fmt.Sprintf("unable to parse date %q: %v", meta.Date, err)}
+		}
+		rel.Date = &t
+	}
+
+	return rel, true, nil
+}
+
+// releaseNotesFile is the name of the optional file in a release directory
+// which describes the release, see ReleaseNotes.
+const releaseNotesFile = "notes.yml"
+
+// ReleaseNotes is the content of the notes.yml file in a release directory:
+// an optional codename, a short summary of the release and whether the
+// release was yanked (withdrawn after it was published).
+type ReleaseNotes struct {
+	Codename string `yaml:"codename"`
+	Summary  string `yaml:"summary"`
+	Yanked   bool   `yaml:"yanked"`
+}
+
+// readReleaseNotes returns rel with the codename, summary and yanked flag
+// from the notes.yml file in its directory, if there is one. A codename
+// from the releases file takes precedence.
+func readReleaseNotes(rel Release) (Release, error) {
+	filename := filepath.Join(rel.Path, releaseNotesFile)
+	buf, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return rel, nil
+	}
+	if err != nil {
+		return rel, err
+	}
+
+	var notes ReleaseNotes
+	err = yaml.Unmarshal(buf, &notes)
+	if err != nil {
+		return rel, &ErrBadReleaseDir{File: filename, Dir: rel.Path, Reason: fmt.Sprintf("invalid %v: %v", releaseNotesFile, err)}
+	}
+
+	if rel.Codename == "" {
+		rel.Codename = strings.TrimSpace(notes.Codename)
+	}
+	rel.Summary = strings.TrimSpace(notes.Summary)
+	rel.Yanked = notes.Yanked
+
+	return rel, nil
+}
+
+// releaseIntroFile is the name of the optional file in a release directory
+// with an introduction to the release, rendered before the entries.
+const releaseIntroFile = "_intro.md"
+
+// readReleaseIntro returns rel with the paragraphs of the _intro.md file in
+// its directory, if there is one. The paragraphs are split like the ones of
+// an entry.
+func (o *Options) readReleaseIntro(rel Release) (Release, error) {
+	filename := filepath.Join(rel.Path, releaseIntroFile)
+	buf, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return rel, nil
+	}
+	if err != nil {
+		return rel, err
+	}
+
+	if !utf8.Valid(buf) {
+		if !o.Latin1 {
+			return rel, invalidEncoding(filename, buf)
+		}
+		buf = latin1ToUTF8(buf)
+	}
+
+	var body paragraphBuilder
+	for _, line := range strings.Split(string(NormalizeText(buf)), "\n") {
+		body.add(line)
+	}
+
+	if body.verbatim {
+		return rel, fmt.Errorf("unmatched verbatim tag in %v", filename)
+	}
+
+	rel.Intro = nil
+	for _, par := range body.finish() {
+		rel.Intro = append(rel.Intro, Capitalize(strings.TrimSpace(par)))
+	}
+
+	return rel, nil
+}
+
+// readReleaseDetails returns rel with the details from the optional files in
+// its directory, see readReleaseNotes and readReleaseIntro.
+func (o *Options) readReleaseDetails(rel Release) (Release, error) {
+	rel, err := readReleaseNotes(rel)
+	if err != nil {
+		return rel, err
+	}
+
+	return o.readReleaseIntro(rel)
+}
+
+// ParseReleaseDir returns the release for the directory path, whose name
+// consists of the version and an optional date. Invalid names are reported
+// as *ErrBadReleaseDir.
+func (o *Options) ParseReleaseDir(path string) (Release, error) {
+	data := versionRegex.FindStringSubmatch(filepath.Base(path))
+	if len(data) == 0 {
+		return Release{}, &ErrBadReleaseDir{Dir: path, Reason: "invalid subdir name"}
+	}
+
+	rel, err := o.ParseVersion(data[1])
+	if err != nil {
+		return Release{}, &ErrBadReleaseDir{Dir: path, Reason: fmt.Sprintf("invalid subdir name: %v", err)}
+	}
+	rel.Path = path
+
+	date := data[2]
+
+	if date != "" {
+		t, err := time.Parse("2006-01-02", date)
+		if err != nil {
+			return Release{}, &ErrBadReleaseDir{Dir: path, Reason: fmt.Sprintf("unable to parse date %q: %v", date, err)}
+		}
+		rel.Date = &t
+	}
+
+	return rel, nil
+}
+
+// mergeDuplicates checks that there is only one directory for each version.
+// With MergeDuplicates, the entries of all directories for the same version
+// are merged into one release with the latest date instead.
+func (o *Options) mergeDuplicates(releases []Release) (result []Release, err error) {
+	// sort by path so that the order of the merged files is stable
+	sort.SliceStable(releases, func(i, j int) bool {
+		return releases[i].Path < releases[j].Path
+	})
+
+	index := make(map[string]int)
+	for _, rel := range releases {
+		i, ok := index[rel.Version]
+		if !ok {
+			index[rel.Version] = len(result)
+			result = append(result, rel)
+			continue
+		}
+
+		prev := &result[i]
+		if !o.MergeDuplicates {
+			return nil, fmt.Errorf("version %v found in several directories: %v and %v, remove one or use --merge-duplicates", rel.Version, prev.Path, rel.Path)
+		}
+
+		if prev.Files == nil {
+			prev.Files, err = o.Files(prev.Path)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		list, err := o.Files(rel.Path)
+		if err != nil {
+			return nil, err
+		}
+		prev.Files = append(prev.Files, list...)
+
+		if prev.Date == nil || (rel.Date != nil && rel.Date.After(*prev.Date)) {
+			prev.Date = rel.Date
+			prev.Path = rel.Path
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,114 @@
+package changelog
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// smallWords are not capitalized in the middle of a title with TitleCase
+// "title".
+var smallWords = map[string]bool{
+	"a": true, "an": true, "and": true, "as": true, "at": true, "but": true,
+	"by": true, "for": true, "from": true, "in": true, "into": true,
+	"nor": true, "of": true, "on": true, "or": true, "the": true, "to": true,
+	"via": true, "vs": true, "with": true,
+}
+
+// applyTitleCase converts title to the case set with TitleCase: "sentence"
+// only capitalizes the first word, "title" capitalizes all words except
+// small words like "and" or "of". Words which may be names are never
+// changed: words containing upper case letters after the first letter (like
+// "GitHub" or "API"), digits or other characters (like "S3" or "--verbose"),
+// and text in backticks. The words in TitleCaseKeep (like "Windows") are
+// always written as given there.
+func (o *Options) applyTitleCase(title string) string {
+	if o.TitleCase == "" || o.TitleCase == "none" {
+		return title
+	}
+
+	words := strings.Split(title, " ")
+	code := false
+	for i, word := range words {
+		// skip text in backticks
+		if code {
+			code = !strings.HasSuffix(word, "`")
+			continue
+		}
+		if strings.HasPrefix(word, "`") {
+			code = len(word) == 1 || !strings.HasSuffix(word, "`")
+			continue
+		}
+
+		core := trimPunctuation(word)
+		if keep := o.keepWord(core); keep != "" {
+			words[i] = strings.Replace(word, core, keep, 1)
+			continue
+		}
+
+		if i == 0 || !plainWord(core) {
+			continue
+		}
+
+		switch o.TitleCase {
+		case "sentence":
+			words[i] = strings.ToLower(word)
+		case "title":
+			if i < len(words)-1 && smallWords[strings.ToLower(core)] {
+				words[i] = strings.ToLower(word)
+			} else {
+				words[i] = capitalizeWord(word)
+			}
+		}
+	}
+
+	return strings.Join(words, " ")
+}
+
+// trimPunctuation removes punctuation like "(" or "," around word.
+func trimPunctuation(word string) string {
+	return strings.Trim(word, "\"'([{,.;:!?)]}")
+}
+
+// keepWord returns the word in TitleCaseKeep which matches word regardless
+// of case, or "" if there is none.
+func (o *Options) keepWord(word string) string {
+	for _, keep := range o.TitleCaseKeep {
+		if strings.EqualFold(word, keep) {
+			return keep
+		}
+	}
+	return ""
+}
+
+// plainWord reports whether the case of word may be changed: it consists of
+// letters (and apostrophes or hyphens after the first letter) of which at
+// most the first one is upper case.
+func plainWord(word string) bool {
+	if word == "" {
+		return false
+	}
+
+	for i, r := range word {
+		switch {
+		case i > 0 && (r == '\'' || r == '-'):
+		case !unicode.IsLetter(r):
+			return false
+		case i > 0 && unicode.IsUpper(r):
+			return false
+		}
+	}
+
+	return true
+}
+
+// capitalizeWord converts the first letter of word to upper case, skipping
+// leading punctuation.
+func capitalizeWord(word string) string {
+	for i, r := range word {
+		if unicode.IsLetter(r) {
+			return word[:i] + string(unicode.ToUpper(r)) + word[i+utf8.RuneLen(r):]
+		}
+	}
+	return word
+}
@@ -0,0 +1,28 @@
+package changelog
+
+import "testing"
+
+func TestApplyTitleCase(t *testing.T) {
+	var tests = []struct {
+		mode, title, want string
+	}{
+		{"none", "Add Support For zstd", "Add Support For zstd"},
+		{"sentence", "Add Support For Zstd Compression", "Add support for zstd compression"},
+		{"sentence", "Fix Crash In S3 Backend On windows", "Fix crash in S3 backend on Windows"},
+		{"sentence", "Support GitHub API (Enterprise) And MACOS", "Support GitHub API (enterprise) and macOS"},
+		{"sentence", "Add `Restore --Target` Option", "Add `Restore --Target` option"},
+		{"title", "add support for the zstd compression", "Add Support for the Zstd Compression"},
+		{"title", "fix crash in the S3 backend on macos with --verbose", "Fix Crash in the S3 Backend on macOS with --verbose"},
+		{"title", "don't print a warning for", "Don't Print a Warning For"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.mode+" "+test.title, func(t *testing.T) {
+			o := &Options{TitleCase: test.mode, TitleCaseKeep: []string{"Windows", "macOS"}}
+			res := o.applyTitleCase(Capitalize(test.title))
+			if res != test.want {
+				t.Errorf("want %q, got %q", test.want, res)
+			}
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package changelog
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// VersionScheme parses and orders the versions of releases. The scheme is
+// selected by name, see LookupVersionScheme.
+type VersionScheme interface {
+	// Parse checks that s is a valid version and returns it in normalized
+	// form.
+	Parse(s string) (string, error)
+
+	// Compare returns -1, 0 or 1 depending on whether version a is lower,
+	// equal to or higher than b. Both versions were returned by Parse.
+	Compare(a, b string) int
+}
+
+// versionSchemes contains the registered versioning schemes by name.
+var versionSchemes = map[string]VersionScheme{
+	"semver":  semverScheme{},
+	"calver":  calverScheme{},
+	"ordinal": ordinalScheme{},
+}
+
+// RegisterVersionScheme makes scheme available as name. An existing scheme
+// with the same name is replaced, a nil scheme removes it.
+func RegisterVersionScheme(name string, scheme VersionScheme) {
+	if scheme == nil {
+		delete(versionSchemes, name)
+		return
+	}
+	versionSchemes[name] = scheme
+}
+
+// LookupVersionScheme returns the scheme registered as name. The built-in
+// schemes are "semver" (also used if name is empty), "calver" and
+// "ordinal".
+func LookupVersionScheme(name string) (VersionScheme, error) {
+	if name == "" {
+		name = "semver"
+	}
+
+	scheme, ok := versionSchemes[name]
+	if !ok {
+		var names []string
+		for name := range versionSchemes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		return nil, fmt.Errorf("unknown versioning scheme %q, valid schemes: %v", name, strings.Join(names, ", "))
+	}
+
+	return scheme, nil
+}
+
+// PrereleaseBase returns the final version for the pre-release version
+// parsed with scheme (e.g. "1.3.0" for "1.3.0-rc.1"). It returns false if
+// version is not a pre-release of a semantic version.
+func PrereleaseBase(scheme VersionScheme, version string) (string, bool) {
+	if _, ok := scheme.(semverScheme); !ok {
+		return "", false
+	}
+
+	ver, _, err := parseSemver(version)
+	if err != nil || ver.Prerelease() == "" {
+		return "", false
+	}
+
+	return fmt.Sprintf("%d.%d.%d", ver.Major(), ver.Minor(), ver.Patch()), true
+}
+
+// semverScheme parses versions according to https://semver.org, a leading
+// "v" is removed. Versions with two components like "1.2" are normalized to
+// "1.2.0", versions with four components like "1.2.3.4" are accepted as well.
+type semverScheme struct{}
+
+// fourComponentRegex matches versions with four numeric components.
+var fourComponentRegex = regexp.MustCompile(`^v?(\d+)\.(\d+)\.(\d+)\.(\d+)$`)
+
+// parseSemver parses a semantic version, for versions with four components
+// the last one is returned separately.
+func parseSemver(s string) (ver *semver.Version, fourth uint64, err error) {
+	if m := fourComponentRegex.FindStringSubmatch(s); m != nil {
+		fourth, err = strconv.ParseUint(m[4], 10, 64)
+		if err != nil {
+			return nil, 0, err
+		}
+		s = strings.Join(m[1:4], ".")
+	}
+
+	ver, err = semver.NewVersion(s)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return ver, fourth, nil
+}
+
+// Parse returns the normalized semantic version.
+func (semverScheme) Parse(s string) (string, error) {
+	ver, fourth, err := parseSemver(s)
+	if err != nil {
+		return "", err
+	}
+
+	if fourComponentRegex.MatchString(s) {
+		return fmt.Sprintf("%v.%d", ver, fourth), nil
+	}
+	return ver.String(), nil
+}
+
+// Compare orders two semantic versions, versions which cannot be parsed are
+// considered equal.
+func (semverScheme) Compare(a, b string) int {
+	x, x4, errA := parseSemver(a)
+	y, y4, errB := parseSemver(b)
+	if errA != nil || errB != nil {
+		return 0
+	}
+
+	if c := x.Compare(y); c != 0 {
+		return c
+	}
+
+	switch {
+	case x4 < y4:
+		return -1
+	case x4 > y4:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// calverScheme parses CalVer versions like "2024.06" or "2024.06.1", see
+// ParseCalVer.
+type calverScheme struct{}
+
+// Parse returns the CalVer version with the month written with two digits
+// and the micro component without leading zeroes, so that "2024.6" and
+// "2024.06" are the same version "2024.06".
+func (calverScheme) Parse(s string) (string, error) {
+	parts, err := ParseCalVer(s)
+	if err != nil {
+		return "", err
+	}
+
+	// keep the year as written, "24" and "2024" are not the same
+	version := fmt.Sprintf("%v.%02d", calverRegex.FindStringSubmatch(s)[1], parts[1])
+	if len(parts) > 2 {
+		version += fmt.Sprintf(".%d", parts[2])
+	}
+	return version, nil
+}
+
+// Compare orders two CalVer versions component by component.
+func (calverScheme) Compare(a, b string) int {
+	x, _ := ParseCalVer(a)
+	y, _ := ParseCalVer(b)
+	return compareCalVer(x, y)
+}
+
+// ordinalScheme parses versions which are plain release numbers like "42".
+type ordinalScheme struct{}
+
+// Parse returns the release number without leading zeroes.
+func (ordinalScheme) Parse(s string) (string, error) {
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("version %q is not a release number", s)
+	}
+	return strconv.FormatUint(n, 10), nil
+}
+
+// Compare orders two release numbers.
+func (ordinalScheme) Compare(a, b string) int {
+	x, _ := strconv.ParseUint(a, 10, 64)
+	y, _ := strconv.ParseUint(b, 10, 64)
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	default:
+		return 0
+	}
+}
+
+var calverRegex = regexp.MustCompile(`^(\d{2}|\d{4})\.(\d{1,2})(?:\.(\d+))?$`)
+
+// LooksLikeCalVer reports whether version looks like a calendar version with
+// a four digit year, which the semver scheme reads leniently (e.g. "2024.05"
+// as "2024.5.0").
+func LooksLikeCalVer(version string) bool {
+	data := calverRegex.FindStringSubmatch(version)
+	return data != nil && len(data[1]) == 4
+}
+
+// ParseCalVer parses a CalVer version like "2024.06" or "2024.06.1" (year,
+// month and an optional micro or day component) and returns the numeric
+// components.
+func ParseCalVer(s string) ([]int, error) {
+	data := calverRegex.FindStringSubmatch(s)
+	if len(data) == 0 {
+		return nil, fmt.Errorf("version %q does not match the format YYYY.MM[.MICRO]", s)
+	}
+
+	var parts []int
+	for _, str := range data[1:] {
+		if str == "" {
+			continue
+		}
+
+		n, err := strconv.Atoi(str)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, n)
+	}
+
+	if parts[1] < 1 || parts[1] > 12 {
+		return nil, fmt.Errorf("version %q has invalid month %d", s, parts[1])
+	}
+
+	return parts, nil
+}
+
+// compareCalVer returns -1, 0 or 1 depending on whether the CalVer version a
+// is lower, equal to or higher than b. A missing component is treated as zero.
+func compareCalVer(a, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+
+	return 0
+}
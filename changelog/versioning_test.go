@@ -0,0 +1,85 @@
+package changelog
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+func TestParseCalVer(t *testing.T) {
+	var tests = []struct {
+		Version string
+		Parts   []int
+		Valid   bool
+	}{
+		{"2024.06.1", []int{2024, 6, 1}, true},
+		{"2024.05", []int{2024, 5}, true},
+		{"24.1.3", []int{24, 1, 3}, true},
+		{"2024.13", nil, false},
+		{"1.2.3-rc.1", nil, false},
+		{"v2024.01", nil, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Version, func(t *testing.T) {
+			parts, err := ParseCalVer(test.Version)
+			if test.Valid && err != nil {
+				t.Fatal(err)
+			}
+			if !test.Valid && err == nil {
+				t.Fatalf("expected error for %q, got none", test.Version)
+			}
+
+			if diff := deep.Equal(test.Parts, parts); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestCalverScheme(t *testing.T) {
+	var tests = []struct {
+		version, want string
+	}{
+		{"2024.06", "2024.06"},
+		{"2024.6", "2024.06"},
+		{"2024.06.1", "2024.06.1"},
+		{"2024.6.01", "2024.06.1"},
+		{"24.1.3", "24.01.3"},
+	}
+
+	for _, test := range tests {
+		version, err := calverScheme{}.Parse(test.version)
+		if err != nil {
+			t.Errorf("%v: %v", test.version, err)
+			continue
+		}
+		if version != test.want {
+			t.Errorf("%v: want version %v, got %v", test.version, test.want, version)
+		}
+	}
+
+	if _, err := (calverScheme{}).Parse("2024.13"); err == nil {
+		t.Error("expected error for invalid month")
+	}
+}
+
+func TestSemverSchemeCompare(t *testing.T) {
+	var compare = []struct {
+		a, b string
+		want int
+	}{
+		{"1.2.0", "1.2.0", 0},
+		{"1.2.0", "1.10.0", -1},
+		{"1.2.3.4", "1.2.3.10", -1},
+		{"1.2.3.4", "1.2.3", 1},
+		{"1.2.4", "1.2.3.9", 1},
+		{"1.0.0-rc.1", "1.0.0", -1},
+	}
+
+	for _, test := range compare {
+		if res := (semverScheme{}).Compare(test.a, test.b); res != test.want {
+			t.Errorf("compare %v %v: want %d, got %d", test.a, test.b, test.want, res)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+
+	"github.com/restic/calens/changelog"
+)
+
+// checklistStep is a step from the checklist section of the config, see
+// ChecklistConfig.
+type checklistStep struct {
+	step *template.Template
+	cond *template.Template
+}
+
+// checklistSteps are the steps from the checklist section of the config.
+var checklistSteps []checklistStep
+
+// newChecklistStep compiles the templates for a checklist step.
+func newChecklistStep(cfg ChecklistConfig) (checklistStep, error) {
+	var step checklistStep
+
+	if strings.TrimSpace(cfg.Step) == "" {
+		return step, errors.New("checklist: empty step")
+	}
+
+	var err error
+	step.step, err = template.New("").Funcs(templateFuncs()).Parse(cfg.Step)
+	if err != nil {
+		return step, fmt.Errorf("checklist: invalid step %q: %v", cfg.Step, err)
+	}
+
+	if cfg.If != "" {
+		step.cond, err = template.New("").Funcs(templateFuncs()).Parse("{{ if " + cfg.If + " }}true{{ end }}")
+		if err != nil {
+			return step, fmt.Errorf("checklist: invalid condition %q: %v", cfg.If, err)
+		}
+	}
+
+	return step, nil
+}
+
+// runChecklist implements the "checklist" command: it writes a Markdown
+// checklist with the steps from the config which apply to the release
+// selected with --version (or the unreleased entries), e.g. as the body of a
+// GitHub issue.
+func runChecklist(args []string) {
+	if len(args) != 0 || len(opts.Versions) > 1 {
+		die("usage: calens checklist [--version VERSION]")
+	}
+
+	if len(checklistSteps) == 0 {
+		die("no steps found, configure them in the checklist section of the config")
+	}
+
+	version := "unreleased"
+	if len(opts.Versions) == 1 {
+		version = opts.Versions[0]
+	}
+
+	var releases []changelog.Release
+	for _, rel := range readReleases(opts.InputDir) {
+		if rel.Version == version {
+			releases = append(releases, rel)
+		}
+	}
+
+	if len(releases) == 0 {
+		die("version %v not found", version)
+	}
+
+	vc := VersionChanges{Version: version}
+	if changes := buildChanges(releases); len(changes) > 0 {
+		vc = changes[0]
+	}
+
+	list, err := checklist(vc)
+	if err != nil {
+		die("%v", err)
+	}
+
+	writeOutput(func(wr io.Writer) error {
+		for _, step := range list {
+			_, err := fmt.Fprintf(wr, "- [ ] %v\n", step)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// checklist returns the steps which apply to the release vc.
+func checklist(vc VersionChanges) (list []string, err error) {
+	for _, step := range checklistSteps {
+		if step.cond != nil {
+			var buf bytes.Buffer
+			err := step.cond.Execute(&buf, vc)
+			if err != nil {
+				return nil, fmt.Errorf("checklist: %v", err)
+			}
+
+			if buf.Len() == 0 {
+				continue
+			}
+		}
+
+		var buf bytes.Buffer
+		err := step.step.Execute(&buf, vc)
+		if err != nil {
+			return nil, fmt.Errorf("checklist: %v", err)
+		}
+
+		list = append(list, strings.TrimSpace(buf.String()))
+	}
+
+	return list, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/restic/calens/changelog"
+)
+
+// EntryGroup is a list of entries which belong to the same component.
+type EntryGroup struct {
+	// Name is the name of the component, it is empty for the group of
+	// entries without a component.
+	Name    string
+	Entries []changelog.Entry
+}
+
+// labelCache caches the labels of issues and pull requests by reference.
+var labelCache = make(map[string][]string)
+
+// issueLabels returns the labels of the GitHub issue or pull request ref
+// (e.g. "restic/restic#1234").
+func issueLabels(ref string) ([]string, error) {
+	if labels, ok := labelCache[ref]; ok {
+		return labels, nil
+	}
+
+	data := strings.SplitN(ref, "#", 2)
+	if len(data) != 2 {
+		return nil, fmt.Errorf("invalid reference %q", ref)
+	}
+
+	var issue struct {
+		Labels []struct {
+			Name string `json:"name"`
+		} `json:"labels"`
+	}
+
+	err := githubGet(fmt.Sprintf("/repos/%v/issues/%v", data[0], data[1]), &issue)
+	if err != nil {
+		return nil, err
+	}
+
+	var labels []string
+	for _, label := range issue.Labels {
+		labels = append(labels, label.Name)
+	}
+
+	labelCache[ref] = labels
+	return labels, nil
+}
+
+// addComponents adds the components from the labels of all referenced GitHub
+// issues and pull requests which start with prefix to the entry.
+func addComponents(e *changelog.Entry, prefix string) {
+	seen := make(map[string]struct{})
+	for _, name := range e.Components {
+		seen[name] = struct{}{}
+	}
+
+	for _, u := range append(e.IssueURLs, e.PRURLs...) {
+		if u.Host != "github.com" {
+			continue
+		}
+
+		labels, err := issueLabels(githubRef(u))
+		if err != nil {
+			die("unable to fetch labels for %v: %v", u, err)
+		}
+
+		for _, label := range labels {
+			if !strings.HasPrefix(label, prefix) {
+				continue
+			}
+
+			name := strings.TrimPrefix(label, prefix)
+			if _, ok := seen[name]; ok {
+				continue
+			}
+			seen[name] = struct{}{}
+			e.Components = append(e.Components, name)
+		}
+	}
+
+	sort.Strings(e.Components)
+}
+
+// groupByComponent groups the entries by component, sorted by name. Entries
+// with several components are listed in each group, entries without any
+// component are listed in a last group without name. The order of the
+// entries is kept within each group.
+func groupByComponent(entries []changelog.Entry) (groups []EntryGroup) {
+	index := make(map[string]int)
+	var other []changelog.Entry
+
+	for _, e := range entries {
+		if len(e.Components) == 0 {
+			other = append(other, e)
+			continue
+		}
+
+		for _, name := range e.Components {
+			i, ok := index[name]
+			if !ok {
+				i = len(groups)
+				index[name] = i
+				groups = append(groups, EntryGroup{Name: name})
+			}
+			groups[i].Entries = append(groups[i].Entries, e)
+		}
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		return groups[i].Name < groups[j].Name
+	})
+
+	if len(other) > 0 {
+		groups = append(groups, EntryGroup{Entries: other})
+	}
+
+	return groups
+}
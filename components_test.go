@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/restic/calens/changelog"
+)
+
+func TestGroupByComponent(t *testing.T) {
+	entries := []changelog.Entry{
+		{Title: "A", Components: []string{"backup"}},
+		{Title: "B"},
+		{Title: "C", Components: []string{"backup", "archiver"}},
+		{Title: "D", Components: []string{"archiver"}},
+	}
+
+	groups := groupByComponent(entries)
+
+	var got [][]string
+	for _, g := range groups {
+		titles := []string{g.Name}
+		for _, e := range g.Entries {
+			titles = append(titles, e.Title)
+		}
+		got = append(got, titles)
+	}
+
+	want := [][]string{
+		{"archiver", "C", "D"},
+		{"backup", "A", "C"},
+		{"", "B"},
+	}
+
+	if diff := deep.Equal(want, got); diff != nil {
+		t.Error(diff)
+	}
+}
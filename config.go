@@ -0,0 +1,496 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/restic/calens/changelog"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// configFiles are the files tried in order if --config is not set.
+var configFiles = []string{".calens.yml", filepath.Join("changelog", "config.yml")}
+
+// loadedConfigs lists the local config files read by loadConfig, including
+// the ones named with "extends".
+var loadedConfigs []string
+
+// isConfigFile reports whether filename is one of the config files read by
+// loadConfig, e.g. changelog/config.yml, which is not an entry.
+func isConfigFile(filename string) bool {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return false
+	}
+
+	for _, name := range loadedConfigs {
+		if cfg, err := os.Stat(name); err == nil && os.SameFile(fi, cfg) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// configSections contains the keys in the config file which are not flags
+// but sections decoded into Config.
+var configSections = map[string]struct{}{
+	"types":          {},
+	"required-links": {},
+	"links":          {},
+	"bump":           {},
+	"checklist":      {},
+	"title-rules":    {},
+	"post-process":   {},
+}
+
+// Config contains the sections of the config file which do not correspond to
+// command line options.
+type Config struct {
+	Types []EntryTypeConfig `yaml:"types"`
+
+	// RequiredLinks sets the kinds of links required for each entry type,
+	// see RequiredLinks.
+	RequiredLinks map[string][]string `yaml:"required-links"`
+
+	// InternalComponents lists the components which are dropped by the
+	// user-facing preset, see InternalComponents.
+	InternalComponents []string `yaml:"internal-components"`
+
+	// Links classifies the links matching a pattern.
+	Links []LinkConfig `yaml:"links"`
+
+	// Bump lists the files updated by "calens bump".
+	Bump []BumpConfig `yaml:"bump"`
+
+	// Checklist lists the steps for "calens checklist".
+	Checklist []ChecklistConfig `yaml:"checklist"`
+
+	// TitleRules lists additional rules for entry titles.
+	TitleRules []TitleRuleConfig `yaml:"title-rules"`
+
+	// PostProcess lists the commands the output is piped through.
+	PostProcess []PostProcessConfig `yaml:"post-process"`
+}
+
+// PostProcessConfig is a command the output is piped through before it is
+// written, e.g. "prettier --parser markdown". The output is passed on stdin,
+// or in a temporary file if an argument of Command is "{file}". The command
+// prints the processed output to stdout. With Output, a pattern like
+// "*.html", the command only runs if the base name of --output matches.
+type PostProcessConfig struct {
+	Command string `yaml:"command"`
+	Output  string `yaml:"output"`
+}
+
+// TitleRuleConfig requires the title of each entry (without the type) to
+// match the regular expression Pattern, e.g. "^[A-Z][a-z]+ " for titles
+// starting with a capitalized word. Message is reported for entries which
+// don't match.
+type TitleRuleConfig struct {
+	Pattern string `yaml:"pattern"`
+	Message string `yaml:"message"`
+}
+
+// ChecklistConfig is a step of the release checklist. Step is a template
+// which gets the release data (like the templates for the changelog). If is
+// a template expression like `hasType .Entries "Security"`, the step is only
+// listed if it is true.
+type ChecklistConfig struct {
+	Step string `yaml:"step"`
+	If   string `yaml:"if"`
+}
+
+// BumpConfig replaces the matches of the regular expression Pattern in File
+// with Replace, a template which gets the new version as .Version. Replace
+// may refer to groups of Pattern as $1. Without Pattern, the whole file is
+// replaced.
+type BumpConfig struct {
+	File    string `yaml:"file"`
+	Pattern string `yaml:"pattern"`
+	Replace string `yaml:"replace"`
+}
+
+// LinkConfig classifies the links whose host and path match Pattern, a
+// regular expression whose first group is the ID, as Kind: "issue", "pr", or
+// a custom category.
+type LinkConfig struct {
+	Pattern string `yaml:"pattern"`
+	Kind    string `yaml:"kind"`
+}
+
+// EntryTypeConfig defines an entry type in the config file. Defining one of
+// the built-in types changes its priority, abbreviation or emoji.
+type EntryTypeConfig struct {
+	Name         string `yaml:"name"`
+	Priority     int    `yaml:"priority"`
+	Abbreviation string `yaml:"abbreviation"`
+	Emoji        string `yaml:"emoji"`
+}
+
+// apply registers the entry types with changelog.EntryTypePriority,
+// EntryTypeAbbreviation and EntryTypeEmoji and sets RequiredLinks,
+// InternalComponents, opts.LinkRules, bumpRules, checklistSteps,
+// opts.TitleRules and postProcessors. Types without a priority are sorted
+// after all other types, types without an abbreviation are abbreviated with
+// the first three letters.
+func (cfg Config) apply() error {
+	for _, typ := range cfg.Types {
+		name := changelog.Capitalize(typ.Name)
+		if name == "" || strings.ContainsAny(name, ": ") {
+			return fmt.Errorf("invalid entry type name %q", typ.Name)
+		}
+
+		prio := typ.Priority
+		if prio == 0 {
+			prio = changelog.EntryTypePriority[name]
+		}
+		if prio == 0 {
+			for _, p := range changelog.EntryTypePriority {
+				if p >= prio {
+					prio = p + 1
+				}
+			}
+		}
+		changelog.EntryTypePriority[name] = prio
+
+		abbrev := typ.Abbreviation
+		if abbrev == "" {
+			abbrev = changelog.EntryTypeAbbreviation[name]
+		}
+		if abbrev == "" {
+			abbrev = name
+			if len(abbrev) > 3 {
+				abbrev = abbrev[:3]
+			}
+		}
+		changelog.EntryTypeAbbreviation[name] = abbrev
+
+		if typ.Emoji != "" {
+			changelog.EntryTypeEmoji[name] = typ.Emoji
+		}
+	}
+
+	for _, component := range cfg.InternalComponents {
+		InternalComponents[strings.ToLower(component)] = true
+	}
+
+	for typ, kinds := range cfg.RequiredLinks {
+		name := changelog.Capitalize(typ)
+		if _, ok := changelog.EntryTypePriority[name]; !ok {
+			return fmt.Errorf("required-links: unknown entry type %q", typ)
+		}
+
+		for _, kind := range kinds {
+			switch kind {
+			case "issue", "pr", "advisory", "other", "url":
+			default:
+				return fmt.Errorf("required-links: invalid kind %q for type %v, valid kinds: issue, pr, advisory, other, url", kind, typ)
+			}
+		}
+
+		if kinds == nil {
+			kinds = []string{}
+		}
+		changelog.RequiredLinks[name] = kinds
+	}
+
+	for _, link := range cfg.Links {
+		if link.Kind == "" {
+			return fmt.Errorf("links: no kind set for pattern %q", link.Pattern)
+		}
+
+		pattern, err := regexp.Compile("^(?:" + link.Pattern + ")")
+		if err != nil {
+			return fmt.Errorf("links: invalid pattern %q: %v", link.Pattern, err)
+		}
+
+		if pattern.NumSubexp() == 0 {
+			return fmt.Errorf("links: pattern %q has no group for the ID", link.Pattern)
+		}
+
+		opts.LinkRules = append(opts.LinkRules, changelog.LinkRule{Pattern: pattern, Kind: link.Kind})
+	}
+
+	for _, bump := range cfg.Bump {
+		if bump.File == "" {
+			return errors.New("bump: no file set")
+		}
+
+		rule := bumpRule{file: bump.File}
+		if bump.Pattern != "" {
+			pattern, err := regexp.Compile(bump.Pattern)
+			if err != nil {
+				return fmt.Errorf("bump: invalid pattern %q for %v: %v", bump.Pattern, bump.File, err)
+			}
+			rule.pattern = pattern
+		}
+
+		replace, err := template.New("").Parse(bump.Replace)
+		if err != nil {
+			return fmt.Errorf("bump: invalid replacement for %v: %v", bump.File, err)
+		}
+		rule.replace = replace
+
+		bumpRules = append(bumpRules, rule)
+	}
+
+	for _, item := range cfg.Checklist {
+		step, err := newChecklistStep(item)
+		if err != nil {
+			return err
+		}
+		checklistSteps = append(checklistSteps, step)
+	}
+
+	for _, rule := range cfg.TitleRules {
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return fmt.Errorf("title-rules: invalid pattern %q: %v", rule.Pattern, err)
+		}
+
+		msg := rule.Message
+		if msg == "" {
+			msg = fmt.Sprintf("title does not match %q", rule.Pattern)
+		}
+
+		opts.TitleRules = append(opts.TitleRules, changelog.TitleRule{Pattern: pattern, Message: msg})
+	}
+
+	for _, item := range cfg.PostProcess {
+		p, err := newPostProcessor(item)
+		if err != nil {
+			return err
+		}
+		postProcessors = append(postProcessors, p)
+	}
+
+	return nil
+}
+
+// loadConfig reads the config file set with --config, or the first one of
+// configFiles which exists, see applyConfig.
+func loadConfig() {
+	filename := opts.Config
+	if filename == "" {
+		filename = findConfig(configFiles)
+	}
+
+	if filename == "" {
+		return
+	}
+
+	err := applyConfig(filename)
+	if err != nil {
+		die("%v", err)
+	}
+}
+
+// findConfig returns the first file in names which exists, or the empty
+// string if there is none.
+func findConfig(names []string) string {
+	for _, name := range names {
+		if _, err := os.Stat(name); err == nil {
+			return name
+		}
+	}
+	return ""
+}
+
+// applyConfig reads the config file filename. Each top-level key in the
+// config file sets the flag of the same name, unless it was given on the
+// command line. Lists set flags which can be specified multiple times, maps
+// set flags which take key=value pairs. The other sections are described in
+// Config.
+func applyConfig(filename string) error {
+	values, err := readConfig(filename, "", nil)
+	if err != nil {
+		return err
+	}
+
+	// apply the options in a stable order
+	var keys []string
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if _, ok := configSections[key]; ok {
+			continue
+		}
+
+		err := setFlag(key, values[key])
+		if err != nil {
+			return fmt.Errorf("config %v: %v", filename, err)
+		}
+	}
+
+	// decode the sections from the merged values
+	buf, err := yaml.Marshal(values)
+	if err != nil {
+		return fmt.Errorf("config %v: %v", filename, err)
+	}
+
+	var cfg Config
+	err = yaml.Unmarshal(buf, &cfg)
+	if err != nil {
+		return fmt.Errorf("unable to parse config %v: %v", filename, err)
+	}
+
+	err = cfg.apply()
+	if err != nil {
+		return fmt.Errorf("config %v: %v", filename, err)
+	}
+
+	return nil
+}
+
+// isURL reports whether name is an http(s) URL rather than a file name.
+func isURL(name string) bool {
+	return strings.HasPrefix(name, "https://") || strings.HasPrefix(name, "http://")
+}
+
+// readConfig returns the top-level keys of the config file name, which is
+// either a file or an http(s) URL. If the config has the key "extends", the
+// base config it names is read first and the keys of name override its keys.
+// Relative file names in "extends" are resolved relative to name. A base
+// config read from a URL must be pinned with its SHA256 checksum in
+// "extends-sha256", which is checked like sum. The list seen contains the
+// configs already read, to detect loops.
+func readConfig(name, sum string, seen []string) (map[string]interface{}, error) {
+	for _, s := range seen {
+		if s == name {
+			return nil, fmt.Errorf("config %v extends itself", name)
+		}
+	}
+	seen = append(seen, name)
+
+	var buf []byte
+	var err error
+	if isURL(name) {
+		buf, err = download(name)
+	} else {
+		buf, err = ioutil.ReadFile(name)
+		loadedConfigs = append(loadedConfigs, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read config: %v", err)
+	}
+
+	if sum != "" {
+		err = checkSHA256(buf, sum)
+		if err != nil {
+			return nil, fmt.Errorf("config %v: %v", name, err)
+		}
+	}
+
+	var values map[string]interface{}
+	err = yaml.Unmarshal(buf, &values)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse config %v: %v", name, err)
+	}
+	if values == nil {
+		values = make(map[string]interface{})
+	}
+
+	base, ok := values["extends"]
+	if !ok {
+		if _, ok := values["extends-sha256"]; ok {
+			return nil, fmt.Errorf("config %v: extends-sha256 without extends", name)
+		}
+		return values, nil
+	}
+	baseSum, _ := values["extends-sha256"].(string)
+	delete(values, "extends")
+	delete(values, "extends-sha256")
+
+	baseName, ok := base.(string)
+	if !ok || baseName == "" {
+		return nil, fmt.Errorf("config %v: extends needs a file name or URL", name)
+	}
+
+	switch {
+	case isURL(baseName) || filepath.IsAbs(baseName):
+	case isURL(name):
+		u, err := url.Parse(name)
+		if err != nil {
+			return nil, fmt.Errorf("config %v: %v", name, err)
+		}
+		ref, err := url.Parse(baseName)
+		if err != nil {
+			return nil, fmt.Errorf("config %v: %v", name, err)
+		}
+		baseName = u.ResolveReference(ref).String()
+	default:
+		baseName = filepath.Join(filepath.Dir(name), baseName)
+	}
+
+	if isURL(baseName) && baseSum == "" {
+		return nil, fmt.Errorf("config %v: extends-sha256 is required for the base config %v", name, baseName)
+	}
+
+	result, err := readConfig(baseName, baseSum, seen)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, value := range values {
+		result[key] = value
+	}
+
+	return result, nil
+}
+
+// setFlag sets the flag name to value, unless it was set on the command line.
+func setFlag(name string, value interface{}) error {
+	flag := pflag.Lookup(name)
+	if flag == nil || name == "config" {
+		return fmt.Errorf("unknown option %q", name)
+	}
+
+	if flag.Changed {
+		return nil
+	}
+
+	var values []interface{}
+	switch v := value.(type) {
+	case []interface{}:
+		values = v
+	case map[string]interface{}:
+		// maps set options which take key=value pairs
+		var keys []string
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			values = append(values, fmt.Sprintf("%v=%v", key, v[key]))
+		}
+	default:
+		values = []interface{}{v}
+	}
+
+	for _, v := range values {
+		if v == nil {
+			continue
+		}
+
+		err := pflag.Set(name, fmt.Sprint(v))
+		if err != nil {
+			return fmt.Errorf("option %q: %v", name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/restic/calens/changelog"
+	"github.com/spf13/pflag"
+)
+
+func TestLoadConfig(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "config.yml")
+	err := ioutil.WriteFile(filename, []byte("max-paragraphs: 3\nversioning: calver\nset:\n  - tagline=fast\n  - docs=https://example.com\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// simulate --versioning on the command line
+	err = pflag.Set("versioning", "semver")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		for _, name := range []string{"config", "max-paragraphs", "versioning", "set"} {
+			pflag.Lookup(name).Changed = false
+		}
+		opts.Config = ""
+		opts.MaxParagraphs = 0
+		opts.Set = nil
+	}()
+
+	opts.Config = filename
+	loadConfig()
+
+	if opts.MaxParagraphs != 3 {
+		t.Errorf("max-paragraphs not set from config, got %v", opts.MaxParagraphs)
+	}
+
+	if opts.Versioning != "semver" {
+		t.Errorf("versioning set on the command line was overwritten, got %v", opts.Versioning)
+	}
+
+	if diff := deep.Equal([]string{"tagline=fast", "docs=https://example.com"}, opts.Set); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestSetFlagUnknown(t *testing.T) {
+	if err := setFlag("no-such-option", "foo"); err == nil {
+		t.Error("expected error for unknown option")
+	}
+}
+
+func TestConfigTypes(t *testing.T) {
+	defer func(prio map[string]int, abbrev, emoji map[string]string) {
+		changelog.EntryTypePriority = prio
+		changelog.EntryTypeAbbreviation = abbrev
+		changelog.EntryTypeEmoji = emoji
+	}(changelog.EntryTypePriority, changelog.EntryTypeAbbreviation, changelog.EntryTypeEmoji)
+
+	changelog.EntryTypePriority = map[string]int{"Security": 1, "Bugfix": 2, "Change": 3, "Enhancement": 4}
+	changelog.EntryTypeAbbreviation = map[string]string{"Security": "Sec", "Bugfix": "Fix", "Change": "Chg", "Enhancement": "Enh"}
+	changelog.EntryTypeEmoji = map[string]string{"Bugfix": "🐛"}
+
+	cfg := Config{
+		Types: []EntryTypeConfig{
+			{Name: "deprecation", Priority: 5, Abbreviation: "Dep", Emoji: "⚠️"},
+			{Name: "Performance"},
+			{Name: "Bugfix", Abbreviation: "Bug"},
+		},
+	}
+
+	err := cfg.apply()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantPrio := map[string]int{"Security": 1, "Bugfix": 2, "Change": 3, "Enhancement": 4, "Deprecation": 5, "Performance": 6}
+	if diff := deep.Equal(wantPrio, changelog.EntryTypePriority); diff != nil {
+		t.Error(diff)
+	}
+
+	wantAbbrev := map[string]string{"Security": "Sec", "Bugfix": "Bug", "Change": "Chg", "Enhancement": "Enh", "Deprecation": "Dep", "Performance": "Per"}
+	if diff := deep.Equal(wantAbbrev, changelog.EntryTypeAbbreviation); diff != nil {
+		t.Error(diff)
+	}
+
+	wantEmoji := map[string]string{"Bugfix": "🐛", "Deprecation": "⚠️"}
+	if diff := deep.Equal(wantEmoji, changelog.EntryTypeEmoji); diff != nil {
+		t.Error(diff)
+	}
+
+	e := changelog.Entry{Type: "Performance", Title: "Speed up restore", PrimaryID: 1}
+	if err := opts.Validate(e); err != nil {
+		t.Errorf("configured type rejected: %v", err)
+	}
+}
+
+func TestApplyTypeOptions(t *testing.T) {
+	defer func(prio map[string]int, abbrev, emoji map[string]string) {
+		changelog.EntryTypePriority = prio
+		changelog.EntryTypeAbbreviation = abbrev
+		changelog.EntryTypeEmoji = emoji
+		opts.TypePriority = nil
+		opts.TypeAbbreviation = nil
+		opts.TypeEmoji = nil
+	}(changelog.EntryTypePriority, changelog.EntryTypeAbbreviation, changelog.EntryTypeEmoji)
+
+	changelog.EntryTypePriority = map[string]int{"Security": 1, "Bugfix": 2, "Change": 3, "Enhancement": 4}
+	changelog.EntryTypeAbbreviation = map[string]string{"Security": "Sec", "Bugfix": "Fix", "Change": "Chg", "Enhancement": "Enh"}
+	changelog.EntryTypeEmoji = map[string]string{"Bugfix": "🐛"}
+
+	opts.TypePriority = []string{"bugfix", "Enhancement"}
+	opts.TypeAbbreviation = map[string]string{"Bugfix": "Bug"}
+	opts.TypeEmoji = map[string]string{"bugfix": "🪲"}
+
+	err := applyTypeOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := deep.Equal([]string{"Bugfix", "Enhancement", "Security", "Change"}, changelog.EntryTypes()); diff != nil {
+		t.Error(diff)
+	}
+
+	if changelog.EntryTypeAbbreviation["Bugfix"] != "Bug" {
+		t.Errorf("abbreviation not overridden: %v", changelog.EntryTypeAbbreviation)
+	}
+
+	if changelog.EntryTypeEmoji["Bugfix"] != "🪲" {
+		t.Errorf("emoji not overridden: %v", changelog.EntryTypeEmoji)
+	}
+
+	opts.TypePriority = []string{"Feature"}
+	if err := applyTypeOptions(); err == nil {
+		t.Error("expected error for unknown type")
+	}
+}
+
+func TestRequiredLinks(t *testing.T) {
+	defer func() {
+		changelog.RequiredLinks = map[string][]string{}
+	}()
+
+	cfg := Config{
+		RequiredLinks: map[string][]string{
+			"Security": {"issue", "advisory", "other"},
+			"change":   nil,
+		},
+	}
+
+	err := cfg.apply()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		changelog.Entry
+		Valid bool
+	}{
+		{changelog.Entry{Title: "Foo", Type: "Change"}, true},
+		{changelog.Entry{Title: "Foo", Type: "Bugfix"}, false},
+		{changelog.Entry{Title: "Foo", Type: "Bugfix", PrimaryID: 12}, true},
+		{changelog.Entry{Title: "Foo", Type: "Security", PRs: []string{"12"}, PrimaryID: 12}, false},
+		{changelog.Entry{Title: "Foo", Type: "Security", OtherURLs: []*url.URL{parseURL(t, "https://example.com/advisory")}}, true},
+		{changelog.Entry{Title: "Foo", Type: "Security", Advisories: []string{"GHSA-r5gh-p3vq-w6x2"}}, true},
+	}
+
+	for _, test := range tests {
+		err := opts.Validate(test.Entry)
+		if test.Valid && err != nil {
+			t.Errorf("%v: unexpected error %v", test.Type, err)
+		}
+		if !test.Valid && err == nil {
+			t.Errorf("%v: expected error", test.Type)
+		}
+	}
+
+	cfg = Config{RequiredLinks: map[string][]string{"Security": {"ghsa"}}}
+	if err := cfg.apply(); err == nil {
+		t.Error("expected error for invalid kind")
+	}
+}
+
+func TestReadConfigExtends(t *testing.T) {
+	checksum := func(data string) string {
+		hash := sha256.Sum256([]byte(data))
+		return hex.EncodeToString(hash[:])
+	}
+
+	base := "max-paragraphs: 2\nrequire-body: [Security]\ntypes:\n  - name: Deprecation\n"
+	org := "extends: base.yml\nextends-sha256: " + checksum(base) + "\nmax-paragraphs: 3\n"
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/calens/base.yml":
+			_, _ = io.WriteString(w, base)
+		case "/calens/org.yml":
+			_, _ = io.WriteString(w, org)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	writeConfig := func(name, data string) string {
+		filename := filepath.Join(dir, name)
+		err := ioutil.WriteFile(filename, []byte(data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return filename
+	}
+
+	filename := writeConfig("config.yml", "extends: shared.yml\nversioning: calver\n")
+	writeConfig("shared.yml", "extends: "+srv.URL+"/calens/org.yml\nextends-sha256: "+checksum(org)+"\nmax-body-length: 500\nversioning: semver\n")
+
+	values, err := readConfig(filename, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]interface{}{
+		"max-paragraphs":  3,
+		"max-body-length": 500,
+		"versioning":      "calver",
+		"require-body":    []interface{}{"Security"},
+		"types":           []interface{}{map[string]interface{}{"name": "Deprecation"}},
+	}
+	if diff := deep.Equal(want, values); diff != nil {
+		t.Error(diff)
+	}
+
+	for name, data := range map[string]string{
+		"loop.yml":     "extends: loop.yml\n",
+		"unpinned.yml": "extends: " + srv.URL + "/calens/base.yml\n",
+		"mismatch.yml": "extends: " + srv.URL + "/calens/base.yml\nextends-sha256: " + checksum(org) + "\n",
+		"nobase.yml":   "extends-sha256: " + checksum(base) + "\n",
+	} {
+		if _, err := readConfig(writeConfig(name, data), "", nil); err == nil {
+			t.Errorf("%v: expected error", name)
+		}
+	}
+}
+
+func TestConfigLinks(t *testing.T) {
+	rules := opts.LinkRules
+	defer func() {
+		opts.LinkRules = rules
+	}()
+	opts.LinkRules = nil
+
+	cfg := Config{
+		Links: []LinkConfig{
+			{Pattern: `git\.example\.com/team/[^/]+/tickets/(\d+)`, Kind: "issue"},
+			{Pattern: `git\.example\.com/team/[^/]+/reviews/(\d+)`, Kind: "pr"},
+			{Pattern: `datatracker\.ietf\.org/doc/html/rfc(\d+)`, Kind: "rfc"},
+		},
+	}
+
+	err := cfg.apply()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules, err = opts.ForgeRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.LinkRules = append(opts.LinkRules, rules...)
+
+	e := readFile(writeEntry(t, "Security: Fix leak\n\nhttps://git.example.com/team/app/reviews/7\nhttps://git.example.com/team/app/tickets/5\nhttps://datatracker.ietf.org/doc/html/rfc9110\nhttps://example.com/git.example.com/team/app/tickets/6\n"))
+
+	if diff := deep.Equal([]string{"5"}, e.Issues); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal([]string{"7"}, e.PRs); diff != nil {
+		t.Error(diff)
+	}
+	if e.PrimaryID != 7 {
+		t.Errorf("wrong primary ID %v", e.PrimaryID)
+	}
+	if diff := deep.Equal(map[string][]string{"rfc": {"9110"}}, e.Refs); diff != nil {
+		t.Error(diff)
+	}
+	if len(e.OtherURLs) != 2 {
+		t.Errorf("wrong other URLs %v", e.OtherURLs)
+	}
+
+	for _, link := range []LinkConfig{{Pattern: `example\.com/(\d+)`}, {Pattern: `example\.com/\d+`, Kind: "issue"}, {Pattern: `(`, Kind: "issue"}} {
+		if err := (Config{Links: []LinkConfig{link}}).apply(); err == nil {
+			t.Errorf("expected error for %v", link)
+		}
+	}
+}
+
+func TestConfigTitleRules(t *testing.T) {
+	defer func() {
+		opts.TitleRules = nil
+		opts.TitlePunctuation = changelog.Punctuation
+	}()
+
+	cfg := Config{
+		TitleRules: []TitleRuleConfig{
+			{Pattern: `^(Add|Fix) `, Message: "title must start with a verb"},
+			{Pattern: `[a-z]$`},
+		},
+	}
+
+	err := cfg.apply()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		title string
+		err   string
+	}{
+		{"Fix crash on startup", ""},
+		{"Crash on startup fixed", "title must start with a verb"},
+		{"Add support for ZIP", `title does not match "[a-z]$"`},
+		{"Fix crash on startup.", `title ends with punctuation, e.g. a character out of ".!?"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			e := changelog.Entry{Type: "Bugfix", Title: test.title, PrimaryID: 1}
+			err := opts.Validate(e)
+			if test.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			var invalid *changelog.ErrInvalidTitle
+			if !errors.As(err, &invalid) || invalid.Reason != test.err {
+				t.Fatalf("want reason %q, got %v", test.err, err)
+			}
+		})
+	}
+
+	// with an empty list of characters, titles may end with punctuation
+	opts.TitleRules = nil
+	opts.TitlePunctuation = ""
+	e := changelog.Entry{Type: "Bugfix", Title: "Fix crash on startup?", PrimaryID: 1}
+	if err := opts.Validate(e); err != nil {
+		t.Errorf("unexpected error with punctuation rule disabled: %v", err)
+	}
+	if _, problems := fixTitle("Bugfix: Fix crash on startup?"); len(problems) != 0 {
+		t.Errorf("unexpected problems with punctuation rule disabled: %v", problems)
+	}
+
+	cfg = Config{TitleRules: []TitleRuleConfig{{Pattern: "("}}}
+	if err := cfg.apply(); err == nil {
+		t.Error("expected error for invalid pattern")
+	}
+}
+
+func TestConfigInternalComponents(t *testing.T) {
+	defer func() {
+		InternalComponents = map[string]bool{}
+	}()
+
+	err := Config{InternalComponents: []string{"CI", "tests"}}.apply()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := deep.Equal(map[string]bool{"ci": true, "tests": true}, InternalComponents); diff != nil {
+		t.Error(diff)
+	}
+}
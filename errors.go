@@ -0,0 +1,31 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/restic/calens/changelog"
+)
+
+// errorPosition returns the line (starting at 1, or 0 if unknown) and the
+// message without the position for an error returned by parseEntry for
+// filename.
+func errorPosition(filename string, err error) (line int, msg string) {
+	var titleErr *changelog.ErrInvalidTitle
+	var typeErr *changelog.ErrUnknownType
+	var encErr *changelog.ErrInvalidEncoding
+	switch {
+	case errors.As(err, &titleErr):
+		return titleErr.Line, titleErr.Reason
+	case errors.As(err, &typeErr):
+		e := *typeErr
+		e.File, e.Line = "", 0
+		return typeErr.Line, e.Error()
+	case errors.As(err, &encErr):
+		e := *encErr
+		e.File, e.Line = "", 0
+		return encErr.Line, e.Error()
+	default:
+		return 0, strings.TrimPrefix(err.Error(), "file "+filename+": ")
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/restic/calens/changelog"
+)
+
+// runExplain implements the "explain" command: it prints how the entry files
+// in args were parsed, so authors can find out why an entry is rendered
+// unexpectedly.
+func runExplain(args []string) {
+	if len(args) == 0 {
+		die("usage: calens explain FILE...")
+	}
+
+	failed := false
+	for i, file := range args {
+		if i > 0 {
+			fmt.Println()
+		}
+
+		err := explain(os.Stdout, file)
+		if err != nil {
+			line, msg := errorPosition(file, err)
+			if line > 0 {
+				fmt.Printf("error: line %d: %v\n", line, msg)
+			} else {
+				fmt.Printf("error: %v\n", msg)
+			}
+			failed = true
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// explain writes the fields of the entry in filename to wr, followed by
+// warnings about problems which do not make the entry invalid.
+func explain(wr io.Writer, filename string) error {
+	fmt.Fprintf(wr, "file:       %v\n", filename)
+
+	e, err := opts.ParseFile(filename)
+	if err != nil {
+		return err
+	}
+
+	typ := e.Type
+	if e.Breaking {
+		typ += " (breaking)"
+	}
+	fmt.Fprintf(wr, "type:       %v\n", typ)
+	if e.Component != "" {
+		fmt.Fprintf(wr, "component:  %v\n", e.Component)
+	}
+	fmt.Fprintf(wr, "title:      %v\n", e.Title)
+	if e.Order > 0 {
+		fmt.Fprintf(wr, "order:      %d\n", e.Order)
+	}
+
+	fmt.Fprintf(wr, "paragraphs: %d\n", len(e.Paragraphs))
+	for i, par := range e.Paragraphs {
+		text, _ := wrapIndent(par, 72, 6)
+		fmt.Fprintf(wr, "  %2d: %v\n", i+1, text)
+	}
+
+	fmt.Fprintf(wr, "links:      %d\n", len(e.URLs))
+	for _, u := range e.URLs {
+		kind, id := opts.ClassifyURL(u)
+		switch kind {
+		case "":
+			fmt.Fprintf(wr, "  %v: other\n", u)
+		default:
+			fmt.Fprintf(wr, "  %v: %v %v\n", u, kind, id)
+		}
+	}
+
+	if e.PrimaryURL != nil {
+		fmt.Fprintf(wr, "primary:    %v (%v)\n", e.PrimaryID, e.PrimaryURL)
+	} else {
+		fmt.Fprintf(wr, "primary:    none\n")
+	}
+
+	if len(e.Authors) > 0 {
+		fmt.Fprintf(wr, "authors:    %v\n", strings.Join(e.Authors, ", "))
+	}
+
+	warnings := explainWarnings(filename, e)
+	if len(warnings) > 0 {
+		fmt.Fprintf(wr, "warnings:\n")
+		for _, w := range warnings {
+			fmt.Fprintf(wr, "  %v\n", w)
+		}
+	}
+
+	return nil
+}
+
+// explainWarnings returns the problems found in the valid entry e read from
+// filename: problems in the title line reported by lint, and the reasons why
+// the entry is not rendered with the current options.
+func explainWarnings(filename string, e changelog.Entry) (warnings []string) {
+	buf, err := ioutil.ReadFile(filename)
+	if err == nil {
+		lines := strings.Split(string(changelog.NormalizeText(buf)), "\n")
+		_, problems := fixTitle(lines[changelog.TitleLineIndex(lines)])
+		warnings = append(warnings, problems...)
+	}
+
+	if len(e.URLs) == 0 {
+		warnings = append(warnings, "no links found, the links are read from the last paragraph")
+	}
+
+	switch {
+	case e.Draft:
+		warnings = append(warnings, "entry is a draft and not rendered")
+	case e.Visibility == "internal" && !opts.Internal:
+		warnings = append(warnings, "entry is internal and only rendered with --internal")
+	case !keepEntry(e):
+		warnings = append(warnings, fmt.Sprintf("entry is not rendered with --preset %v --audience %q", opts.Preset, opts.Audience))
+	}
+
+	return warnings
+}
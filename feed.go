@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/restic/calens/changelog"
+)
+
+// OSVEntry is a simplified vulnerability record in the OSV format, see
+// https://ossf.github.io/osv-schema/
+type OSVEntry struct {
+	ID         string         `json:"id"`
+	Published  string         `json:"published,omitempty"`
+	Aliases    []string       `json:"aliases,omitempty"`
+	Summary    string         `json:"summary"`
+	Details    string         `json:"details,omitempty"`
+	Affected   []OSVAffected  `json:"affected"`
+	References []OSVReference `json:"references,omitempty"`
+}
+
+// OSVAffected describes the affected versions of a package.
+type OSVAffected struct {
+	Package struct {
+		Name string `json:"name"`
+	} `json:"package"`
+	Ranges []OSVRange `json:"ranges"`
+}
+
+// OSVRange is a range of affected versions.
+type OSVRange struct {
+	Type   string              `json:"type"`
+	Events []map[string]string `json:"events"`
+}
+
+// OSVReference is a link with more information.
+type OSVReference struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+// affectedEvents returns the events of the range of versions affected by the
+// Security entry e, which is contained in the release version. Without
+// affected ranges in the front matter, all versions before the release are
+// affected.
+func affectedEvents(e changelog.Entry, version string) (events []map[string]string) {
+	if len(e.Affected) == 0 {
+		return []map[string]string{{"introduced": "0"}, {"fixed": version}}
+	}
+
+	for _, r := range e.Affected {
+		fixed := r.Fixed
+		if fixed == "" {
+			fixed = version
+		}
+		events = append(events, map[string]string{"introduced": r.Introduced}, map[string]string{"fixed": fixed})
+	}
+
+	return events
+}
+
+// runSecurityFeed implements the "security-feed" command: it writes a JSON
+// list of OSV records for all Security entries in released versions. Each
+// record lists the affected versions from the front matter of the entry, or
+// all versions before the release.
+func runSecurityFeed(args []string) {
+	if len(args) != 0 || opts.PackageName == "" {
+		die("usage: calens security-feed --package-name NAME")
+	}
+
+	rangeType := "SEMVER"
+	if opts.Versioning != "semver" {
+		rangeType = "ECOSYSTEM"
+	}
+
+	var released []changelog.Release
+	for _, rel := range readReleases(opts.InputDir) {
+		if rel.Date != nil {
+			released = append(released, rel)
+		}
+	}
+
+	feed := []OSVEntry{}
+	for _, vc := range buildChanges(released) {
+		for _, e := range vc.Entries {
+			if e.Type != "Security" {
+				continue
+			}
+
+			entry := OSVEntry{
+				ID:        fmt.Sprintf("%v-%v-%d", opts.PackageName, vc.Version, e.PrimaryID),
+				Published: vc.Date + "T00:00:00Z",
+				Summary:   e.Title,
+				Details:   strings.Join(e.Paragraphs, "\n\n"),
+			}
+
+			var ids []string
+			for _, cve := range e.CVEs {
+				ids = append(ids, cve.ID)
+			}
+			ids = append(ids, e.Advisories...)
+
+			if len(ids) > 0 {
+				entry.ID = ids[0]
+				entry.Aliases = ids[1:]
+			}
+
+			affected := OSVAffected{
+				Ranges: []OSVRange{{
+					Type:   rangeType,
+					Events: affectedEvents(e, vc.Version),
+				}},
+			}
+			affected.Package.Name = opts.PackageName
+			entry.Affected = append(entry.Affected, affected)
+
+			for _, u := range e.URLs {
+				entry.References = append(entry.References, OSVReference{Type: "WEB", URL: u.String()})
+			}
+
+			feed = append(feed, entry)
+		}
+	}
+
+	writeOutput(func(wr io.Writer) error {
+		enc := json.NewEncoder(wr)
+		enc.SetIndent("", "  ")
+		return enc.Encode(feed)
+	})
+}
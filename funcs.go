@@ -0,0 +1,217 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/mattn/go-runewidth"
+	"github.com/restic/calens/changelog"
+)
+
+// dateNames contains the localized month and weekday names for the date
+// template function.
+type dateNames struct {
+	Months      [12]string
+	ShortMonths [12]string
+	Days        [7]string
+	ShortDays   [7]string
+}
+
+// locales maps the supported locale names to the localized names.
+var locales = map[string]dateNames{
+	"en": {
+		Months:      [12]string{"January", "February", "March", "April", "May", "June", "July", "August", "September", "October", "November", "December"},
+		ShortMonths: [12]string{"Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec"},
+		Days:        [7]string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"},
+		ShortDays:   [7]string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"},
+	},
+	"de": {
+		Months:      [12]string{"Januar", "Februar", "März", "April", "Mai", "Juni", "Juli", "August", "September", "Oktober", "November", "Dezember"},
+		ShortMonths: [12]string{"Jan", "Feb", "Mär", "Apr", "Mai", "Jun", "Jul", "Aug", "Sep", "Okt", "Nov", "Dez"},
+		Days:        [7]string{"Sonntag", "Montag", "Dienstag", "Mittwoch", "Donnerstag", "Freitag", "Samstag"},
+		ShortDays:   [7]string{"So", "Mo", "Di", "Mi", "Do", "Fr", "Sa"},
+	},
+	"fr": {
+		Months:      [12]string{"janvier", "février", "mars", "avril", "mai", "juin", "juillet", "août", "septembre", "octobre", "novembre", "décembre"},
+		ShortMonths: [12]string{"janv.", "févr.", "mars", "avr.", "mai", "juin", "juil.", "août", "sept.", "oct.", "nov.", "déc."},
+		Days:        [7]string{"dimanche", "lundi", "mardi", "mercredi", "jeudi", "vendredi", "samedi"},
+		ShortDays:   [7]string{"dim.", "lun.", "mar.", "mer.", "jeu.", "ven.", "sam."},
+	},
+	"es": {
+		Months:      [12]string{"enero", "febrero", "marzo", "abril", "mayo", "junio", "julio", "agosto", "septiembre", "octubre", "noviembre", "diciembre"},
+		ShortMonths: [12]string{"ene", "feb", "mar", "abr", "may", "jun", "jul", "ago", "sept", "oct", "nov", "dic"},
+		Days:        [7]string{"domingo", "lunes", "martes", "miércoles", "jueves", "viernes", "sábado"},
+		ShortDays:   [7]string{"dom", "lun", "mar", "mié", "jue", "vie", "sáb"},
+	},
+	"it": {
+		Months:      [12]string{"gennaio", "febbraio", "marzo", "aprile", "maggio", "giugno", "luglio", "agosto", "settembre", "ottobre", "novembre", "dicembre"},
+		ShortMonths: [12]string{"gen", "feb", "mar", "apr", "mag", "giu", "lug", "ago", "set", "ott", "nov", "dic"},
+		Days:        [7]string{"domenica", "lunedì", "martedì", "mercoledì", "giovedì", "venerdì", "sabato"},
+		ShortDays:   [7]string{"dom", "lun", "mar", "mer", "gio", "ven", "sab"},
+	},
+	"nl": {
+		Months:      [12]string{"januari", "februari", "maart", "april", "mei", "juni", "juli", "augustus", "september", "oktober", "november", "december"},
+		ShortMonths: [12]string{"jan", "feb", "mrt", "apr", "mei", "jun", "jul", "aug", "sep", "okt", "nov", "dec"},
+		Days:        [7]string{"zondag", "maandag", "dinsdag", "woensdag", "donderdag", "vrijdag", "zaterdag"},
+		ShortDays:   [7]string{"zo", "ma", "di", "wo", "do", "vr", "za"},
+	},
+}
+
+// ordinal returns the day with an English ordinal suffix, e.g. "1st".
+func ordinal(day int) string {
+	suffix := "th"
+	switch {
+	case day%100 >= 11 && day%100 <= 13:
+	case day%10 == 1:
+		suffix = "st"
+	case day%10 == 2:
+		suffix = "nd"
+	case day%10 == 3:
+		suffix = "rd"
+	}
+	return strconv.Itoa(day) + suffix
+}
+
+// formatDate formats the date value according to the Go time layout and the
+// optional locale. The layout may contain "2nd" for the day with an English
+// ordinal suffix. The value may be a date string in the format YYYY-MM-DD, a
+// time.Time (or pointer to one) or a Unix timestamp. Other strings (like
+// "UNRELEASED") are returned unchanged.
+func formatDate(layout string, value interface{}, locale ...string) (string, error) {
+	var t time.Time
+	switch v := value.(type) {
+	case string:
+		var err error
+		t, err = time.Parse("2006-01-02", v)
+		if err != nil {
+			return v, nil
+		}
+	case time.Time:
+		t = v
+	case *time.Time:
+		if v == nil {
+			return "", nil
+		}
+		t = *v
+	case int:
+		t = time.Unix(int64(v), 0)
+	case int64:
+		t = time.Unix(v, 0)
+	default:
+		return "", fmt.Errorf("date: unsupported value %v of type %T", value, value)
+	}
+
+	names := locales["en"]
+	if len(locale) > 0 {
+		var ok bool
+		names, ok = locales[strings.ToLower(locale[0])]
+		if !ok {
+			return "", fmt.Errorf("date: unsupported locale %q", locale[0])
+		}
+	}
+
+	// replace the tokens with names by placeholders which are not
+	// interpreted by time.Format, the order is important
+	tokens := []struct {
+		token string
+		value string
+	}{
+		{"January", names.Months[t.Month()-1]},
+		{"Jan", names.ShortMonths[t.Month()-1]},
+		{"Monday", names.Days[t.Weekday()]},
+		{"Mon", names.ShortDays[t.Weekday()]},
+		{"2nd", ordinal(t.Day())},
+	}
+
+	var replacements []string
+	for i, tok := range tokens {
+		placeholder := fmt.Sprintf("\x00%c\x00", 'a'+i)
+		layout = strings.Replace(layout, tok.token, placeholder, -1)
+		replacements = append(replacements, placeholder, tok.value)
+	}
+
+	return strings.NewReplacer(replacements...).Replace(t.Format(layout)), nil
+}
+
+// templateVar returns the value of the variable name set with --set. If the
+// variable is not set, the default value is returned if given, otherwise an
+// error is returned so that typos in variable names are noticed.
+func templateVar(name string, def ...string) (string, error) {
+	v, ok := Changelog(nil).Meta().Vars[name]
+	if ok {
+		return v, nil
+	}
+
+	if len(def) > 0 {
+		return def[0], nil
+	}
+
+	return "", fmt.Errorf("variable %q is not set, use --set %v=value", name, name)
+}
+
+// countType returns the number of entries of type typ.
+func countType(entries []changelog.Entry, typ string) int {
+	count := 0
+	for _, e := range entries {
+		if strings.EqualFold(e.Type, typ) {
+			count++
+		}
+	}
+	return count
+}
+
+// hasType reports whether entries contains at least one entry of type typ.
+func hasType(entries []changelog.Entry, typ string) bool {
+	return countType(entries, typ) > 0
+}
+
+// versionURL returns the URL of the section or page for version according to
+// --version-url, where "{version}" is replaced by the version and "{anchor}"
+// by the version as used in Markdown heading anchors (e.g. "0150" for
+// "0.15.0"). A version with a prefix like "v0.15.0" is normalized first.
+func versionURL(version string) (string, error) {
+	if opts.VersionURL == "" {
+		return "", errors.New("versionURL: no URL pattern set, use --version-url")
+	}
+
+	if rel, err := opts.ParseVersion(version); err == nil {
+		version = rel.Version
+	}
+
+	anchor := strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_':
+			return unicode.ToLower(r)
+		case r == ' ':
+			return '-'
+		default:
+			return -1
+		}
+	}, version)
+
+	return strings.NewReplacer("{version}", version, "{anchor}", anchor).Replace(opts.VersionURL), nil
+}
+
+// pad pads text with spaces on the left to width display columns, so that
+// it is right-aligned in a column. Longer text is returned unchanged.
+func pad(width int, text interface{}) string {
+	s := fmt.Sprint(text)
+	if n := width - runewidth.StringWidth(s); n > 0 {
+		return strings.Repeat(" ", n) + s
+	}
+	return s
+}
+
+// rpad pads text with spaces on the right to width display columns, so that
+// it is left-aligned in a column. Longer text is returned unchanged.
+func rpad(width int, text interface{}) string {
+	s := fmt.Sprint(text)
+	if n := width - runewidth.StringWidth(s); n > 0 {
+		return s + strings.Repeat(" ", n)
+	}
+	return s
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/restic/calens/changelog"
+)
+
+func TestFormatDate(t *testing.T) {
+	var tests = []struct {
+		Layout string
+		Value  interface{}
+		Locale []string
+		Out    string
+	}{
+		{"January 2nd, 2006", "2024-07-01", nil, "July 1st, 2024"},
+		{"02.01.2006", "2024-07-01", nil, "01.07.2024"},
+		{"Monday, 2. January 2006", "2024-03-12", []string{"de"}, "Dienstag, 12. März 2024"},
+		{"2 Jan 2006", time.Date(2023, time.December, 23, 0, 0, 0, 0, time.UTC), []string{"fr"}, "23 déc. 2023"},
+		{"Jan 2nd", ptrTime(time.Date(2023, time.May, 13, 0, 0, 0, 0, time.UTC)), nil, "May 13th"},
+		{"January 2nd, 2006", "UNRELEASED", nil, "UNRELEASED"},
+	}
+
+	for _, test := range tests {
+		t.Run("", func(t *testing.T) {
+			res, err := formatDate(test.Layout, test.Value, test.Locale...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if res != test.Out {
+				t.Errorf("wrong result, want %q, got %q", test.Out, res)
+			}
+		})
+	}
+}
+
+func TestTemplateVar(t *testing.T) {
+	runMeta = &Meta{Vars: map[string]string{"tagline": "fast and secure"}}
+	defer func() {
+		runMeta = nil
+	}()
+
+	v, err := templateVar("tagline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != "fast and secure" {
+		t.Errorf("wrong value %q", v)
+	}
+
+	v, err = templateVar("docs", "https://example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != "https://example.com" {
+		t.Errorf("wrong default value %q", v)
+	}
+
+	if _, err := templateVar("docs"); err == nil {
+		t.Error("expected error for unset variable")
+	}
+}
+
+func TestCountType(t *testing.T) {
+	entries := []changelog.Entry{
+		{Type: "Security"},
+		{Type: "Bugfix"},
+		{Type: "Bugfix"},
+	}
+
+	if n := countType(entries, "Bugfix"); n != 2 {
+		t.Errorf("wrong count for Bugfix: %d", n)
+	}
+
+	if !hasType(entries, "security") {
+		t.Error("Security entry not found")
+	}
+
+	if hasType(entries, "Change") {
+		t.Error("unexpected Change entry found")
+	}
+}
+
+func TestVersionURL(t *testing.T) {
+	defer func() {
+		opts.VersionURL = ""
+	}()
+
+	if _, err := versionURL("0.15.0"); err == nil {
+		t.Error("expected error without --version-url")
+	}
+
+	var tests = []struct {
+		Pattern, Version, URL string
+	}{
+		{"#{anchor}", "0.15.0", "#0150"},
+		{"#{anchor}", "v0.15.0", "#0150"},
+		{"https://example.com/releases/{version}/", "v0.15.0", "https://example.com/releases/0.15.0/"},
+		{"#{anchor}", "unreleased", "#unreleased"},
+	}
+
+	for _, test := range tests {
+		opts.VersionURL = test.Pattern
+		url, err := versionURL(test.Version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if url != test.URL {
+			t.Errorf("%v %v: want %q, got %q", test.Pattern, test.Version, test.URL, url)
+		}
+	}
+}
+
+func TestPad(t *testing.T) {
+	var tests = []struct {
+		Fn    func(int, interface{}) string
+		Width int
+		Text  interface{}
+		Out   string
+	}{
+		{pad, 6, 1234, "  1234"},
+		{pad, 2, 1234, "1234"},
+		{rpad, 5, "Fix", "Fix  "},
+		{rpad, 6, "修正", "修正  "},
+		{pad, 6, "修正", "  修正"},
+	}
+
+	for _, test := range tests {
+		if out := test.Fn(test.Width, test.Text); out != test.Out {
+			t.Errorf("%v %v: want %q, got %q", test.Width, test.Text, test.Out, out)
+		}
+	}
+}
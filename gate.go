@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/restic/calens/changelog"
+)
+
+// runGate implements the "gate" command: it fails unless all issues and pull
+// requests in the milestone set with --milestone are referenced by an
+// unreleased entry.
+func runGate(args []string) {
+	if len(args) != 0 || opts.Milestone == "" {
+		die("usage: calens gate --milestone TITLE [--github-repo owner/name]")
+	}
+
+	repo, err := githubRepo()
+	if err != nil {
+		die("%v", err)
+	}
+
+	issues, err := milestoneIssues(repo, opts.Milestone)
+	if err != nil {
+		die("unable to list milestone: %v", err)
+	}
+
+	referenced := unreleasedReferences(opts.InputDir)
+
+	var missing []githubIssue
+	for _, issue := range issues {
+		if _, ok := referenced[fmt.Sprintf("%v#%d", strings.ToLower(repo), issue.Number)]; !ok {
+			missing = append(missing, issue)
+		}
+	}
+
+	if len(missing) > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d issues and pull requests in milestone %v are not referenced by any unreleased entry:\n", len(missing), len(issues), opts.Milestone)
+		for _, issue := range missing {
+			kind := "issue"
+			if issue.PullRequest != nil {
+				kind = "pull request"
+			}
+			fmt.Fprintf(os.Stderr, "  %v #%d %v (%v)\n", kind, issue.Number, issue.Title, issue.HTMLURL)
+		}
+		os.Exit(1)
+	}
+
+	fmt.Printf("all %d issues and pull requests in milestone %v are referenced\n", len(issues), opts.Milestone)
+}
+
+// unreleasedReferences returns the GitHub issues and pull requests (see
+// githubRef) referenced by the unreleased entries in dir. Entries which are
+// not rendered with the current options, like drafts, are skipped (see
+// filterEntries).
+func unreleasedReferences(dir string) map[string]struct{} {
+	var pending []changelog.Release
+	for _, rel := range readReleases(dir) {
+		if rel.Date == nil {
+			pending = append(pending, rel)
+		}
+	}
+
+	referenced := make(map[string]struct{})
+	for _, list := range readEntries(pending) {
+		for _, e := range filterEntries(list) {
+			for _, u := range append(e.IssueURLs, e.PRURLs...) {
+				referenced[githubRef(u)] = struct{}{}
+			}
+		}
+	}
+
+	return referenced
+}
+
+// githubRef returns a reference like "restic/restic#1234" for the URL of a
+// GitHub issue or pull request.
+func githubRef(u *url.URL) string {
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) < 4 {
+		return u.String()
+	}
+	return strings.ToLower(parts[0]+"/"+parts[1]) + "#" + parts[3]
+}
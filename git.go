@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/restic/calens/changelog"
+)
+
+// git runs git with args in dir and returns the output.
+func git(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %v: %v", strings.Join(args, " "), err)
+	}
+	return string(out), nil
+}
+
+// lines splits the output of a command into non-empty lines.
+func lines(s string) (result []string) {
+	sc := bufio.NewScanner(strings.NewReader(s))
+	for sc.Scan() {
+		if line := strings.TrimSpace(sc.Text()); line != "" {
+			result = append(result, line)
+		}
+	}
+	return result
+}
+
+// changedSince contains the absolute names of the files in the input dir
+// which were added or modified since --changed-since, see isChanged.
+var changedSince map[string]bool
+
+// isChanged reports whether file was added or modified since the ref set
+// with --changed-since. Without --changed-since, all files count as changed.
+func isChanged(file string) bool {
+	if opts.ChangedSince == "" {
+		return true
+	}
+
+	if changedSince == nil {
+		changedSince = changedFiles(opts.InputDir, opts.ChangedSince)
+	}
+
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		die("%v", err)
+	}
+
+	return changedSince[abs]
+}
+
+// changedFiles returns the absolute names of the files in dir which were
+// added or modified since the commit where ref and HEAD diverged, including
+// uncommitted and untracked files.
+func changedFiles(dir, ref string) map[string]bool {
+	base, err := git(dir, "merge-base", ref, "HEAD")
+	if err != nil {
+		die("unable to find merge base with %v: %v", ref, err)
+	}
+
+	changed, err := git(dir, "-c", "core.quotePath=false", "diff", "--name-only", "--relative", "--diff-filter=ACMR", strings.TrimSpace(base), "--", ".")
+	if err != nil {
+		die("unable to list changed files: %v", err)
+	}
+
+	untracked, err := git(dir, "-c", "core.quotePath=false", "ls-files", "--others", "--exclude-standard", "--", ".")
+	if err != nil {
+		die("unable to list untracked files: %v", err)
+	}
+
+	result := make(map[string]bool)
+	for _, name := range append(lines(changed), lines(untracked)...) {
+		abs, err := filepath.Abs(filepath.Join(dir, name))
+		if err != nil {
+			die("%v", err)
+		}
+		result[abs] = true
+	}
+
+	return result
+}
+
+// readReleasesGit returns the releases for all annotated git tags matching
+// pattern, dated with the tag date. The entry files are read from dir
+// directly (without version subdirs), each file belongs to the oldest
+// release whose tag contains the commit that added the file. Files which
+// are not contained in any release (or not committed yet) belong to the
+// "unreleased" version.
+func readReleasesGit(dir, pattern string) (result []changelog.Release, err error) {
+	out, err := git(dir, "for-each-ref", "--format=%(objecttype) %(refname:short) %(creatordate:iso-strict)", "refs/tags/"+pattern)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list tags: %v", err)
+	}
+
+	tags := make(map[string]gitTag)
+	for _, line := range lines(out) {
+		fields := strings.Fields(line)
+		if len(fields) != 3 || fields[0] != "tag" {
+			// skip lightweight tags
+			if len(fields) > 1 {
+				warnSkipped("refs/tags/"+fields[1], "not an annotated tag")
+			}
+			continue
+		}
+
+		rel, err := opts.ParseVersion(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid tag %v: %v", fields[1], err)
+		}
+
+		created, err := time.Parse(time.RFC3339, fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse date %q of tag %v: %v", fields[2], fields[1], err)
+		}
+
+		// the release date is the day the tag was created
+		t, err := time.Parse("2006-01-02", created.Format("2006-01-02"))
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse date of tag %v: %v", fields[1], err)
+		}
+		rel.Date = &t
+		rel.Path = dir
+		rel.Files = []string{}
+
+		tags[fields[1]] = gitTag{idx: len(result), created: created}
+		result = append(result, rel)
+	}
+
+	result = append(result, changelog.Release{
+		Path:    dir,
+		Version: "unreleased",
+		Files:   []string{},
+	})
+
+	result, err = assignByHistory(dir, result, tags)
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(changelog.ReleaseSlice(result))
+
+	return result, nil
+}
+
+// assignByHistory adds the entry files directly in dir to the releases,
+// each file is added to the release of the oldest tag containing the commit
+// which added the file (see releaseByHistory), all other files are added to
+// the "unreleased" version, which is created if necessary.
+func assignByHistory(dir string, releases []changelog.Release, tags map[string]gitTag) ([]changelog.Release, error) {
+	unreleased := -1
+	for i, rel := range releases {
+		if rel.Version == "unreleased" {
+			unreleased = i
+		}
+	}
+
+	list, err := flatFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range list {
+		idx, ok, err := releaseByHistory(dir, file, tags)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			if unreleased < 0 {
+				releases = append(releases, changelog.Release{Path: dir, Version: "unreleased", Files: []string{}})
+				unreleased = len(releases) - 1
+			}
+			idx = unreleased
+		}
+
+		rel := &releases[idx]
+		if rel.Files == nil {
+			// keep the files from the release directory
+			rel.Files, err = opts.Files(rel.Path)
+			if err != nil {
+				return nil, err
+			}
+		}
+		rel.Files = append(rel.Files, file)
+	}
+
+	return releases, nil
+}
+
+// assignReleasesByHistory adds the entry files directly in dir to the
+// releases read from the version subdirs, matching git tags to releases by
+// their version. It is used as the Assign hook with --assign-by-history.
+func assignReleasesByHistory(dir string, releases []changelog.Release) ([]changelog.Release, error) {
+	out, err := git(dir, "for-each-ref", "--format=%(refname:short) %(creatordate:iso-strict)", "refs/tags")
+	if err != nil {
+		return nil, fmt.Errorf("unable to list tags: %v", err)
+	}
+
+	versions := make(map[string]int)
+	for i, rel := range releases {
+		versions[rel.Version] = i
+	}
+
+	tags := make(map[string]gitTag)
+	for _, line := range lines(out) {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+
+		idx, ok := versions[fields[0]]
+		if !ok {
+			rel, err := opts.ParseVersion(fields[0])
+			if err != nil {
+				continue
+			}
+
+			idx, ok = versions[rel.Version]
+			if !ok {
+				continue
+			}
+		}
+
+		created, err := time.Parse(time.RFC3339, fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse date %q of tag %v: %v", fields[1], fields[0], err)
+		}
+
+		tags[fields[0]] = gitTag{idx: idx, created: created}
+	}
+
+	return assignByHistory(dir, releases, tags)
+}
+
+// flatFiles returns all entry files directly in dir, skipping directories and
+// the files calens uses itself.
+func flatFiles(dir string) (result []string, err error) {
+	o := opts.Options
+	o.Recursive = false
+	list, err := o.Files(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range list {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return nil, fmt.Errorf("unable to stat %v: %v", file, err)
+		}
+
+		if !fi.Mode().IsRegular() {
+			if !fi.IsDir() {
+				warnSkipped(file, "not a regular file")
+			}
+			continue
+		}
+
+		if filepath.Clean(file) == filepath.Clean(opts.TemplateFile) || isConfigFile(file) {
+			warnSkipped(file, "file used by calens")
+			continue
+		}
+
+		result = append(result, file)
+	}
+
+	return result, nil
+}
+
+// gitTag describes a tag used as a release.
+type gitTag struct {
+	idx     int // index of the release
+	created time.Time
+}
+
+// releaseByHistory finds the commit which added file and returns the index
+// of the release for the oldest tag in tags which contains the commit. If the
+// file was not committed yet or is not contained in any of the tags, ok is
+// false.
+func releaseByHistory(dir, file string, tags map[string]gitTag) (idx int, ok bool, err error) {
+	out, err := git(dir, "log", "--diff-filter=A", "--format=%H", "--", filepath.Base(file))
+	if err != nil {
+		return 0, false, fmt.Errorf("unable to find commit for %v: %v", file, err)
+	}
+
+	commits := lines(out)
+	if len(commits) == 0 {
+		return 0, false, nil
+	}
+
+	// the oldest commit which added the file is listed last
+	out, err = git(dir, "tag", "--contains", commits[len(commits)-1])
+	if err != nil {
+		return 0, false, fmt.Errorf("unable to find tags for %v: %v", file, err)
+	}
+
+	var oldest gitTag
+	for _, name := range lines(out) {
+		tag, found := tags[name]
+		if !found {
+			continue
+		}
+
+		if !ok || tag.created.Before(oldest.created) {
+			oldest, ok = tag, true
+		}
+	}
+
+	return oldest.idx, ok, nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// githubAPI is the base URL of the GitHub API.
+var githubAPI = "https://api.github.com"
+
+// githubRepo returns the GitHub repository as "owner/name", either set with
+// --github-repo or derived from --repo-url.
+func githubRepo() (string, error) {
+	if opts.GitHubRepo != "" {
+		return opts.GitHubRepo, nil
+	}
+
+	if opts.RepoURL != "" {
+		u, err := url.Parse(opts.RepoURL)
+		if err == nil && u.Host == "github.com" {
+			parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+			if len(parts) >= 2 {
+				return parts[0] + "/" + strings.TrimSuffix(parts[1], ".git"), nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("GitHub repository not set, use --github-repo owner/name")
+}
+
+// githubGet requests path from the GitHub API and decodes the JSON response
+// into data. If $GITHUB_TOKEN is set, it is used for authentication.
+func githubGet(path string, data interface{}) error {
+	req, err := http.NewRequest("GET", githubAPI+path, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if token := os.Getenv("GITHUB_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		_ = res.Body.Close()
+		return fmt.Errorf("GET %v returned %v", path, res.Status)
+	}
+
+	err = json.NewDecoder(res.Body).Decode(data)
+	if err != nil {
+		_ = res.Body.Close()
+		return fmt.Errorf("unable to decode response for %v: %v", path, err)
+	}
+
+	return res.Body.Close()
+}
+
+// githubIssue is an issue or pull request returned by the GitHub API.
+type githubIssue struct {
+	Number      int64     `json:"number"`
+	Title       string    `json:"title"`
+	HTMLURL     string    `json:"html_url"`
+	PullRequest *struct{} `json:"pull_request"`
+}
+
+// milestoneIssues returns all issues and pull requests in the milestone with
+// the given title.
+func milestoneIssues(repo, title string) ([]githubIssue, error) {
+	var milestones []struct {
+		Number int64  `json:"number"`
+		Title  string `json:"title"`
+	}
+
+	err := githubGet(fmt.Sprintf("/repos/%v/milestones?state=all&per_page=100", repo), &milestones)
+	if err != nil {
+		return nil, err
+	}
+
+	number := int64(0)
+	for _, m := range milestones {
+		if m.Title == title {
+			number = m.Number
+		}
+	}
+
+	if number == 0 {
+		return nil, fmt.Errorf("milestone %q not found in %v", title, repo)
+	}
+
+	var result []githubIssue
+	for page := 1; ; page++ {
+		var issues []githubIssue
+		err := githubGet(fmt.Sprintf("/repos/%v/issues?milestone=%d&state=all&per_page=100&page=%d", repo, number, page), &issues)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(issues) == 0 {
+			break
+		}
+		result = append(result, issues...)
+	}
+
+	return result, nil
+}
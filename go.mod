@@ -6,5 +6,8 @@ require (
 	github.com/Masterminds/semver/v3 v3.2.1
 	github.com/Masterminds/sprig/v3 v3.0.1
 	github.com/go-test/deep v1.0.1
+	github.com/klauspost/compress v1.13.6
+	github.com/mattn/go-runewidth v0.0.15
 	github.com/spf13/pflag v1.0.5
+	gopkg.in/yaml.v3 v3.0.1
 )
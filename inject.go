@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/restic/calens/changelog"
+)
+
+// runInject implements the "inject" command: it renders the latest release
+// (or the versions selected with --version) with the template and section
+// markers (see renderChangelog) and replaces the marked sections in each file
+// given with --target.
+func runInject(args []string) {
+	if len(args) != 0 || len(opts.InjectTargets) == 0 {
+		die("usage: calens inject --target FILE")
+	}
+	opts.Markers = true
+
+	templ := loadTemplate()
+
+	releases := readReleases(opts.InputDir)
+	if len(opts.Versions) > 0 {
+		releases = selectReleases(releases)
+	} else {
+		releases = latestRelease(releases)
+	}
+
+	changes := buildChanges(releases)
+	if len(changes) == 0 {
+		die("no release with entries found")
+	}
+	changes.Meta()
+
+	buf := bytes.NewBuffer(nil)
+	err := renderChangelog(buf, templ, changes)
+	if err != nil {
+		die("error executing template: %v", err)
+	}
+
+	for _, target := range opts.InjectTargets {
+		data, err := ioutil.ReadFile(target)
+		if err != nil {
+			die("unable to read %v: %v", target, err)
+		}
+
+		text, err := injectRegion(string(data), buf.String())
+		if err != nil {
+			die("%v: %v", target, err)
+		}
+
+		if text == string(data) {
+			continue
+		}
+
+		fi, err := os.Stat(target)
+		if err != nil {
+			die("unable to stat %v: %v", target, err)
+		}
+
+		err = ioutil.WriteFile(target, []byte(text), fi.Mode())
+		if err != nil {
+			die("unable to write %v: %v", target, err)
+		}
+	}
+}
+
+// latestRelease returns a list with the newest released version in releases,
+// which is empty if there is no release yet.
+func latestRelease(releases []changelog.Release) []changelog.Release {
+	for _, rel := range releases {
+		if rel.Date != nil {
+			return []changelog.Release{rel}
+		}
+	}
+
+	return nil
+}
+
+// injectRegion replaces the sections in text from the first start marker to
+// the last end marker (for any version, see marker) with content, which
+// contains the markers for the new sections.
+func injectRegion(text, content string) (string, error) {
+	start := markerRegexp("start").FindStringIndex(text)
+	if start == nil {
+		return "", fmt.Errorf("start marker %v not found", marker("start", "VERSION"))
+	}
+
+	ends := markerRegexp("end").FindAllStringIndex(text[start[1]:], -1)
+	if len(ends) == 0 {
+		return "", fmt.Errorf("end marker %v not found", marker("end", "VERSION"))
+	}
+	end := start[1] + ends[len(ends)-1][1]
+
+	return text[:start[0]] + strings.Trim(content, "\n") + text[end:], nil
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/restic/calens/changelog"
+)
+
+// lintEntryFiles returns the entry files to check: the files in args, or all
+// entry files in all releases if args is empty. With --changed-since, only
+// the files changed since the ref are checked.
+func lintEntryFiles(args []string) []string {
+	if len(args) > 0 {
+		return args
+	}
+
+	var all []string
+	for _, rel := range readReleases(opts.InputDir) {
+		if rel.Files != nil {
+			all = append(all, rel.Files...)
+			continue
+		}
+		all = append(all, files(rel.Path)...)
+	}
+
+	var list []string
+	for _, file := range all {
+		if isChanged(file) {
+			list = append(list, file)
+		}
+	}
+
+	return list
+}
+
+// runLint implements the "lint" command: it checks all entry files (or the
+// files given in args), prints the problems found together with suggested
+// fixes and, with --fix, applies the unambiguous fixes to the files. Changes
+// to released versions recorded in the history lock file are reported as
+// well.
+func runLint(args []string) {
+	switch opts.LintFormat {
+	case "text", "github-annotations":
+	default:
+		die("unknown format %q, valid formats: text, github-annotations", opts.LintFormat)
+	}
+
+	failed := !lintHistory()
+	lintReleaseOrder()
+	lintCalendarVersions()
+	for _, file := range lintEntryFiles(args) {
+		if !lintFile(file) {
+			failed = true
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// lintFile checks a single entry file and reports whether it is valid.
+func lintFile(filename string) bool {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		lintProblem(filename, 0, err.Error(), "")
+		return false
+	}
+
+	lines := strings.Split(string(changelog.NormalizeText(buf)), "\n")
+	idx := changelog.TitleLineIndex(lines)
+	if idx >= len(lines) {
+		lintProblem(filename, idx, "missing title line", "")
+		return false
+	}
+
+	fixed, problems := fixTitle(lines[idx])
+	if len(problems) > 0 {
+		note := ""
+		switch {
+		case fixed == lines[idx]:
+			// nothing to fix automatically
+		case opts.Fix:
+			lines[idx] = fixed
+			err = ioutil.WriteFile(filename, restoreLineEndings(buf, strings.Join(lines, "\n")), 0644)
+			if err != nil {
+				lintProblem(filename, 0, fmt.Sprintf("unable to write fixed title: %v", err), "")
+				return false
+			}
+			note = "fixed: " + fixed
+		default:
+			note = "suggestion: " + fixed
+		}
+
+		for i, problem := range problems {
+			if i < len(problems)-1 {
+				lintProblem(filename, idx+1, problem, "")
+			} else {
+				lintProblem(filename, idx+1, problem, note)
+			}
+		}
+	}
+
+	_, err = opts.ParseFile(filename)
+	if err != nil {
+		// problems with a suggestion were already reported above
+		if opts.Fix || len(problems) == 0 {
+			line, msg := errorPosition(filename, err)
+			lintProblem(filename, line, msg, "")
+		}
+		return false
+	}
+
+	return true
+}
+
+// utf8BOM is the byte order mark, which is kept when entry files are fixed.
+var utf8BOM = []byte("\ufeff")
+
+// restoreLineEndings returns text (with LF line endings) with the byte
+// order mark and CRLF line endings of orig, if it has them.
+func restoreLineEndings(orig []byte, text string) []byte {
+	if bytes.Contains(orig, []byte("\r\n")) {
+		text = strings.Replace(text, "\n", "\r\n", -1)
+	}
+
+	if bytes.HasPrefix(orig, utf8BOM) {
+		text = string(utf8BOM) + text
+	}
+
+	return []byte(text)
+}
+
+// lintProblem reports a problem in filename at line (0 if unknown), with an
+// optional note such as a suggested fix, in the format set with --format.
+func lintProblem(filename string, line int, msg, note string) {
+	lintMessage("error", filename, line, msg, note)
+}
+
+// lintWarning reports a problem like lintProblem, but as a warning which
+// does not make the lint fail.
+func lintWarning(filename string, line int, msg, note string) {
+	lintMessage("warning", filename, line, msg, note)
+}
+
+// lintMessage prints a message with the given level ("error" or "warning")
+// in the format set with --format.
+func lintMessage(level, filename string, line int, msg, note string) {
+	switch opts.LintFormat {
+	case "github-annotations":
+		props := "file=" + escapeAnnotation(filepath.ToSlash(filename), true)
+		if line > 0 {
+			props += fmt.Sprintf(",line=%d", line)
+		}
+		if note != "" {
+			msg += "\n" + note
+		}
+		fmt.Printf("::%v %v::%v\n", level, props, escapeAnnotation(msg, false))
+	default:
+		if level != "error" {
+			msg = level + ": " + msg
+		}
+		if line > 0 {
+			fmt.Fprintf(os.Stderr, "%v:%d: %v\n", filename, line, msg)
+		} else {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", filename, msg)
+		}
+		if note != "" {
+			fmt.Fprintf(os.Stderr, "  %v\n", note)
+		}
+	}
+}
+
+// escapeAnnotation escapes s for a GitHub Actions workflow command, either
+// as the value of a property or as the message.
+func escapeAnnotation(s string, property bool) string {
+	s = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+	if property {
+		s = strings.NewReplacer(":", "%3A", ",", "%2C").Replace(s)
+	}
+	return s
+}
+
+// fixTitle checks the title line of an entry for problems which can be fixed
+// automatically and returns the fixed title line together with a description
+// of the problems found.
+func fixTitle(line string) (fixed string, problems []string) {
+	typ, title := "", strings.TrimSpace(line)
+	data := strings.SplitN(title, ": ", 2)
+	if len(data) == 2 {
+		typ, title = strings.TrimSpace(data[0]), strings.TrimSpace(data[1])
+	}
+
+	// aliases like "fix" may be written in lower case
+	if typ != "" && typ != changelog.Capitalize(typ) && !isTypeAlias(typ) {
+		problems = append(problems, fmt.Sprintf("entry type %q is not capitalized", typ))
+		typ = changelog.Capitalize(typ)
+	}
+
+	if p := opts.TitlePunctuation; p != "" {
+		if trimmed := strings.TrimRight(title, p+" "); trimmed != title {
+			problems = append(problems, fmt.Sprintf("title ends with punctuation, e.g. a character out of %q", p))
+			title = trimmed
+		}
+	}
+
+	fixed = title
+	if typ != "" {
+		fixed = typ + ": " + title
+	}
+
+	// this cannot be fixed automatically, but the suggestion shows where to
+	// shorten the title
+	if max := opts.MaxTitleLength; max > 0 && len(typ)+len(title)+1 > max {
+		problems = append(problems, fmt.Sprintf("title is too long (max %d characters), shorten the text after %q", max, fixed[:max]))
+	}
+
+	return fixed, problems
+}
+
+// isTypeAlias reports whether the type prefix typ (optionally with a scope
+// and "!") is an alias of an entry type.
+func isTypeAlias(prefix string) bool {
+	typ, _, _ := changelog.SplitTypePrefix(prefix)
+	_, ok := changelog.EntryTypeAliases[strings.ToLower(typ)]
+	return ok
+}
+
+// misorderedRelease is a release with a higher version but an earlier date
+// than the release Later.
+type misorderedRelease struct {
+	changelog.Release
+	Later changelog.Release
+}
+
+// misorderedReleases returns the releases in list (sorted newest first)
+// which have an earlier date than a lower version, together with the newest
+// such lower version. This usually means the version or the date in the name
+// of the release directory is mistyped.
+func misorderedReleases(list []changelog.Release) []misorderedRelease {
+	var result []misorderedRelease
+	for i, rel := range list {
+		if rel.Date == nil || rel.Scheme == nil {
+			continue
+		}
+
+		for _, later := range list[:i] {
+			if later.Date == nil || later.Scheme == nil || !rel.Date.Before(*later.Date) {
+				continue
+			}
+
+			if rel.Scheme.Compare(rel.Version, later.Version) > 0 {
+				result = append(result, misorderedRelease{Release: rel, Later: later})
+				break
+			}
+		}
+	}
+
+	return result
+}
+
+// lintReleaseOrder warns about releases with a higher version but an earlier
+// date than another release.
+func lintReleaseOrder() {
+	for _, rel := range misorderedReleases(readReleases(opts.InputDir)) {
+		lintWarning(rel.Path, 0, fmt.Sprintf("version %v is higher than %v but was released earlier (%v, %v)",
+			rel.Version, rel.Later.Version, rel.Date.Format("2006-01-02"), rel.Later.Date.Format("2006-01-02")),
+			"check the version and the date in the name of the release directory")
+	}
+}
+
+// calendarVersionReleases returns the releases in list whose directory name
+// looks like a calendar version, e.g. "2024.05" read as version "2024.5.0".
+func calendarVersionReleases(list []changelog.Release) (result []changelog.Release) {
+	for _, rel := range list {
+		if rel.Path == "" {
+			continue
+		}
+
+		version := strings.SplitN(filepath.Base(rel.Path), "_", 2)[0]
+		if changelog.LooksLikeCalVer(version) {
+			result = append(result, rel)
+		}
+	}
+
+	return result
+}
+
+// lintCalendarVersions warns about release directories which look like
+// calendar versions but are read as semantic versions, as long as
+// --versioning is semver.
+func lintCalendarVersions() {
+	if opts.Versioning != "semver" {
+		return
+	}
+
+	for _, rel := range calendarVersionReleases(readReleases(opts.InputDir)) {
+		lintWarning(rel.Path, 0, fmt.Sprintf("%v looks like a calendar version but is read as version %v", filepath.Base(rel.Path), rel.Version),
+			"use --versioning calver if it is one")
+	}
+}
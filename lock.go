@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/restic/calens/changelog"
+)
+
+// releaseChecksum returns the SHA256 checksum of the names and contents of
+// the entry files of rel.
+func releaseChecksum(rel changelog.Release) (string, error) {
+	list := rel.Files
+	if list == nil {
+		list = files(rel.Path)
+	}
+
+	hash := sha256.New()
+	for _, file := range list {
+		buf, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+
+		fmt.Fprintf(hash, "%v\x00%d\x00", filepath.Base(file), len(buf))
+		_, _ = hash.Write(buf)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// readHistoryLock returns the checksums by version from the lock file in
+// dir, or nil if there is no lock file. Each line of the file consists of
+// the checksum and the version, like the output of sha256sum.
+func readHistoryLock(dir string) (map[string]string, error) {
+	filename := filepath.Join(dir, changelog.HistoryLockFile)
+	buf, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	checksums := make(map[string]string)
+	sc := bufio.NewScanner(strings.NewReader(string(buf)))
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%v:%d: invalid line %q, expected checksum and version", filename, line, text)
+		}
+
+		checksums[fields[1]] = fields[0]
+	}
+
+	return checksums, nil
+}
+
+// writeHistoryLock writes the checksums by version to the lock file in dir.
+func writeHistoryLock(dir string, checksums map[string]string) error {
+	var versions []string
+	for version := range checksums {
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+
+	var sb strings.Builder
+	sb.WriteString("# checksums of the released versions, written by \"calens lock\"\n")
+	for _, version := range versions {
+		fmt.Fprintf(&sb, "%v  %v\n", checksums[version], version)
+	}
+
+	return ioutil.WriteFile(filepath.Join(dir, changelog.HistoryLockFile), []byte(sb.String()), 0644)
+}
+
+// runLock implements the "lock" command: it records the checksums of all
+// released versions in the lock file, so that "calens lint" reports changes
+// to the entries of published releases. Running it again accepts the
+// changes made since.
+func runLock(args []string) {
+	if len(args) != 0 {
+		die("usage: calens lock")
+	}
+
+	checksums := make(map[string]string)
+	for _, rel := range readReleases(opts.InputDir) {
+		if rel.Version == "unreleased" {
+			continue
+		}
+
+		sum, err := releaseChecksum(rel)
+		if err != nil {
+			die("unable to compute checksum for %v: %v", rel.Version, err)
+		}
+		checksums[rel.Version] = sum
+	}
+
+	err := writeHistoryLock(opts.InputDir, checksums)
+	if err != nil {
+		die("unable to write lock file: %v", err)
+	}
+
+	fmt.Printf("locked %d releases in %v\n", len(checksums), filepath.Join(opts.InputDir, changelog.HistoryLockFile))
+}
+
+// lockRelease adds the checksum of rel to the lock file, if there is one.
+func lockRelease(rel changelog.Release) error {
+	checksums, err := readHistoryLock(opts.InputDir)
+	if err != nil || checksums == nil {
+		return err
+	}
+
+	checksums[rel.Version], err = releaseChecksum(rel)
+	if err != nil {
+		return err
+	}
+
+	return writeHistoryLock(opts.InputDir, checksums)
+}
+
+// lintHistory reports the released versions whose entries were changed
+// since they were recorded in the lock file, unless --allow-history-edit is
+// set. It returns false if any problems were found.
+func lintHistory() bool {
+	if opts.AllowHistoryEdit {
+		return true
+	}
+
+	checksums, err := readHistoryLock(opts.InputDir)
+	if err != nil {
+		lintProblem(filepath.Join(opts.InputDir, changelog.HistoryLockFile), 0, err.Error(), "")
+		return false
+	}
+	if checksums == nil {
+		return true
+	}
+
+	ok := true
+	found := make(map[string]bool)
+	for _, rel := range readReleases(opts.InputDir) {
+		want, locked := checksums[rel.Version]
+		if !locked {
+			continue
+		}
+		found[rel.Version] = true
+
+		sum, err := releaseChecksum(rel)
+		if err != nil {
+			lintProblem(rel.Path, 0, err.Error(), "")
+			ok = false
+			continue
+		}
+
+		if sum != want {
+			lintProblem(rel.Path, 0, fmt.Sprintf("entries of the released version %v were changed", rel.Version),
+				"use --allow-history-edit for intentional changes and run \"calens lock\" to accept them")
+			ok = false
+		}
+	}
+
+	var missing []string
+	for version := range checksums {
+		if !found[version] {
+			missing = append(missing, version)
+		}
+	}
+	sort.Strings(missing)
+
+	for _, version := range missing {
+		lintProblem(filepath.Join(opts.InputDir, changelog.HistoryLockFile), 0, fmt.Sprintf("released version %v not found", version), "")
+		ok = false
+	}
+
+	return ok
+}
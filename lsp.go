@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/restic/calens/changelog"
+)
+
+// lspRequest is a request or notification sent by the editor.
+type lspRequest struct {
+	ID     *json.RawMessage `json:"id"`
+	Method string           `json:"method"`
+	Params json.RawMessage  `json:"params"`
+}
+
+// lspResponse is the response to a request.
+type lspResponse struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Result  interface{}      `json:"result"`
+}
+
+// lspErrorResponse is the response to a request which failed.
+type lspErrorResponse struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Error   struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// lspNotification is a notification sent to the editor.
+type lspNotification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+// lspPosition is a position in a document, both fields start at zero.
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+// lspDiagnostic is a problem found in a document.
+type lspDiagnostic struct {
+	Range struct {
+		Start lspPosition `json:"start"`
+		End   lspPosition `json:"end"`
+	} `json:"range"`
+	Severity int    `json:"severity"`
+	Source   string `json:"source"`
+	Message  string `json:"message"`
+}
+
+// lspCompletionItem is a suggestion returned for a completion request.
+type lspCompletionItem struct {
+	Label      string `json:"label"`
+	Kind       int    `json:"kind"`
+	InsertText string `json:"insertText"`
+}
+
+// lspDocument is the part of the parameters identifying the document.
+type lspDocument struct {
+	TextDocument struct {
+		URI  string `json:"uri"`
+		Text string `json:"text"`
+	} `json:"textDocument"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+	Position lspPosition `json:"position"`
+}
+
+// lspServer is a minimal language server for entry files. It reports the
+// problems found in entry files as diagnostics and completes entry types.
+type lspServer struct {
+	rd *bufio.Reader
+	wr io.Writer
+
+	// docs contains the text of the open documents by URI
+	docs map[string]string
+}
+
+// runLSP implements the "lsp" command: it runs a language server which
+// communicates with the editor on stdin and stdout.
+func runLSP(args []string) {
+	if len(args) != 0 {
+		die("usage: calens lsp")
+	}
+
+	srv := &lspServer{
+		rd:   bufio.NewReader(os.Stdin),
+		wr:   os.Stdout,
+		docs: make(map[string]string),
+	}
+
+	err := srv.serve()
+	if err != nil {
+		die("lsp: %v", err)
+	}
+}
+
+// serve handles messages until the editor sends "exit" or closes the
+// connection.
+func (srv *lspServer) serve() error {
+	for {
+		req, err := srv.read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if req.Method == "exit" {
+			return nil
+		}
+
+		err = srv.handle(req)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// read returns the next message, which is preceded by a header with the
+// length of the content.
+func (srv *lspServer) read() (req lspRequest, err error) {
+	length := -1
+	for {
+		line, err := srv.rd.ReadString('\n')
+		if err != nil {
+			return lspRequest{}, err
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+
+		data := strings.SplitN(line, ":", 2)
+		if len(data) == 2 && strings.EqualFold(data[0], "Content-Length") {
+			length, err = strconv.Atoi(strings.TrimSpace(data[1]))
+			if err != nil {
+				return lspRequest{}, fmt.Errorf("invalid header %q", line)
+			}
+		}
+	}
+
+	if length < 0 {
+		return lspRequest{}, errors.New("message without Content-Length header")
+	}
+
+	buf := make([]byte, length)
+	_, err = io.ReadFull(srv.rd, buf)
+	if err != nil {
+		return lspRequest{}, err
+	}
+
+	err = json.Unmarshal(buf, &req)
+	if err != nil {
+		return lspRequest{}, fmt.Errorf("unable to decode message: %v", err)
+	}
+
+	return req, nil
+}
+
+// write sends msg to the editor.
+func (srv *lspServer) write(msg interface{}) error {
+	buf, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(srv.wr, "Content-Length: %d\r\n\r\n%s", len(buf), buf)
+	return err
+}
+
+// handle processes a single request or notification.
+func (srv *lspServer) handle(req lspRequest) error {
+	var doc lspDocument
+	if len(req.Params) > 0 {
+		err := json.Unmarshal(req.Params, &doc)
+		if err != nil {
+			return fmt.Errorf("unable to decode params for %v: %v", req.Method, err)
+		}
+	}
+	uri := doc.TextDocument.URI
+
+	var result interface{}
+	switch req.Method {
+	case "initialize":
+		result = map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":   1, // full document on every change
+				"completionProvider": map[string]interface{}{},
+			},
+			"serverInfo": map[string]string{
+				"name":    "calens",
+				"version": calensVersion(),
+			},
+		}
+	case "shutdown":
+		result = nil
+	case "textDocument/didOpen":
+		srv.docs[uri] = doc.TextDocument.Text
+		return srv.publish(uri)
+	case "textDocument/didChange":
+		if len(doc.ContentChanges) > 0 {
+			srv.docs[uri] = doc.ContentChanges[len(doc.ContentChanges)-1].Text
+		}
+		return srv.publish(uri)
+	case "textDocument/didClose":
+		delete(srv.docs, uri)
+		return srv.publish(uri)
+	case "textDocument/completion":
+		result = srv.complete(uri, doc.Position)
+	default:
+		// notifications which are not handled are ignored
+		if req.ID == nil {
+			return nil
+		}
+
+		res := lspErrorResponse{JSONRPC: "2.0", ID: req.ID}
+		res.Error.Code = -32601
+		res.Error.Message = fmt.Sprintf("method %v not supported", req.Method)
+		return srv.write(res)
+	}
+
+	return srv.write(lspResponse{JSONRPC: "2.0", ID: req.ID, Result: result})
+}
+
+// publish sends the diagnostics for the document uri. Closed documents and
+// documents which are not entry files get an empty list.
+func (srv *lspServer) publish(uri string) error {
+	diagnostics := []lspDiagnostic{}
+
+	text, ok := srv.docs[uri]
+	if filename := lspFilename(uri); ok && isEntryFile(filename) {
+		diagnostics = append(diagnostics, lspDiagnostics(filename, text)...)
+	}
+
+	return srv.write(lspNotification{
+		JSONRPC: "2.0",
+		Method:  "textDocument/publishDiagnostics",
+		Params: map[string]interface{}{
+			"uri":         uri,
+			"diagnostics": diagnostics,
+		},
+	})
+}
+
+// complete returns the entry types as completion items if pos is in the
+// type prefix of the title line.
+func (srv *lspServer) complete(uri string, pos lspPosition) []lspCompletionItem {
+	items := []lspCompletionItem{}
+
+	lines := strings.Split(srv.docs[uri], "\n")
+	idx := changelog.TitleLineIndex(lines)
+	if pos.Line != idx || idx >= len(lines) {
+		return items
+	}
+
+	prefix := lines[idx]
+	prefix = prefix[:byteOffset(prefix, pos.Character)]
+
+	if strings.Contains(prefix, ":") {
+		return items
+	}
+
+	for _, typ := range changelog.EntryTypes() {
+		items = append(items, lspCompletionItem{
+			Label:      typ,
+			Kind:       13, // enum member
+			InsertText: typ + ": ",
+		})
+	}
+
+	return items
+}
+
+// utf16Len returns the length of s in UTF-16 code units, which LSP uses for
+// character offsets.
+func utf16Len(s string) (n int) {
+	for _, r := range s {
+		if r >= 0x10000 {
+			n += 2
+		} else {
+			n++
+		}
+	}
+	return n
+}
+
+// byteOffset returns the index of the byte in line at the LSP character
+// offset, which counts UTF-16 code units. Offsets after the end of the line
+// return its length.
+func byteOffset(line string, character int) int {
+	n := 0
+	for i, r := range line {
+		if n >= character {
+			return i
+		}
+		n += utf16Len(string(r))
+	}
+	return len(line)
+}
+
+// lspFilename returns the file name for a file:// URI, or "" for other URIs.
+func lspFilename(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return ""
+	}
+
+	return filepath.FromSlash(u.Path)
+}
+
+// isEntryFile reports whether filename is an entry file in the input dir:
+// a file in a release subdir (or in the input dir itself when reading
+// releases from git tags) which is neither hidden nor a reserved file.
+func isEntryFile(filename string) bool {
+	if filename == "" {
+		return false
+	}
+
+	dir, err := filepath.Abs(opts.InputDir)
+	if err != nil {
+		return false
+	}
+
+	rel, err := filepath.Rel(dir, filename)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return false
+	}
+
+	name := filepath.Base(rel)
+	if changelog.IsReservedName(name) || strings.HasPrefix(name, ".") {
+		return false
+	}
+
+	switch strings.Count(filepath.ToSlash(rel), "/") {
+	case 0:
+		return opts.GitTags != "" || opts.AssignByHistory
+	case 1:
+		return !strings.HasPrefix(rel, ".")
+	default:
+		return false
+	}
+}
+
+// lspDiagnostics returns the problems found in the entry text.
+func lspDiagnostics(filename, text string) []lspDiagnostic {
+	_, err := opts.ParseEntry(filename, strings.NewReader(text))
+	if err == nil {
+		return nil
+	}
+
+	line, msg := errorPosition(filename, err)
+	line-- // diagnostics start at line zero
+
+	lines := strings.Split(text, "\n")
+	if line < 0 || line >= len(lines) {
+		line = 0
+	}
+
+	var d lspDiagnostic
+	d.Range.Start = lspPosition{Line: line}
+	d.Range.End = lspPosition{Line: line, Character: utf16Len(lines[line])}
+	d.Severity = 1 // error
+	d.Source = "calens"
+	d.Message = msg
+
+	return []lspDiagnostic{d}
+}
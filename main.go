@@ -2,38 +2,204 @@ package main
 
 import (
 	"bufio"
-	"errors"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
-	"sort"
-	"strconv"
 	"strings"
 	"time"
 
 	"text/template"
 
-	"github.com/Masterminds/semver/v3"
 	"github.com/Masterminds/sprig/v3"
+	"github.com/klauspost/compress/zstd"
+	"github.com/mattn/go-runewidth"
+	"github.com/restic/calens/changelog"
 	"github.com/spf13/pflag"
 )
 
 var opts struct {
-	Output       string
-	InputDir     string
-	TemplateFile string
-	Versions     []string
+	changelog.Options
+
+	Config         string
+	Output         string
+	Compress       string
+	InputDir       string
+	TemplateFile   string
+	TemplateSHA256 string
+	Versions       []string
+	Latest         bool
+	UnreleasedOnly bool
+	Since          string
+	From           string
+
+	Review   bool
+	DeferTo  string
+	To       string
+	Timezone string
+
+	Markers      bool
+	MarkerFormat string
+	VersionURL   string
+	URLOwnLine   bool
+
+	GitTags         string
+	AssignByHistory bool
+	ChangedSince    string
+
+	RepoURL string
+	Set     []string
+
+	Milestone  string
+	GitHubRepo string
+
+	PackageName string
+
+	Fix              bool
+	AllowHistoryEdit bool
+	LintFormat       string
+	Quality          bool
+
+	LabelPrefix string
+
+	CollapsePrereleases bool
+	KeepPrereleases     bool
+
+	TypePriority     []string
+	TypeAbbreviation map[string]string
+	TypeEmoji        map[string]string
+	TypeAlias        map[string]string
+
+	IncludeEmpty     bool
+	EmptyPlaceholder string
+
+	WarnSkipped bool
+	WarnFormat  string
+
+	Preset   string
+	Internal bool
+	Audience string
+
+	InjectTargets []string
+
+	Repos string
+
+	Insecure bool
 }
 
 func init() {
+	pflag.StringVarP(&opts.Config, "config", "c", "", "read options from config `file` (default: .calens.yml or changelog/config.yml if present)")
 	pflag.StringVarP(&opts.InputDir, "input", "i", "changelog", "read input files from `dir`")
+	pflag.BoolVar(&opts.Recursive, "recursive", false, "also read entry files in subdirectories of the release directories")
+	pflag.BoolVar(&opts.Latin1, "latin1", false, "read entry files which are not valid UTF-8 as Latin-1 instead of rejecting them")
 	pflag.StringVarP(&opts.Output, "output", "o", "", "write generated changelog to this `file` (default: print to stdout)")
-	pflag.StringVarP(&opts.TemplateFile, "template", "t", filepath.FromSlash("changelog/CHANGELOG.tmpl"), "read template from `file`")
+	pflag.StringVar(&opts.Compress, "compress", "auto", "compress the output with `method` (auto: by the extension of --output, none, gzip, zstd)")
+	pflag.StringVarP(&opts.TemplateFile, "template", "t", filepath.FromSlash("changelog/CHANGELOG.tmpl"), "read template from `file` or http(s) URL")
+	pflag.StringVar(&opts.TemplateSHA256, "template-sha256", "", "require the template to have the SHA256 checksum `hex`")
 	pflag.StringSliceVar(&opts.Versions, "version", nil, "only print `version` (separate multiple versions with commas)")
+	pflag.BoolVar(&opts.Latest, "latest", false, "only print the newest released version")
+	pflag.BoolVar(&opts.UnreleasedOnly, "unreleased-only", false, "only print the unreleased changes")
+	pflag.StringVar(&opts.From, "from", "", "only print the versions released on or after `date` (YYYY-MM-DD)")
+	pflag.StringVar(&opts.To, "to", "", "only print the versions released on or before `date` (YYYY-MM-DD)")
+	pflag.StringVar(&opts.Since, "since", "", "only print the versions newer than `version` and the unreleased changes")
+	pflag.StringVar(&opts.Versioning, "versioning", "semver", "parse release versions according to `scheme` (semver, calver, ordinal)")
+	pflag.IntVar(&opts.MaxParagraphs, "max-paragraphs", 0, "reject entries with more than `n` paragraphs (0: no limit)")
+	pflag.IntVar(&opts.MaxBodyLength, "max-body-length", 0, "reject entries with more than `n` characters in all paragraphs (0: no limit)")
+	pflag.IntVar(&opts.MaxTitleLength, "max-title-length", 80, "reject entries with more than `n` characters in the type and title (0: no limit)")
+	pflag.StringVar(&opts.TitlePunctuation, "title-punctuation", changelog.Punctuation, "reject entries whose title ends with one of the `characters` (empty: allow any)")
+	pflag.StringVar(&opts.TitleCase, "title-case", "none", "convert entry titles to `case` (none, sentence, title)")
+	pflag.StringSliceVar(&opts.TitleCaseKeep, "title-case-keep", nil, "keep the `words` (e.g. Windows,macOS) as written with --title-case")
+	pflag.StringSliceVar(&opts.RequireBody, "require-body", nil, "require at least one paragraph for entries of `types` (e.g. Change,Security)")
+	pflag.BoolVar(&opts.Review, "review", false, "release: review each unreleased entry before the release")
+	pflag.StringVar(&opts.DeferTo, "defer-to", "next", "release --review, defer: move deferred entries to the pending bucket `name` (\"unreleased\" for the next release)")
+	pflag.StringVar(&opts.Timezone, "timezone", "Local", "release: date the release with the current date in time zone `name` (e.g. UTC, Europe/Berlin)")
+	pflag.StringVar(&opts.Unreleased, "unreleased", "unreleased", "render and release the entries of the pending bucket `name` as the unreleased changes (e.g. 0.16.x for changelog/unreleased-0.16.x)")
+	pflag.BoolVar(&opts.Markers, "markers", false, "enclose the section for each version in markers, see --marker-format")
+	pflag.StringVar(&opts.MarkerFormat, "marker-format", "<!-- calens:{kind}:{version} -->", "format the markers according to `format`, {kind} is replaced by start or end")
+	pflag.BoolVar(&opts.URLOwnLine, "url-own-line", false, "wrapIndent: put URLs which do not fit on the current line on a line of their own")
+	pflag.StringVar(&opts.VersionURL, "version-url", "", "link versions with the template function versionURL according to `pattern`, {version} and {anchor} are replaced")
+	pflag.StringVar(&opts.GitTags, "git-tags", "", "read releases from annotated git tags matching `pattern` and entries from the input dir directly")
+	pflag.BoolVar(&opts.AssignByHistory, "assign-by-history", false, "add entry files in the input dir to the release of the first git tag containing them")
+	pflag.StringVar(&opts.ChangedSince, "changed-since", "", "only read entry files added or modified since the git `ref` (e.g. origin/master)")
+	pflag.StringVar(&opts.RepoURL, "repo-url", "", "provide `url` of the repository to the template as .Meta.RepoURL")
+	pflag.StringArrayVar(&opts.Set, "set", nil, "provide variable to the template as .Meta.Vars.`name=value` (can be specified multiple times)")
+	pflag.StringVar(&opts.Milestone, "milestone", "", "gate: check that all issues and pull requests in milestone `title` are referenced")
+	pflag.StringVar(&opts.GitHubRepo, "github-repo", "", "use the GitHub repository `owner/name` for API requests (default: derived from --repo-url)")
+	pflag.StringVar(&opts.PackageName, "package-name", "", "security-feed: name of the affected package")
+	pflag.BoolVar(&opts.Fix, "fix", false, "lint: apply unambiguous fixes to the entry files")
+	pflag.BoolVar(&opts.AllowHistoryEdit, "allow-history-edit", false, "lint: allow changes to the entries of released versions recorded in the history lock file")
+	pflag.StringVar(&opts.LintFormat, "format", "text", "lint: print problems in `format` (text, github-annotations)")
+	pflag.BoolVar(&opts.Quality, "quality", false, "stats: print the quality score of each release and the entries with problems")
+	pflag.StringVar(&opts.LabelPrefix, "label-prefix", "", "group entries by the GitHub labels of referenced issues starting with `prefix` (e.g. component/)")
+	pflag.BoolVar(&opts.MergeDuplicates, "merge-duplicates", false, "merge the entries of several directories for the same version instead of failing")
+	pflag.BoolVar(&opts.CollapsePrereleases, "collapse-prereleases", false, "render the entries of pre-releases (e.g. 1.3.0-rc.1) in the final release (1.3.0) if it exists")
+	pflag.BoolVar(&opts.KeepPrereleases, "keep-prereleases", false, "collapse-prereleases: keep the sections for the pre-releases as well")
+	pflag.StringSliceVar(&opts.TypePriority, "type-priority", nil, "order entry types by the list of `types` (e.g. Bugfix,Security), unlisted types follow")
+	pflag.StringToStringVar(&opts.TypeAbbreviation, "type-abbreviation", nil, "abbreviate entry types as given by `type=abbreviation` (e.g. Bugfix=Bug)")
+	pflag.StringToStringVar(&opts.TypeEmoji, "type-emoji", nil, "decorate entry types with an emoji or badge as given by `type=emoji`")
+	pflag.StringToStringVar(&opts.TypeAlias, "type-alias", nil, "accept `alias=type` as the type prefix of entries (e.g. fix=Bugfix)")
+	pflag.StringVar(&opts.SortBy, "sort-by", "id", "sort the entries of each type by `key` (id: primary issue or pull request, title, file: file name)")
+	pflag.BoolVar(&opts.IncludeEmpty, "include-empty", false, "include released versions without entries, with .Placeholder set")
+	pflag.StringVar(&opts.EmptyPlaceholder, "empty-placeholder", "No user-visible changes.", "provide `text` to the template as .Placeholder for versions without entries")
+	pflag.StringSliceVar(&opts.GitLabURLs, "gitlab-url", nil, "recognize issue and merge request URLs of the GitLab instances at `urls` in addition to gitlab.com")
+	pflag.StringSliceVar(&opts.BitbucketURLs, "bitbucket-url", nil, "recognize issue and pull request URLs of the Bitbucket Server instances at `urls` in addition to bitbucket.org")
+	pflag.StringSliceVar(&opts.GerritURLs, "gerrit-url", nil, "recognize change URLs of the Gerrit instances at `urls` as pull requests")
+	pflag.StringSliceVar(&opts.RedmineURLs, "redmine-url", nil, "recognize issue URLs of the Redmine instances at `urls`")
+	pflag.BoolVar(&opts.WarnSkipped, "warn-skipped", false, "print a warning for each file or directory which is ignored")
+	pflag.StringVar(&opts.WarnFormat, "warn-format", "text", "print warnings in `format` (text, json)")
+	pflag.StringVar(&opts.JiraURL, "jira-url", "", "recognize Jira issue keys (e.g. PROJ-1234) and links to the Jira instance at `url`")
+	pflag.StringSliceVar(&opts.JiraProjects, "jira-project", nil, "only recognize Jira issue keys of the `projects` (default: all)")
+	pflag.StringVar(&opts.Audience, "audience", "", "only render entries for `audience` (e.g. user, developer) and entries without an audience")
+	pflag.StringVar(&opts.Preset, "preset", "technical", "render the entries for an audience, `preset` is technical (all entries) or user-facing")
+	pflag.BoolVar(&opts.Internal, "internal", false, "also render entries with visibility internal")
+	pflag.StringSliceVar(&opts.InjectTargets, "target", nil, "inject: replace the sections enclosed in markers in the `files`")
+	pflag.StringVar(&opts.Repos, "repos", "", "multi: read the list of repositories from `file`")
+	pflag.BoolVar(&opts.Insecure, "insecure", false, "self-update: do not verify the signature of the checksums (not recommended)")
 	pflag.Parse()
+
+	restoreCommandLine = saveOptions()
+
+	loadConfig()
+
+	err := applyOptions()
+	if err != nil {
+		die("%v", err)
+	}
+
+	releaseTimezone, err = time.LoadLocation(opts.Timezone)
+	if err != nil {
+		die("invalid time zone %q: %v", opts.Timezone, err)
+	}
+
+	for _, d := range []struct {
+		flag  string
+		value string
+		date  *time.Time
+	}{{"from", opts.From, &dateFrom}, {"to", opts.To, &dateTo}} {
+		if d.value == "" {
+			continue
+		}
+		*d.date, err = time.Parse("2006-01-02", d.value)
+		if err != nil {
+			die("invalid date %q for --%v: %v", d.value, d.flag, err)
+		}
+	}
+
+	selections := 0
+	for _, set := range []bool{len(opts.Versions) > 0, opts.Latest, opts.UnreleasedOnly, opts.Since != ""} {
+		if set {
+			selections++
+		}
+	}
+	if selections > 1 {
+		die("only one of --version, --latest, --unreleased-only and --since can be used")
+	}
 }
 
 func die(msg string, args ...interface{}) {
@@ -44,546 +210,797 @@ func die(msg string, args ...interface{}) {
 	os.Exit(1)
 }
 
-// files lists all file names in dir. The file name is split by _, and the first component is used as the key in the resulting map.
-func files(dir string) []string {
-	d, err := os.Open(dir)
+// applyOptions checks the options which select a mode and sets up the
+// entry types, the link rules and the hooks of the changelog options
+// according to the flags and the config file.
+func applyOptions() error {
+	err := applyTypeOptions()
 	if err != nil {
-		die("error opening dir: %v", err)
+		return err
 	}
 
-	names, err := d.Readdirnames(-1)
+	rules, err := opts.ForgeRules()
 	if err != nil {
-		_ = d.Close()
-		die("error listing dir: %v", err)
+		return err
 	}
+	opts.LinkRules = append(opts.LinkRules, rules...)
 
-	err = d.Close()
-	if err != nil {
-		die("error closing dir: %v", err)
+	opts.Warn = warnSkipped
+	opts.Filter = isChanged
+	opts.DefaultRepo = githubRepo
+	if opts.AssignByHistory {
+		opts.Assign = assignReleasesByHistory
 	}
 
-	sort.Strings(names)
+	switch opts.Preset {
+	case "technical", "user-facing":
+	default:
+		return fmt.Errorf("unknown preset %q, valid presets: technical, user-facing", opts.Preset)
+	}
+
+	switch opts.TitleCase {
+	case "none", "sentence", "title":
+	default:
+		return fmt.Errorf("unknown title case %q, valid values: none, sentence, title", opts.TitleCase)
+	}
+
+	switch opts.SortBy {
+	case "id", "title", "file":
+	default:
+		return fmt.Errorf("unknown sort key %q, valid values: id, title, file", opts.SortBy)
+	}
+
+	return nil
+}
+
+// collapsePrereleases adds the entries of pre-releases like "1.3.0-rc.1" to
+// the final release "1.3.0", if it exists. The pre-releases are removed
+// unless --keep-prereleases is set. Pre-releases without a final release are
+// kept as they are.
+func collapsePrereleases(releases []changelog.Release) (result []changelog.Release) {
+	result = make([]changelog.Release, len(releases))
+	copy(result, releases)
 
-	var files []string
-	for _, name := range names {
-		// skip the template and versions file
-		if name == "TEMPLATE" || name == "releases" {
+	index := make(map[string]int)
+	for i, rel := range result {
+		index[rel.Version] = i
+	}
+
+	collapsed := make(map[int]bool)
+	for i, rel := range result {
+		base, ok := changelog.PrereleaseBase(rel.Scheme, rel.Version)
+		if !ok {
 			continue
 		}
 
-		// skip dot files
-		if strings.HasPrefix(name, ".") {
+		j, ok := index[base]
+		if !ok {
 			continue
 		}
 
-		files = append(files, filepath.Join(dir, name))
+		final := &result[j]
+		if final.Files == nil {
+			final.Files = files(final.Path)
+		} else {
+			final.Files = append([]string{}, final.Files...)
+		}
+
+		list := rel.Files
+		if list == nil {
+			list = files(rel.Path)
+		}
+		final.Files = append(final.Files, list...)
+
+		collapsed[i] = true
 	}
 
-	return files
-}
+	if opts.KeepPrereleases {
+		return result
+	}
 
-// Release is one release, with an optional release date.
-type Release struct {
-	path    string
-	Version string
-	Date    *time.Time
+	var list []changelog.Release
+	for i, rel := range result {
+		if !collapsed[i] {
+			list = append(list, rel)
+		}
+	}
+
+	return list
 }
 
-// ReleaseSlice allows sorting a slice of releases by the release date
-// with Go < 1.8
-type ReleaseSlice []Release
+// applyTypeOptions changes the priorities, abbreviations and emoji of the
+// entry types as set with --type-priority, --type-abbreviation and
+// --type-emoji, and adds the aliases set with --type-alias.
+func applyTypeOptions() error {
+	if len(opts.TypePriority) > 0 {
+		prio := make(map[string]int)
+		for i, typ := range opts.TypePriority {
+			typ = changelog.Capitalize(strings.TrimSpace(typ))
+			if _, ok := changelog.EntryTypePriority[typ]; !ok {
+				return fmt.Errorf("unknown entry type %q in --type-priority, valid types: %v", typ, strings.Join(changelog.EntryTypes(), ", "))
+			}
+			prio[typ] = i + 1
+		}
 
-// Len is the number of elements in the collection.
-func (s ReleaseSlice) Len() int {
-	return len(s)
-}
+		// all other types keep their order after the listed types
+		for i, typ := range changelog.EntryTypes() {
+			if _, ok := prio[typ]; !ok {
+				prio[typ] = len(opts.TypePriority) + i + 1
+			}
+		}
 
-// Less reports whether the element with
-// index i should sort before the element with index j.
-func (s ReleaseSlice) Less(i, j int) bool {
-	if s[i].Date == nil {
-		return true
+		changelog.EntryTypePriority = prio
 	}
 
-	if s[j].Date == nil {
-		return false
+	for typ, abbrev := range opts.TypeAbbreviation {
+		typ = changelog.Capitalize(strings.TrimSpace(typ))
+		if _, ok := changelog.EntryTypePriority[typ]; !ok {
+			return fmt.Errorf("unknown entry type %q in --type-abbreviation, valid types: %v", typ, strings.Join(changelog.EntryTypes(), ", "))
+		}
+		changelog.EntryTypeAbbreviation[typ] = abbrev
 	}
 
-	return s[j].Date.Before(*s[i].Date)
-}
+	for typ, emoji := range opts.TypeEmoji {
+		typ = changelog.Capitalize(strings.TrimSpace(typ))
+		if _, ok := changelog.EntryTypePriority[typ]; !ok {
+			return fmt.Errorf("unknown entry type %q in --type-emoji, valid types: %v", typ, strings.Join(changelog.EntryTypes(), ", "))
+		}
+		changelog.EntryTypeEmoji[typ] = emoji
+	}
 
-// Swap swaps the elements with indexes i and j.
-func (s ReleaseSlice) Swap(i, j int) {
-	s[i], s[j] = s[j], s[i]
+	for alias, typ := range opts.TypeAlias {
+		typ = changelog.Capitalize(strings.TrimSpace(typ))
+		if _, ok := changelog.EntryTypePriority[typ]; !ok {
+			return fmt.Errorf("unknown entry type %q in --type-alias, valid types: %v", typ, strings.Join(changelog.EntryTypes(), ", "))
+		}
+		changelog.EntryTypeAliases[strings.ToLower(strings.TrimSpace(alias))] = typ
+	}
+
+	return nil
 }
 
-var versionRegex = regexp.MustCompile(`^([^_]+)(?:_(\d{4}-\d{2}-\d{2}))?$`)
+// files lists all entry files in dir, sorted by name. Subdirectories are only
+// read with --recursive.
+func files(dir string) []string {
+	list, err := opts.Files(dir)
+	if err != nil {
+		die("%v", err)
+	}
+	return list
+}
 
-// readReleases lists the directory and parses all releases from the subdir
-// names there. A valid release subdir has the format "x.y.z_YYYY-MM-DD", the
-// underscore and date is optional (for unreleased versions). The resulting
-// slice is sorted by the release dates, starting with unreleased versions and
-// continuing with the other versions, newest first.
-func readReleases(dir string) (result []Release) {
-	f, err := os.Open(dir)
+// readReleases returns the releases in dir, from the annotated git tags with
+// --git-tags and from the release subdirs otherwise, see
+// changelog.Options.ReadReleases.
+func readReleases(dir string) []changelog.Release {
+	releases, err := loadReleases(dir)
 	if err != nil {
-		die("unable to open dir: %v", err)
+		die("%v", err)
+	}
+	return releases
+}
+
+// loadReleases is like readReleases, but returns errors.
+func loadReleases(dir string) ([]changelog.Release, error) {
+	if opts.GitTags != "" {
+		return readReleasesGit(dir, opts.GitTags)
 	}
+	return opts.ReadReleases(dir)
+}
 
-	entries, err := f.Readdir(-1)
+// readEntries returns the entries of the releases by version, sorted
+// according to the type priority and --sort-by.
+func readEntries(releases []changelog.Release) map[string][]changelog.Entry {
+	entries, err := opts.ReadEntries(releases)
 	if err != nil {
-		die("unable to list directory: %v", err)
+		die("%v", err)
 	}
+	return entries
+}
 
-	err = f.Close()
+// readFile parses the entry in filename and exits with an error message if
+// the file is invalid.
+func readFile(filename string) changelog.Entry {
+	e, err := opts.ParseFile(filename)
 	if err != nil {
-		die("close dir: %v", err)
+		die("%v", err)
 	}
+	return e
+}
 
-	for _, entry := range entries {
-		if !entry.Mode().IsDir() {
-			continue
-		}
+// listItemRegexp matches the marker of a Markdown list item.
+var listItemRegexp = regexp.MustCompile(`^(?:[-*+]|\d+[.)])\s+`)
 
-		if entry.Name() == "unreleased" {
-			rel := Release{
-				path:    filepath.Join(dir, entry.Name()),
-				Version: "unreleased",
-			}
-			result = append(result, rel)
-			continue
-		}
+// wrapIndent formats the text in a column smaller than width characters,
+// indenting each new line with indent spaces. The lines of a paragraph are
+// wrapped one by one: continuation lines of a list item are indented to the
+// text after the marker, continuation lines of a blockquote start with "> "
+// as well, and table rows are kept as they are. The optional prefix (e.g.
+// "* " for a bullet) is prepended to the first line, which is shortened
+// accordingly.
+func wrapIndent(text string, width, indent int, prefix ...string) (result string, err error) {
+	first := strings.Join(prefix, "")
+	start := runewidth.StringWidth(first)
 
-		data := versionRegex.FindStringSubmatch(entry.Name())
-		if len(data) == 0 {
-			die("invalid subdir name %v", filepath.Join(dir, entry.Name()))
-			continue
+	if strings.HasPrefix(text, "```") {
+		parts := strings.Split(text, "\n")
+		sep := "\n" + strings.Repeat(" ", indent)
+		return first + strings.Join(parts, sep), nil
+	}
+
+	var lines []string
+	for i, line := range strings.Split(text, "\n") {
+		item := strings.TrimLeft(line, " ")
+		level := len(line) - len(item)
+		spaces := strings.Repeat(" ", level)
+		if i > 0 {
+			start = 0
 		}
 
-		ver, err := semver.NewVersion(data[1])
+		var wrapped string
+		switch {
+		case strings.HasPrefix(item, "|"):
+			wrapped = item
+		case strings.HasPrefix(item, ">"):
+			quote := strings.TrimSpace(strings.TrimPrefix(item, ">"))
+			wrapped, err = wrapWords(quote, start, width-level-2, "\n"+strings.Repeat(" ", indent)+spaces+"> ")
+			wrapped = strings.TrimRight("> "+wrapped, " ")
+		default:
+			marker := listItemRegexp.FindString(item)
+			wrapped, err = wrapWords(item, start, width-level, "\n"+strings.Repeat(" ", indent+level+len(marker)))
+		}
 		if err != nil {
-			die("invalid subdir name %v. Parsing semver returned error: %v", filepath.Join(dir, entry.Name()), err)
+			return "", err
 		}
-		date := data[2]
 
-		rel := Release{
-			path:    filepath.Join(dir, entry.Name()),
-			Version: ver.String(),
-		}
+		lines = append(lines, spaces+wrapped)
+	}
 
-		if date != "" {
-			t, err := time.Parse("2006-01-02", date)
-			if err != nil {
-				die("unable to parse date %q: %v", date, err)
-			}
-			rel.Date = &t
+	return first + strings.Join(lines, "\n"+strings.Repeat(" ", indent)), nil
+}
+
+// wrapWords joins the words in text to lines smaller than width characters,
+// the lines are separated by sep. The first line starts at column start. The
+// width of a word is its display width, so wide characters (e.g. CJK
+// characters and emoji) count as two. Words (and URLs) are never split.
+func wrapWords(text string, start, width int, sep string) (result string, err error) {
+	sc := bufio.NewScanner(strings.NewReader(text))
+	sc.Split(bufio.ScanWords)
+	cl := start
+	empty := true    // no word on the current line yet
+	ownLine := false // the previous word needs a line of its own
+	for sc.Scan() {
+		if sc.Err() != nil {
+			return "", sc.Err()
 		}
 
-		result = append(result, rel)
-	}
+		spaceLen := 0
+		if !empty {
+			// account for space between words, if there's already a word on the
+			// current line
+			spaceLen = 1
+		}
 
-	sort.Sort(ReleaseSlice(result))
+		wordLen := runewidth.StringWidth(sc.Text())
+		if !empty && (cl+spaceLen+wordLen > width || ownLine) {
+			result += sep
+			cl = 0
+			empty = true
+		}
 
-	return result
-}
+		// with --url-own-line, a URL which does not fit on the current line
+		// is put on a line of its own
+		ownLine = opts.URLOwnLine && empty && cl == 0 && isURL(sc.Text())
 
-// Entry describes a change.
-type Entry struct {
-	Type       string
-	TypeShort  string
-	Title      string
-	Paragraphs []string
-	URLs       []*url.URL
-	Issues     []string
-	IssueURLs  []*url.URL
-	PRs        []string
-	PRURLs     []*url.URL
-	OtherURLs  []*url.URL
-	PrimaryID  int64
-	PrimaryURL *url.URL
-}
+		if !empty {
+			result += " "
+			cl++
+		}
+		result += sc.Text()
+		cl += wordLen
+		empty = false
+	}
 
-// EntryTypePriority contains the list of valid types, order is priority in the changelog.
-var EntryTypePriority = map[string]int{
-	"Security":    1,
-	"Bugfix":      2,
-	"Change":      3,
-	"Enhancement": 4,
+	return result, nil
 }
 
-// EntryTypeAbbreviation contains the shortened entry types for the overview.
-var EntryTypeAbbreviation = map[string]string{
-	"Security":    "Sec",
-	"Bugfix":      "Fix",
-	"Change":      "Chg",
-	"Enhancement": "Enh",
+var helperFuncs = template.FuncMap{
+	"wrapIndent": wrapIndent,
+	"capitalize": changelog.Capitalize,
+	"date":       formatDate,
+	"var":        templateVar,
+	"hasType":    hasType,
+	"countType":  countType,
+	"versionURL": versionURL,
+	"pad":        pad,
+	"rpad":       rpad,
 }
 
-// EntrySlice allows sorting a slice of releases by the priority of the entry
-// (as defined in EntryTypePriority) with Go < 1.8
-type EntrySlice []Entry
-
-// Len is the number of elements in the collection.
-func (s EntrySlice) Len() int {
-	return len(s)
-}
+// templateFuncs returns the functions available in templates: the sprig
+// functions and helperFuncs.
+func templateFuncs() template.FuncMap {
+	funcMap := sprig.GenericFuncMap()
 
-// Less reports whether the element with
-// index i should sort before the element with index j.
-func (s EntrySlice) Less(i, j int) bool {
-	if s[i].Type == s[j].Type {
-		return s[i].PrimaryID < s[j].PrimaryID
+	for i, m := range helperFuncs {
+		funcMap[i] = m
 	}
-	return EntryTypePriority[s[i].Type] < EntryTypePriority[s[j].Type]
-}
 
-// Swap swaps the elements with indexes i and j.
-func (s EntrySlice) Swap(i, j int) {
-	s[i], s[j] = s[j], s[i]
+	return funcMap
 }
 
-// Punctuation contains all the characters that are not allowed as the last character in the title.
-const Punctuation = ".!?"
+// VersionChanges is the data passed to the template for each release.
+type VersionChanges struct {
+	Version string
+	Date    string
+	Entries []changelog.Entry
 
-// Valid returns an error if the entry is invalid in any way.
-func (e Entry) Valid() error {
-	if e.Type == "" {
-		return errors.New("entry title does not have a prefix, example: Bugfix: restore old behavior")
-	}
+	// Codename, Summary and Yanked describe the release, see ReleaseNotes.
+	Codename string
+	Summary  string
+	Yanked   bool
 
-	if e.Title == "" {
-		return errors.New("entry does not have a title")
-	}
+	// Intro contains the paragraphs of the _intro.md file in the release
+	// directory, to be rendered before the entries.
+	Intro []string
 
-	if e.PrimaryID == 0 {
-		return errors.New("primary issue ID not found")
-	}
+	// Contributors lists the authors of all entries without duplicates, in
+	// the order of the entries.
+	Contributors []string
 
-	lastChar := e.Title[len(e.Title)-1]
-	if strings.ContainsAny(string(lastChar), Punctuation) {
-		return fmt.Errorf("title ends with punctuation, e.g. a character out of %q", Punctuation)
-	}
+	// Breaking lists the entries marked as breaking changes, which are also
+	// contained in Entries.
+	Breaking []changelog.Entry
 
-	if _, ok := EntryTypePriority[e.Type]; !ok {
-		return fmt.Errorf("entry type %q is invalid, valid types: %v", e.Type, EntryTypePriority)
-	}
+	// Placeholder is the text to render instead of the entries for releases
+	// without any entries, which are only included with --include-empty.
+	Placeholder string
 
-	if len(e.Type)+len(e.Title)+1 > 80 {
-		return errors.New("title is too long (max 80 characters)")
-	}
+	// Groups contains the entries grouped by component, it is only set if
+	// at least one entry has a component.
+	Groups []EntryGroup
+}
 
-	return nil
+// marker returns the marker of kind "start" or "end" for version, formatted
+// according to --marker-format.
+func marker(kind, version string) string {
+	return strings.NewReplacer("{kind}", kind, "{version}", version).Replace(opts.MarkerFormat)
 }
 
-func readFile(filename string) (e Entry) {
-	f, err := os.Open(filename)
-	if err != nil {
-		die("unable to open %v: %v", filename, err)
-	}
+// markerRegexp returns a regexp which matches the markers of kind for all
+// versions, see marker.
+func markerRegexp(kind string) *regexp.Regexp {
+	pattern := regexp.QuoteMeta(opts.MarkerFormat)
+	pattern = strings.Replace(pattern, regexp.QuoteMeta("{kind}"), regexp.QuoteMeta(kind), -1)
+	pattern = strings.Replace(pattern, regexp.QuoteMeta("{version}"), `[^\n]*?`, -1)
+	return regexp.MustCompile(pattern)
+}
 
-	sc := bufio.NewScanner(f)
-	if !sc.Scan() {
-		die("unable to read first line from %v", filename)
+// renderChangelog executes templ for changes and writes the output to wr.
+// With --markers, the template is executed for each version on its own and
+// the output is enclosed in the start and end marker of the version, so that
+// the sections can be found and replaced later (see calens inject).
+func renderChangelog(wr io.Writer, templ *template.Template, changes Changelog) error {
+	if !opts.Markers {
+		return templ.Execute(wr, changes)
 	}
 
-	title := sc.Text()
-	data := strings.SplitN(title, ": ", 2)
-	if len(data) == 2 {
-		e.Type = strings.TrimSpace(capitalize(data[0]))
-		e.TypeShort = EntryTypeAbbreviation[e.Type]
-		data = data[1:]
-	}
-	e.Title = strings.TrimSpace(capitalize(data[0]))
+	for i, vc := range changes {
+		var buf bytes.Buffer
+		err := templ.Execute(&buf, Changelog{vc})
+		if err != nil {
+			return err
+		}
 
-	var text []string
-	var sect string
-	var verbatim bool // inside verbatim section
-	for sc.Scan() {
-		if sc.Err() != nil {
-			die("unable to read lines from %v: %v", filename, sc.Err())
+		section := strings.Trim(buf.String(), "\n")
+		if section != "" {
+			section += "\n"
 		}
 
-		trimmedText := strings.TrimSpace(sc.Text())
-		if !verbatim && strings.HasPrefix(trimmedText, "```") {
-			// start new paragraph
-			if sect != "" {
-				text = append(text, sect)
+		// separate the sections with an empty line
+		if i > 0 {
+			_, err = io.WriteString(wr, "\n")
+			if err != nil {
+				return err
 			}
-			sect = trimmedText
-			verbatim = true
-			continue
 		}
 
-		// ignore new lines inside verbatim section
-		if !verbatim && trimmedText == "" {
-			if sect != "" {
-				text = append(text, sect)
-			}
+		_, err = fmt.Fprintf(wr, "%v\n%v%v\n", marker("start", vc.Version), section, marker("end", vc.Version))
+		if err != nil {
+			return err
+		}
+	}
 
-			sect = ""
+	return nil
+}
+
+// buildChanges reads the entries for the releases and returns the data for
+// the template. Releases without entries are skipped, unless --include-empty
+// is set.
+func buildChanges(releases []changelog.Release) (changes Changelog) {
+	all := readEntries(releases)
+	for _, ver := range releases {
+		entries := filterEntries(all[ver.Version])
+
+		// with --include-empty, only released versions without entries are
+		// kept
+		if len(entries) == 0 && (!opts.IncludeEmpty || ver.Date == nil) {
 			continue
 		}
 
-		if verbatim {
-			if sect != "" {
-				sect += "\n"
-			}
-			sect += sc.Text()
-		} else {
-			if sect != "" {
-				sect += " "
-			}
-			sect += trimmedText
-		}
+		changes = append(changes, versionChanges(ver, entries))
+	}
+
+	return changes
+}
 
-		if verbatim && trimmedText == "```" {
-			verbatim = false
+// versionChanges returns the data for the template for the entries of the
+// release ver.
+func versionChanges(ver changelog.Release, entries []changelog.Entry) VersionChanges {
+	vc := VersionChanges{
+		Version:  ver.Version,
+		Codename: ver.Codename,
+		Summary:  ver.Summary,
+		Yanked:   ver.Yanked,
+		Intro:    ver.Intro,
+		Entries:  entries,
+	}
+
+	seen := make(map[string]struct{})
+	for _, e := range entries {
+		if e.Breaking {
+			vc.Breaking = append(vc.Breaking, e)
 		}
-	}
 
-	if verbatim {
-		die("unmatched verbatim tag in %v", filename)
+		for _, author := range e.Authors {
+			if _, ok := seen[strings.ToLower(author)]; ok {
+				continue
+			}
+			seen[strings.ToLower(author)] = struct{}{}
+			vc.Contributors = append(vc.Contributors, author)
+		}
 	}
 
-	err = f.Close()
-	if err != nil {
-		die("error closing %v: %v", filename, err)
+	if ver.Date != nil {
+		vc.Date = ver.Date.Format("2006-01-02")
+	} else {
+		vc.Date = "UNRELEASED"
 	}
 
-	if sect != "" {
-		text = append(text, sect)
+	if len(vc.Entries) == 0 {
+		vc.Placeholder = opts.EmptyPlaceholder
 	}
 
-	if len(text) > 0 {
-		links := text[len(text)-1]
-		text = text[:len(text)-1]
-
-		sc = bufio.NewScanner(strings.NewReader(links))
-		sc.Split(bufio.ScanWords)
-		for sc.Scan() {
-			url, err := url.Parse(sc.Text())
-			if err != nil {
-				die("file %v: unable to parse url %q: %v", filename, sc.Text(), err)
-			}
-			e.URLs = append(e.URLs, url)
+	if opts.LabelPrefix != "" {
+		for i := range vc.Entries {
+			addComponents(&vc.Entries[i], opts.LabelPrefix)
 		}
 	}
 
-	for _, par := range text {
-		e.Paragraphs = append(e.Paragraphs, capitalize(strings.TrimSpace(par)))
+	for _, e := range vc.Entries {
+		if len(e.Components) > 0 {
+			vc.Groups = groupByComponent(vc.Entries)
+			break
+		}
 	}
 
-	githubIDs(e.URLs, &e)
+	return vc
+}
 
-	err = e.Valid()
-	if err != nil {
-		die("file %v: %v", filename, err)
+func main() {
+	switch pflag.Arg(0) {
+	case "":
+		generate()
+	case "release":
+		runRelease(pflag.Args()[1:])
+	case "defer":
+		runDefer(pflag.Args()[1:])
+	case "badge":
+		runBadge(pflag.Args()[1:])
+	case "gate":
+		runGate(pflag.Args()[1:])
+	case "security-feed":
+		runSecurityFeed(pflag.Args()[1:])
+	case "self-update":
+		runSelfUpdate(pflag.Args()[1:])
+	case "lint":
+		runLint(pflag.Args()[1:])
+	case "provenance":
+		runProvenance(pflag.Args()[1:])
+	case "inject":
+		runInject(pflag.Args()[1:])
+	case "lsp":
+		runLSP(pflag.Args()[1:])
+	case "bump":
+		runBump(pflag.Args()[1:])
+	case "checklist":
+		runChecklist(pflag.Args()[1:])
+	case "explain":
+		runExplain(pflag.Args()[1:])
+	case "preview-entry":
+		runPreviewEntry(pflag.Args()[1:])
+	case "stats":
+		runStats(pflag.Args()[1:])
+	case "lock":
+		runLock(pflag.Args()[1:])
+	case "multi":
+		runMulti(pflag.Args()[1:])
+	default:
+		die("unknown command %q", pflag.Arg(0))
 	}
-
-	return e
 }
 
-var (
-	issueRegexp       = regexp.MustCompile(`/.*/.*/issues/(\d+)`)
-	pullRequestRegexp = regexp.MustCompile(`/.*/.*/pull/(\d+)`)
-)
+// dateFrom and dateTo are the dates set with --from and --to, they are zero
+// if not set.
+var dateFrom, dateTo time.Time
 
-func safeParseInt(str string) int64 {
-	val, err := strconv.ParseInt(str, 10, 64)
-	if err != nil {
-		die("unable to parse issue/PR ID %q: %v", str, err)
+// selectReleases returns the releases selected on the command line: the
+// versions selected with selectVersions, and with --from or --to only the
+// ones released in this date range.
+func selectReleases(allReleases []changelog.Release) []changelog.Release {
+	releases := selectVersions(allReleases)
+	if dateFrom.IsZero() && dateTo.IsZero() {
+		return releases
 	}
-	return val
-}
-
-// githubIDs extracts all issue and pull request IDs from the urls.
-func githubIDs(urls []*url.URL, e *Entry) {
-	for _, url := range urls {
-		if url.Host != "github.com" {
-			e.OtherURLs = append(e.OtherURLs, url)
-			continue
-		}
 
+	var result []changelog.Release
+	for _, rel := range releases {
 		switch {
-		case issueRegexp.MatchString(url.Path):
-			data := issueRegexp.FindStringSubmatch(url.Path)
-			id := data[1]
-			e.Issues = append(e.Issues, id)
-			e.IssueURLs = append(e.IssueURLs, url)
-
-			if e.PrimaryID == 0 {
-				e.PrimaryID = safeParseInt(id)
-				e.PrimaryURL = url
-			}
-		case pullRequestRegexp.MatchString(url.Path):
-			data := pullRequestRegexp.FindStringSubmatch(url.Path)
-			id := data[1]
-			e.PRs = append(e.PRs, id)
-			e.PRURLs = append(e.PRURLs, url)
-
-			if e.PrimaryID == 0 {
-				e.PrimaryID = safeParseInt(id)
-				e.PrimaryURL = url
-			}
+		case rel.Date == nil:
+		case !dateFrom.IsZero() && rel.Date.Before(dateFrom):
+		case !dateTo.IsZero() && rel.Date.After(dateTo):
 		default:
-			e.OtherURLs = append(e.OtherURLs, url)
+			result = append(result, rel)
 		}
 	}
+
+	return result
 }
 
-func readEntries(versions []Release) (entries map[string][]Entry) {
-	entries = make(map[string][]Entry)
+// selectVersions returns the versions selected on the command line. With
+// --collapse-prereleases, pre-releases are collapsed first (see
+// collapsePrereleases).
+func selectVersions(allReleases []changelog.Release) (releases []changelog.Release) {
+	if opts.CollapsePrereleases {
+		allReleases = collapsePrereleases(allReleases)
+	}
 
-	for _, ver := range versions {
-		for _, file := range files(ver.path) {
-			entries[ver.Version] = append(entries[ver.Version], readFile(file))
-		}
+	if opts.Latest {
+		return latestRelease(allReleases)
 	}
 
-	// sort all entries according to priority, otherwise leave the original ordering
-	for ver, list := range entries {
-		sort.Stable(EntrySlice(list))
-		entries[ver] = list
+	if opts.Since != "" {
+		return sinceReleases(allReleases, opts.Since)
 	}
 
-	return entries
-}
+	if opts.UnreleasedOnly {
+		for _, rel := range allReleases {
+			if rel.Version == "unreleased" {
+				releases = append(releases, rel)
+			}
+		}
+		return releases
+	}
 
-// wrapIndent formats the text in a column smaller than width characters,
-// indenting each new line with indent spaces.
-func wrapIndent(text string, width, indent int) (result string, err error) {
-	if strings.HasPrefix(text, "```") {
-		parts := strings.Split(text, "\n")
-		sep := "\n" + strings.Repeat(" ", indent)
-		return strings.Join(parts, sep), nil
+	if len(opts.Versions) == 0 {
+		return allReleases
 	}
 
-	sc := bufio.NewScanner(strings.NewReader(text))
-	sc.Split(bufio.ScanWords)
-	cl := 0
-	for sc.Scan() {
-		if sc.Err() != nil {
-			return "", sc.Err()
+	for _, rel := range allReleases {
+		for _, ver := range opts.Versions {
+			// versions are normalized like the release directory names,
+			// e.g. "v1.2" selects "1.2.0"
+			if parsed, err := opts.ParseVersion(ver); err == nil && rel.Scheme != nil {
+				ver = parsed.Version
+			}
+
+			if ver == rel.Version {
+				releases = append(releases, rel)
+			}
 		}
+	}
 
-		spaceLen := 0
-		if cl > 0 {
-			// account for space between words, if there's already a word on the
-			// current line
-			spaceLen = 1
+	return releases
+}
+
+// sinceReleases returns the unreleased changes and the releases newer than
+// version, which must be one of the releases. Versions are compared
+// according to the versioning scheme, versions used verbatim (e.g. from the
+// releases file) are newer if they are listed before version.
+func sinceReleases(releases []changelog.Release, version string) (result []changelog.Release) {
+	// versions are normalized like the release directory names
+	parsed, err := opts.ParseVersion(version)
+
+	idx := -1
+	for i, rel := range releases {
+		ver := version
+		if err == nil && rel.Scheme != nil {
+			ver = parsed.Version
 		}
 
-		if cl+spaceLen+len(sc.Text()) > width {
-			result += "\n"
-			result += strings.Repeat(" ", indent)
-			cl = 0
+		if rel.Version == ver && rel.Version != "unreleased" {
+			idx = i
+			break
 		}
+	}
 
-		if cl > 0 {
-			result += " "
-			cl++
+	if idx < 0 {
+		die("version %v not found", version)
+	}
+	since := releases[idx]
+
+	for i, rel := range releases {
+		switch {
+		case rel.Version == "unreleased":
+		case rel.Scheme != nil && since.Scheme != nil:
+			if rel.Scheme.Compare(rel.Version, since.Version) <= 0 {
+				continue
+			}
+		case i >= idx:
+			continue
 		}
-		result += sc.Text()
-		cl += len(sc.Text())
+
+		result = append(result, rel)
 	}
 
-	return result, nil
+	return result
 }
 
-// capitalize returns a string with the first letter in upper case.
-func capitalize(text string) string {
-	if text == "" {
-		return text
+// readTemplate returns the content of the template name, which is either a
+// file or an http(s) URL. If sum is not empty, the SHA256 checksum of the
+// content must match it.
+func readTemplate(name, sum string) (buf []byte, err error) {
+	if isURL(name) {
+		buf, err = download(name)
+	} else {
+		buf, err = ioutil.ReadFile(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if sum != "" {
+		err = checkSHA256(buf, sum)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	first, rest := text[0:1], text[1:]
-	return strings.ToUpper(first) + rest
+	return buf, nil
 }
 
-var helperFuncs = template.FuncMap{
-	"wrapIndent": wrapIndent,
-	"capitalize": capitalize,
+// checkSHA256 returns an error unless buf has the SHA256 checksum sum, given
+// in hex.
+func checkSHA256(buf []byte, sum string) error {
+	hash := sha256.Sum256(buf)
+	if !strings.EqualFold(hex.EncodeToString(hash[:]), sum) {
+		return fmt.Errorf("checksum mismatch, want SHA256 %v, got %x", sum, hash)
+	}
+	return nil
 }
 
-func main() {
-	buf, err := ioutil.ReadFile(opts.TemplateFile)
+// loadTemplate reads and compiles the template given by --template.
+func loadTemplate() *template.Template {
+	buf, err := readTemplate(opts.TemplateFile, opts.TemplateSHA256)
 	if err != nil {
 		die("unable to read template from %v: %v", opts.TemplateFile, err)
 	}
 
-	funcMap := sprig.GenericFuncMap()
-
-	for i, m := range helperFuncs {
-		funcMap[i] = m
-	}
-
-	templ, err := template.New("").Funcs(funcMap).Parse(string(buf))
+	templ, err := template.New("").Funcs(templateFuncs()).Parse(string(buf))
 
 	if err != nil {
 		die("unable to compile template: %v", err)
 	}
 
-	type VersionChanges struct {
-		Version string
-		Date    string
-		Entries []Entry
-	}
+	return templ
+}
 
-	allReleases := readReleases(opts.InputDir)
+// generate renders the changelog from the template.
+func generate() {
+	templ := loadTemplate()
 
-	var changes []VersionChanges
-	var releases []Release
+	changes := buildChanges(selectReleases(readReleases(opts.InputDir)))
 
-	if len(opts.Versions) == 0 {
-		releases = allReleases
-	} else {
-		for _, rel := range allReleases {
-			for _, ver := range opts.Versions {
-				if ver == rel.Version {
-					releases = append(releases, rel)
-				}
-			}
-		}
-	}
+	// check the meta information before writing anything
+	changes.Meta()
 
-	all := readEntries(releases)
-	for _, ver := range releases {
-		if len(all[ver.Version]) == 0 {
-			continue
+	writeOutput(func(wr io.Writer) error {
+		err := renderChangelog(wr, templ, changes)
+		if err != nil {
+			return fmt.Errorf("error executing template: %v", err)
 		}
+		return nil
+	})
+}
 
-		vc := VersionChanges{
-			Version: ver.Version,
-			Entries: all[ver.Version],
+// writeOutput calls fn with the file given by --output, or stdout if it is
+// not set, and exits with an error message if anything fails. The output is
+// piped through the post-processors from the config and compressed as set
+// with --compress.
+func writeOutput(fn func(wr io.Writer) error) {
+	if len(postProcessors) > 0 {
+		var buf bytes.Buffer
+		err := fn(&buf)
+		if err != nil {
+			die("%v", err)
 		}
 
-		if ver.Date != nil {
-			vc.Date = ver.Date.Format("2006-01-02")
-		} else {
-			vc.Date = "UNRELEASED"
+		processed, err := postProcess(opts.Output, buf.Bytes())
+		if err != nil {
+			die("%v", err)
 		}
 
-		changes = append(changes, vc)
+		fn = func(wr io.Writer) error {
+			_, err := wr.Write(processed)
+			return err
+		}
 	}
 
-	wr := os.Stdout
-
-	if opts.Output != "" {
-		wr, err = os.Create(opts.Output)
+	if opts.Output == "" {
+		err := compressOutput(os.Stdout, fn)
 		if err != nil {
-			die("unable to create file %v: %v", opts.Output, err)
+			die("%v", err)
 		}
+		return
+	}
+
+	wr, err := os.Create(opts.Output)
+	if err != nil {
+		die("unable to create file %v: %v", opts.Output, err)
 	}
 
-	err = templ.Execute(wr, changes)
+	err = compressOutput(wr, fn)
 	if err != nil {
-		die("error executing template: %v", err)
+		_ = wr.Close()
+		die("%v", err)
+	}
+
+	err = wr.Close()
+	if err != nil {
+		die("error closing file %v: %v", opts.Output, err)
+	}
+}
+
+// compression returns the compression for the output: the one set with
+// --compress, or for "auto" the one matching the extension of --output.
+func compression() string {
+	if opts.Compress != "auto" {
+		return opts.Compress
 	}
 
-	if opts.Output != "" {
-		err = wr.Close()
+	switch filepath.Ext(opts.Output) {
+	case ".gz":
+		return "gzip"
+	case ".zst":
+		return "zstd"
+	default:
+		return "none"
+	}
+}
+
+// compressOutput calls fn with a writer which compresses the data written to
+// it before writing it to wr.
+func compressOutput(wr io.Writer, fn func(wr io.Writer) error) error {
+	var cw io.WriteCloser
+	switch c := compression(); c {
+	case "none":
+		return fn(wr)
+	case "gzip":
+		cw = gzip.NewWriter(wr)
+	case "zstd":
+		var err error
+		cw, err = zstd.NewWriter(wr)
 		if err != nil {
-			die("error closing file %v: %v", opts.Output, err)
+			return err
 		}
+	default:
+		return fmt.Errorf("unknown compression %q, valid values: auto, none, gzip, zstd", c)
 	}
+
+	err := fn(cw)
+	if err != nil {
+		_ = cw.Close()
+		return err
+	}
+
+	return cw.Close()
 }
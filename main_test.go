@@ -1,14 +1,33 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
+	"text/template"
 	"time"
 
 	"github.com/go-test/deep"
+	"github.com/klauspost/compress/zstd"
+	"github.com/restic/calens/changelog"
 )
 
 func parseURL(t testing.TB, s string) *url.URL {
@@ -19,6 +38,15 @@ func parseURL(t testing.TB, s string) *url.URL {
 	return url
 }
 
+// forgeRules returns the link rules for all known and configured forges.
+func forgeRules(t testing.TB) []changelog.LinkRule {
+	rules, err := opts.ForgeRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rules
+}
+
 func ptrTime(v time.Time) *time.Time {
 	return &v
 }
@@ -26,14 +54,18 @@ func ptrTime(v time.Time) *time.Time {
 func TestReadFile(t *testing.T) {
 	var tests = []struct {
 		Data string
-		Entry
+		changelog.Entry
 	}{
 		{
 			"Bugfix: subject line\n\nhttps://github.com/restic/restic/issues/12345",
-			Entry{
+			changelog.Entry{
+				References: []changelog.Reference{
+					{Kind: "issue", ID: "12345", URL: "https://github.com/restic/restic/issues/12345", Text: "#12345"},
+				},
 				Title:      "Subject line",
 				Type:       "Bugfix",
 				TypeShort:  "Fix",
+				TypeEmoji:  "🐛",
 				PrimaryID:  12345,
 				PrimaryURL: parseURL(t, "https://github.com/restic/restic/issues/12345"),
 				URLs: []*url.URL{
@@ -63,10 +95,15 @@ links.
 https://github.com/restic/restic/issues/12345
 https://github.com/restic/restic/pull/666666
 `,
-			Entry{
+			changelog.Entry{
+				References: []changelog.Reference{
+					{Kind: "issue", ID: "12345", URL: "https://github.com/restic/restic/issues/12345", Text: "#12345"},
+					{Kind: "pr", ID: "666666", URL: "https://github.com/restic/restic/pull/666666", Text: "#666666"},
+				},
 				Title:     "Short and terse summary",
 				Type:      "Security",
 				TypeShort: "Sec",
+				TypeEmoji: "🔒",
 				Paragraphs: []string{
 					"A block of text. Lorem ipsum or so. May wrap around, arbitrarily.",
 					"Second block of text. may also contain many different lines.",
@@ -98,11 +135,18 @@ https://github.com/restic/rest-server/issues/232323
 https://github.com/restic/restic/pull/666666
 https://forum.restic.net/t/getting-last-successful-backup-time/531
 `,
-			Entry{
+			changelog.Entry{
+				References: []changelog.Reference{
+					{Kind: "issue", ID: "12345", URL: "https://github.com/restic/restic/issues/12345", Text: "#12345"},
+					{Kind: "issue", ID: "232323", URL: "https://github.com/restic/rest-server/issues/232323", Text: "#232323"},
+					{Kind: "pr", ID: "666666", URL: "https://github.com/restic/restic/pull/666666", Text: "#666666"},
+					{Kind: "other", URL: "https://forum.restic.net/t/getting-last-successful-backup-time/531", Text: "https://forum.restic.net/t/getting-last-successful-backup-time/531"},
+				},
 				Title:      "Foo bar subject",
 				Paragraphs: []string{"```bash\necho 'test code block with type'\n```"},
 				Type:       "Enhancement",
 				TypeShort:  "Enh",
+				TypeEmoji:  "✨",
 				PrimaryID:  12345,
 				PrimaryURL: parseURL(t, "https://github.com/restic/restic/issues/12345"),
 				Issues:     []string{"12345", "232323"},
@@ -127,10 +171,14 @@ https://forum.restic.net/t/getting-last-successful-backup-time/531
 		},
 		{
 			"Security: short and terse summary\n\n```\nexample\n   with\n       random spaces\n```\n\nLast block contains just\na few\nlinks.\n\nhttps://github.com/restic/restic/issues/12345",
-			Entry{
+			changelog.Entry{
+				References: []changelog.Reference{
+					{Kind: "issue", ID: "12345", URL: "https://github.com/restic/restic/issues/12345", Text: "#12345"},
+				},
 				Title:     "Short and terse summary",
 				Type:      "Security",
 				TypeShort: "Sec",
+				TypeEmoji: "🔒",
 				Paragraphs: []string{
 					"```\nexample\n   with\n       random spaces\n```",
 					"Last block contains just a few links.",
@@ -167,6 +215,7 @@ https://forum.restic.net/t/getting-last-successful-backup-time/531
 				t.Fatal(err)
 			}
 
+			test.Entry.File = f.Name()
 			entry := readFile(f.Name())
 			if diff := deep.Equal(test.Entry, entry); diff != nil {
 				t.Error(diff)
@@ -174,6 +223,87 @@ https://forum.restic.net/t/getting-last-successful-backup-time/531
 		})
 	}
 }
+
+func TestEntryTitleLength(t *testing.T) {
+	defer func() {
+		opts.MaxTitleLength = 80
+	}()
+
+	e := changelog.Entry{Type: "Bugfix", Title: strings.Repeat("x", 80), PrimaryID: 1}
+	if err := opts.Validate(e); err == nil {
+		t.Error("expected error for too long title")
+	}
+
+	opts.MaxTitleLength = 100
+	if err := opts.Validate(e); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	opts.MaxTitleLength = 0
+	e.Title = strings.Repeat("x", 200)
+	if err := opts.Validate(e); err != nil {
+		t.Errorf("unexpected error with disabled limit: %v", err)
+	}
+
+	opts.MaxTitleLength = 20
+	_, problems := fixTitle("Bugfix: Fix the crash on startup")
+	if diff := deep.Equal([]string{`title is too long (max 20 characters), shorten the text after "Bugfix: Fix the cras"`}, problems); diff != nil {
+		t.Error(diff)
+	}
+}
+
+// writeEntry writes data to a temporary entry file and returns its name.
+func writeEntry(t testing.TB, data string) string {
+	filename := filepath.Join(t.TempDir(), "issue-1234")
+	err := ioutil.WriteFile(filename, []byte(data), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestReadFileJira(t *testing.T) {
+	rules := opts.LinkRules
+	opts.JiraURL = "https://jira.example.com/"
+	opts.JiraProjects = []string{"PROJ", "OPS"}
+	opts.LinkRules = forgeRules(t)
+	defer func() {
+		opts.JiraURL = ""
+		opts.JiraProjects = nil
+		opts.LinkRules = rules
+	}()
+
+	e := readFile(writeEntry(t, "Bugfix: fix crash for CVE-2024-1234 (OPS-7)\n\nPROJ-1234\nhttps://jira.example.com/browse/PROJ-99\nOTHER-5\n"))
+
+	if diff := deep.Equal([]string{"PROJ-1234", "PROJ-99", "OPS-7"}, e.Issues); diff != nil {
+		t.Error(diff)
+	}
+
+	if e.PrimaryID != 1234 || e.PrimaryURL.String() != "https://jira.example.com/browse/PROJ-1234" {
+		t.Errorf("wrong primary ID %v %v", e.PrimaryID, e.PrimaryURL)
+	}
+
+	if len(e.OtherURLs) != 1 || e.OtherURLs[0].String() != "OTHER-5" {
+		t.Errorf("wrong other URLs %v", e.OtherURLs)
+	}
+}
+
+func TestReadFileJiraNoProjects(t *testing.T) {
+	rules := opts.LinkRules
+	opts.JiraURL = "https://jira.example.com/"
+	opts.LinkRules = forgeRules(t)
+	defer func() {
+		opts.JiraURL = ""
+		opts.LinkRules = rules
+	}()
+
+	e := readFile(writeEntry(t, "Bugfix: Use SHA-256 and AES-256 for UTF-8 names\n\nPROJ-1234\n"))
+
+	if diff := deep.Equal([]string{"PROJ-1234"}, e.Issues); diff != nil {
+		t.Error(diff)
+	}
+}
+
 func TestReadReleases(t *testing.T) {
 	type testData struct {
 		Date       *time.Time
@@ -184,8 +314,8 @@ func TestReadReleases(t *testing.T) {
 	releases := []testData{
 		{Date: nil, FolderName: "unreleased", Version: "unreleased"},
 		{Date: ptrTime(time.Date(2023, time.November, 12, 0, 0, 0, 0, time.UTC)), FolderName: "2.0.0-rc.1+build.12345_2023-11-12", Version: "2.0.0-rc.1+build.12345"},
-		{Date: ptrTime(time.Date(2023, time.November, 10, 0, 0, 0, 0, time.UTC)), FolderName: "0.0.1-rc.1_2023-11-10", Version: "0.0.1-rc.1"},
 		{Date: ptrTime(time.Date(2023, time.November, 10, 0, 0, 0, 0, time.UTC)), FolderName: "1.0.1_2023-11-10", Version: "1.0.1"},
+		{Date: ptrTime(time.Date(2023, time.November, 10, 0, 0, 0, 0, time.UTC)), FolderName: "0.0.1-rc.1_2023-11-10", Version: "0.0.1-rc.1"},
 		{Date: ptrTime(time.Date(2023, time.November, 9, 0, 0, 0, 0, time.UTC)), FolderName: "4.0.0_2023-11-09", Version: "4.0.0"},
 		{Date: ptrTime(time.Date(2023, time.November, 8, 0, 0, 0, 0, time.UTC)), FolderName: "1.0.2-alpha.10_2023-11-08", Version: "1.0.2-alpha.10"},
 		{Date: ptrTime(time.Date(2023, time.September, 7, 0, 0, 0, 0, time.UTC)), FolderName: "1.0.0_2023-09-07", Version: "1.0.0"},
@@ -209,29 +339,3081 @@ func TestReadReleases(t *testing.T) {
 	}
 }
 
-func TestWrapIndent(t *testing.T) {
+func TestReadReleasesCalVer(t *testing.T) {
+	opts.Versioning = "calver"
+	defer func() {
+		opts.Versioning = "semver"
+	}()
+
+	dir := t.TempDir()
+	for _, name := range []string{"2024.05_2024-05-02", "2024.06.1_2024-06-15", "unreleased", "2024.06.2_2024-06-15", "2023.12.10_2023-12-24"} {
+		err := os.Mkdir(filepath.Join(dir, name), 0750)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var versions []string
+	for _, rel := range readReleases(dir) {
+		versions = append(versions, rel.Version)
+	}
+
+	want := []string{"unreleased", "2024.06.2", "2024.06.1", "2024.05", "2023.12.10"}
+	if diff := deep.Equal(want, versions); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestReadReleaseMeta(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"1.0.0_2023-09-07/issue-1":     "Bugfix: Fix crash\n\nhttps://github.com/restic/restic/issues/1\n",
+		"big-sur-3/issue-2":            "Bugfix: Fix backup\n\nhttps://github.com/restic/restic/issues/2\n",
+		"big-sur-3/release.yml":        "version: Big Sur Update 3\ndate: 2023-11-20\n",
+		"build-4711/release.yml":       "version: \"4711\"\n",
+		"2.0.0_2024-01-01/issue-3":     "Bugfix: Fix restore\n\nhttps://github.com/restic/restic/issues/3\n",
+		"2.0.0_2024-01-01/release.yml": "version: 2.0.0 \"Final\"\ndate: 2024-01-02\n",
+	} {
+		err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(filepath.Join(dir, filepath.FromSlash(name)), []byte(data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var versions []string
+	for _, rel := range readReleases(dir) {
+		date := "none"
+		if rel.Date != nil {
+			date = rel.Date.Format("2006-01-02")
+		}
+		versions = append(versions, rel.Version+" "+date)
+	}
+
+	want := []string{"4711 none", "2.0.0 \"Final\" 2024-01-02", "Big Sur Update 3 2023-11-20", "1.0.0 2023-09-07"}
+	if diff := deep.Equal(want, versions); diff != nil {
+		t.Error(diff)
+	}
+
+	if list := files(filepath.Join(dir, "big-sur-3")); len(list) != 1 || filepath.Base(list[0]) != "issue-2" {
+		t.Errorf("wrong files %v", list)
+	}
+
+	for _, data := range []string{"date: 2023-11-20\n", "version: 1.0\ndate: 20.11.2023\n", "version: [\n"} {
+		err := ioutil.WriteFile(filepath.Join(dir, "big-sur-3", "release.yml"), []byte(data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = opts.ReadReleases(dir)
+		var e *changelog.ErrBadReleaseDir
+		if !errors.As(err, &e) {
+			t.Errorf("%q: wrong error %v", data, err)
+		}
+	}
+}
+
+func TestReadReleaseNotes(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"0.16.1_2023-10-24/issue-1":   "Bugfix: Fix crash\n\nhttps://github.com/restic/restic/issues/1\n",
+		"0.16.1_2023-10-24/notes.yml": "codename: Kingfisher\nsummary: This release was withdrawn because of a regression.\nyanked: true\n",
+		"0.16.0_2023-07-31/issue-2":   "Bugfix: Fix backup\n\nhttps://github.com/restic/restic/issues/2\n",
+	} {
+		err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(filepath.Join(dir, filepath.FromSlash(name)), []byte(data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	changes := buildChanges(readReleases(dir))
+	if len(changes) != 2 {
+		t.Fatalf("wrong number of versions: %v", len(changes))
+	}
+
+	want := VersionChanges{Version: "0.16.1", Codename: "Kingfisher", Summary: "This release was withdrawn because of a regression.", Yanked: true}
+	got := changes[0]
+	if got.Version != want.Version || got.Codename != want.Codename || got.Summary != want.Summary || got.Yanked != want.Yanked {
+		t.Errorf("wrong notes, want %+v, got %+v", want, got)
+	}
+	if len(got.Entries) != 1 {
+		t.Errorf("notes.yml read as entry: %v", got.Entries)
+	}
+
+	if got := changes[1]; got.Codename != "" || got.Summary != "" || got.Yanked {
+		t.Errorf("unexpected notes for %v: %+v", got.Version, got)
+	}
+
+	err := ioutil.WriteFile(filepath.Join(dir, "0.16.1_2023-10-24", "notes.yml"), []byte("yanked: maybe\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = opts.ReadReleases(dir)
+	var e *changelog.ErrBadReleaseDir
+	if !errors.As(err, &e) {
+		t.Errorf("wrong error %v", err)
+	}
+}
+
+func TestReadReleaseIntro(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"0.17.0_2024-06-15/issue-1":   "Bugfix: Fix crash\n\nhttps://github.com/restic/restic/issues/1\n",
+		"0.17.0_2024-06-15/_intro.md": "this release adds\nsupport for compression.\n\n```\nrestic backup --compression max\n```\n\nThanks to all contributors!\n",
+		"unreleased/issue-2":          "Bugfix: Fix backup\n\nhttps://github.com/restic/restic/issues/2\n",
+		"unreleased/_intro.md":        "Work in progress.\n",
+	} {
+		err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(filepath.Join(dir, filepath.FromSlash(name)), []byte(data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	changes := buildChanges(readReleases(dir))
+	if len(changes) != 2 {
+		t.Fatalf("wrong number of versions: %v", len(changes))
+	}
+
+	want := [][]string{
+		{"Work in progress."},
+		{"This release adds support for compression.", "```\nrestic backup --compression max\n```", "Thanks to all contributors!"},
+	}
+	for i, vc := range changes {
+		if diff := deep.Equal(want[i], vc.Intro); diff != nil {
+			t.Errorf("%v: %v", vc.Version, diff)
+		}
+		if len(vc.Entries) != 1 {
+			t.Errorf("%v: _intro.md read as entry: %v", vc.Version, vc.Entries)
+		}
+	}
+
+	err := ioutil.WriteFile(filepath.Join(dir, "unreleased", "_intro.md"), []byte("```\nunterminated\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = opts.ReadReleases(dir)
+	if err == nil {
+		t.Error("unterminated verbatim section not reported")
+	}
+}
+
+func TestReadReleasesBuckets(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"unreleased/issue-3":        "Bugfix: Fix restore\n\nhttps://github.com/restic/restic/issues/3\n",
+		"unreleased-0.16.x/issue-2": "Bugfix: Fix backup\n\nhttps://github.com/restic/restic/issues/2\n",
+		"unreleased-next/issue-4":   "Bugfix: Fix prune\n\nhttps://github.com/restic/restic/issues/4\n",
+		"0.16.0_2023-07-31/issue-1": "Bugfix: Fix crash\n\nhttps://github.com/restic/restic/issues/1\n",
+	} {
+		err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(filepath.Join(dir, filepath.FromSlash(name)), []byte(data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	defer func() {
+		opts.Unreleased = "unreleased"
+	}()
+
+	for _, test := range []struct {
+		bucket string
+		want   map[string][]int64
+	}{
+		{"unreleased", map[string][]int64{"unreleased": {3}, "0.16.0": {1}}},
+		{"0.16.x", map[string][]int64{"unreleased": {2}, "0.16.0": {1}}},
+		{"unreleased-0.16.x", map[string][]int64{"unreleased": {2}, "0.16.0": {1}}},
+	} {
+		opts.Unreleased = test.bucket
+
+		got := make(map[string][]int64)
+		for version, entries := range readEntries(readReleases(dir)) {
+			for _, e := range entries {
+				got[version] = append(got[version], e.PrimaryID)
+			}
+		}
+
+		if diff := deep.Equal(test.want, got); diff != nil {
+			t.Errorf("%v: %v", test.bucket, diff)
+		}
+	}
+}
+
+func TestReadReleasesFile(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"unreleased", "v2-beta-hotfix_2024-01-03", "v2-beta", "1.0.0_2023-09-07"} {
+		err := os.Mkdir(filepath.Join(dir, name), 0750)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	index := "# versions, newest first\nunreleased\nv2-beta-hotfix 2024-01-03\n\nv2-beta 2024-01-01 Silver Fox\n1.1.0 2023-12-01\n1.0.0 2023-09-07 Copper\n"
+	err := ioutil.WriteFile(filepath.Join(dir, "releases"), []byte(index), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var versions, paths, codenames []string
+	for _, rel := range readReleases(dir) {
+		versions = append(versions, rel.Version)
+		paths = append(paths, filepath.Base(rel.Path))
+		codenames = append(codenames, rel.Codename)
+	}
+
+	if diff := deep.Equal([]string{"unreleased", "v2-beta-hotfix", "v2-beta", "1.1.0", "1.0.0"}, versions); diff != nil {
+		t.Error(diff)
+	}
+
+	// 1.1.0 has no directory and no entries
+	if diff := deep.Equal([]string{"unreleased", "v2-beta-hotfix_2024-01-03", "v2-beta", filepath.Base(dir), "1.0.0_2023-09-07"}, paths); diff != nil {
+		t.Error(diff)
+	}
+
+	if diff := deep.Equal([]string{"", "", "Silver Fox", "", "Copper"}, codenames); diff != nil {
+		t.Error(diff)
+	}
+
+	if entries := readEntries(readReleases(dir)); len(entries["1.1.0"]) != 0 {
+		t.Errorf("unexpected entries for 1.1.0: %v", entries["1.1.0"])
+	}
+}
+
+func TestReadReleasesFileUnlistedUnreleased(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"unreleased", "1.0.0_2023-09-07"} {
+		err := os.Mkdir(filepath.Join(dir, name), 0750)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := ioutil.WriteFile(filepath.Join(dir, "releases"), []byte("1.0.0 2023-09-07\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var versions []string
+	for _, rel := range readReleases(dir) {
+		versions = append(versions, rel.Version)
+	}
+
+	if diff := deep.Equal([]string{"unreleased", "1.0.0"}, versions); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestAddToReleasesFile(t *testing.T) {
 	var tests = []struct {
-		In     string
-		Width  int
-		Indent int
-		Out    string
+		before, after string
 	}{
-		{"Example string", 80, 4, "Example string"},
-		{"Lorem ipsum dolor sit amet, consectetur adipiscing elit, sed do eiusmod tempor incididunt ut labore et dolore magna aliqua.", 70, 3, "Lorem ipsum dolor sit amet, consectetur adipiscing elit, sed do\n   eiusmod tempor incididunt ut labore et dolore magna aliqua."},
-		{"Lorem ipsum dolor sit amet, consectetur adipiscing elit, sed do eiusmod tempor incididunt ut labore et dolore magna aliqua.", 55, 2, "Lorem ipsum dolor sit amet, consectetur adipiscing\n  elit, sed do eiusmod tempor incididunt ut labore et\n  dolore magna aliqua."},
-		{"```\nexample\n   with\n       random spaces\n```", 10, 3, "```\n   example\n      with\n          random spaces\n   ```"},
+		{
+			"# versions\nunreleased\n1.0.0 2023-09-07\n",
+			"# versions\nunreleased\n1.1.0 2024-01-02\n1.0.0 2023-09-07\n",
+		},
+		{
+			"1.0.0 2023-09-07 Copper\n",
+			"1.1.0 2024-01-02\n1.0.0 2023-09-07 Copper\n",
+		},
+		{
+			"unreleased",
+			"unreleased\n1.1.0 2024-01-02\n",
+		},
 	}
 
 	for _, test := range tests {
-		t.Run("", func(t *testing.T) {
-			res, err := wrapIndent(test.In, test.Width, test.Indent)
-			if err != nil {
-				t.Fatal(err)
-			}
+		dir := t.TempDir()
+		err := ioutil.WriteFile(filepath.Join(dir, "releases"), []byte(test.before), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
 
-			if diff := deep.Equal(res, test.Out); diff != nil {
-				t.Error(diff)
-			}
-		})
+		err = addToReleasesFile(dir, "1.1.0", "2024-01-02")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		buf, err := ioutil.ReadFile(filepath.Join(dir, "releases"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(buf) != test.after {
+			t.Errorf("%q: want %q, got %q", test.before, test.after, buf)
+		}
+	}
+
+	// without a releases file, nothing is written
+	dir := t.TempDir()
+	err := addToReleasesFile(dir, "1.1.0", "2024-01-02")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "releases")); !os.IsNotExist(err) {
+		t.Errorf("releases file created: %v", err)
+	}
+}
+
+// gitRepo creates a git repository in a temporary directory and returns the
+// directory, a function to run git commands and a function to write entries.
+func gitRepo(t *testing.T) (dir string, run func(...string), write func(string)) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	dir = t.TempDir()
+	run = func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	write = func(name string) {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte("Bugfix: "+filepath.Base(name)+"\n\nhttps://github.com/restic/restic/issues/1\n"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run("init", "-q")
+	return dir, run, write
+}
+
+func TestFlatFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"issue-1", "config.yml", "deferred.log", "history.lock"} {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte("Bugfix: Fix crash\n\nhttps://github.com/restic/restic/issues/1\n"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	defer func() {
+		loadedConfigs = nil
+	}()
+	loadedConfigs = []string{filepath.Join(dir, "config.yml")}
+
+	list, err := flatFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, file := range list {
+		names = append(names, filepath.Base(file))
+	}
+
+	if diff := deep.Equal([]string{"issue-1"}, names); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestReadReleasesGit(t *testing.T) {
+	dir, run, write := gitRepo(t)
+
+	write("issue-1")
+	run("add", "issue-1")
+	run("commit", "-q", "-m", "add issue-1")
+	run("tag", "-a", "-m", "v0.1.0", "v0.1.0")
+	run("tag", "lightweight")
+	write("issue-2")
+	run("add", "issue-2")
+	run("commit", "-q", "-m", "add issue-2")
+	run("tag", "-a", "-m", "v0.2.0", "v0.2.0")
+	write("issue-3")
+
+	releases, err := readReleasesGit(dir, "v*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var versions []string
+	files := make(map[string][]string)
+	for _, rel := range releases {
+		versions = append(versions, rel.Version)
+		for _, file := range rel.Files {
+			files[rel.Version] = append(files[rel.Version], filepath.Base(file))
+		}
+	}
+
+	// both tags have the same date, so only check the set of versions
+	sort.Strings(versions[1:])
+	if diff := deep.Equal([]string{"unreleased", "0.1.0", "0.2.0"}, versions); diff != nil {
+		t.Error(diff)
+	}
+
+	want := map[string][]string{
+		"unreleased": {"issue-3"},
+		"0.1.0":      {"issue-1"},
+		"0.2.0":      {"issue-2"},
+	}
+	if diff := deep.Equal(want, files); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestAssignByHistory(t *testing.T) {
+	dir, run, write := gitRepo(t)
+
+	for _, name := range []string{"unreleased", "1.0.0_2023-01-01"} {
+		err := os.Mkdir(filepath.Join(dir, name), 0750)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(filepath.Join("1.0.0_2023-01-01", "issue-1"))
+	write("issue-2")
+	run("add", ".")
+	run("commit", "-q", "-m", "add entries")
+	run("tag", "-a", "-m", "v1.0.0", "v1.0.0")
+	write("issue-3")
+
+	opts.Assign = assignReleasesByHistory
+	defer func() {
+		opts.Assign = nil
+	}()
+
+	files := make(map[string][]string)
+	for _, rel := range readReleases(dir) {
+		for _, file := range rel.Files {
+			files[rel.Version] = append(files[rel.Version], filepath.Base(file))
+		}
+	}
+
+	want := map[string][]string{
+		"unreleased": {"issue-3"},
+		"1.0.0":      {"issue-1", "issue-2"},
+	}
+	if diff := deep.Equal(want, files); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestProvenance(t *testing.T) {
+	dir, run, write := gitRepo(t)
+
+	write("issue-1")
+	run("add", "issue-1")
+	run("commit", "-q", "-m", "Add entry for issue 1 (#42)")
+	write("issue-2")
+
+	rec := provenance(readFile(filepath.Join(dir, "issue-1")))
+	if rec.Commit == "" || rec.Author != "test" || rec.PullRequest != "42" {
+		t.Errorf("wrong provenance record %+v", rec)
+	}
+
+	rec = provenance(readFile(filepath.Join(dir, "issue-2")))
+	if rec.Commit != "" || rec.File != filepath.ToSlash(filepath.Join(dir, "issue-2")) {
+		t.Errorf("wrong provenance record for uncommitted file %+v", rec)
+	}
+}
+
+func TestProvenanceMoved(t *testing.T) {
+	dir, run, write := gitRepo(t)
+
+	err := os.Mkdir(filepath.Join(dir, "unreleased"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write("unreleased/issue-1")
+	run("add", "unreleased/issue-1")
+	run("commit", "-q", "-m", "Add entry for issue 1 (#42)")
+	out, err := git(dir, "rev-parse", "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := strings.TrimSpace(out)
+
+	// the release moves the entry into the release directory
+	run("mv", "unreleased", "1.0.0_2024-01-02")
+	run("commit", "-q", "-m", "Prepare release 1.0.0")
+
+	rec := provenance(readFile(filepath.Join(dir, "1.0.0_2024-01-02", "issue-1")))
+	if rec.Commit != first || rec.PullRequest != "42" {
+		t.Errorf("wrong provenance record for moved entry %+v, want commit %v", rec, first)
+	}
+}
+
+func TestMergeDuplicates(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"1.0.1_2023-11-10", "1.0.1_2023-11-12", "1.0.0_2023-11-01"} {
+		err := os.Mkdir(filepath.Join(dir, name), 0750)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = ioutil.WriteFile(filepath.Join(dir, name, "issue-"+name), []byte("Bugfix: foo\n\nhttps://github.com/restic/restic/issues/1\n"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	opts.MergeDuplicates = true
+	defer func() {
+		opts.MergeDuplicates = false
+	}()
+
+	releases := readReleases(dir)
+	if len(releases) != 2 {
+		t.Fatalf("wrong number of releases: %v", releases)
+	}
+
+	rel := releases[0]
+	if rel.Version != "1.0.1" || rel.Date.Format("2006-01-02") != "2023-11-12" {
+		t.Errorf("wrong merged release %v %v", rel.Version, rel.Date)
+	}
+
+	var names []string
+	for _, file := range rel.Files {
+		names = append(names, filepath.Base(file))
+	}
+
+	if diff := deep.Equal([]string{"issue-1.0.1_2023-11-10", "issue-1.0.1_2023-11-12"}, names); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestBuildChangesIncludeEmpty(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"unreleased", "1.0.1_2023-11-10", "1.0.0_2023-11-01"} {
+		err := os.Mkdir(filepath.Join(dir, name), 0750)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := ioutil.WriteFile(filepath.Join(dir, "1.0.0_2023-11-01", "issue-1"), []byte("Bugfix: foo\n\nhttps://github.com/restic/restic/issues/1\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if changes := buildChanges(readReleases(dir)); len(changes) != 1 {
+		t.Errorf("empty releases were not skipped: %v", changes)
+	}
+
+	opts.IncludeEmpty = true
+	defer func() {
+		opts.IncludeEmpty = false
+	}()
+
+	changes := buildChanges(readReleases(dir))
+	if len(changes) != 2 {
+		t.Fatalf("wrong number of releases: %v", changes)
+	}
+
+	if changes[0].Version != "1.0.1" || changes[0].Placeholder != opts.EmptyPlaceholder {
+		t.Errorf("wrong placeholder for empty release %v", changes[0])
+	}
+
+	if changes[1].Placeholder != "" {
+		t.Errorf("placeholder set for release with entries %v", changes[1])
+	}
+}
+
+func TestWarnSkipped(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"unreleased", "unreleased-next", ".git"} {
+		err := os.Mkdir(filepath.Join(dir, name), 0750)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{"CHANGELOG.tmpl", "history.lock", "deferred.log"} {
+		err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stderr := os.Stderr
+	os.Stderr = w
+	opts.WarnSkipped = true
+	opts.WarnFormat = "json"
+	defer func() {
+		os.Stderr = stderr
+		opts.WarnSkipped = false
+		opts.WarnFormat = "text"
+	}()
+
+	readReleases(dir)
+
+	os.Stderr = stderr
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var reasons []string
+	for _, line := range strings.Split(strings.TrimSpace(string(buf)), "\n") {
+		var w SkipWarning
+		err := json.Unmarshal([]byte(line), &w)
+		if err != nil {
+			t.Fatalf("invalid warning %q: %v", line, err)
+		}
+		reasons = append(reasons, filepath.Base(w.Path)+": "+w.Reason)
+	}
+	sort.Strings(reasons)
+
+	want := []string{
+		".git: hidden directory",
+		"CHANGELOG.tmpl: not a directory",
+		"unreleased-next: entries deferred to a later release",
+	}
+	if diff := deep.Equal(want, reasons); diff != nil {
+		t.Error(diff)
+	}
+}
+
+// evenScheme accepts even numbers only, ordered from high to low.
+type evenScheme struct{}
+
+func (evenScheme) Parse(s string) (string, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n%2 != 0 {
+		return "", fmt.Errorf("%q is not even", s)
+	}
+	return s, nil
+}
+
+func (evenScheme) Compare(a, b string) int {
+	x, _ := strconv.Atoi(a)
+	y, _ := strconv.Atoi(b)
+	return y - x
+}
+
+func TestSemverScheme(t *testing.T) {
+	var tests = []struct {
+		dir, version string
+	}{
+		{"1.2.3_2024-01-01", "1.2.3"},
+		{"v1.2.3_2024-01-01", "1.2.3"},
+		{"1.2_2024-01-01", "1.2.0"},
+		{"v1.2", "1.2.0"},
+		{"1.2.3.4_2024-01-01", "1.2.3.4"},
+		{"v1.02.3.04", "1.2.3.4"},
+		{"1.0.0-rc.1_2024-01-01", "1.0.0-rc.1"},
+	}
+
+	for _, test := range tests {
+		rel, err := opts.ParseReleaseDir(filepath.Join("changelog", test.dir))
+		if err != nil {
+			t.Errorf("%v: %v", test.dir, err)
+			continue
+		}
+		if rel.Version != test.version {
+			t.Errorf("%v: want version %v, got %v", test.dir, test.version, rel.Version)
+		}
+	}
+
+	defer func() {
+		opts.Versions = nil
+	}()
+	opts.Versions = []string{"v1.2"}
+
+	rel, err := opts.ParseReleaseDir(filepath.Join("changelog", "1.2_2024-01-01"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if selected := selectReleases([]changelog.Release{rel}); len(selected) != 1 {
+		t.Errorf("release not selected with --version v1.2")
+	}
+}
+
+func TestReleaseSliceSort(t *testing.T) {
+	date := func(s string) *time.Time {
+		d, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &d
+	}
+
+	semver, err := changelog.LookupVersionScheme("semver")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list := changelog.ReleaseSlice{
+		{Version: "1.2.9", Date: date("2024-01-01"), Scheme: semver},
+		{Version: "1.10.0", Date: date("2024-01-01"), Scheme: semver},
+		{Version: "0.9.0", Scheme: semver},
+		{Version: "2.0.0", Date: date("2023-12-01"), Scheme: semver},
+		{Version: "unreleased"},
+		{Version: "0.10.0", Scheme: semver},
+		{Version: "1.1.0", Date: date("2024-01-01"), Scheme: semver},
+	}
+	sort.Sort(list)
+
+	want := []string{"unreleased", "0.10.0", "0.9.0", "1.10.0", "1.2.9", "1.1.0", "2.0.0"}
+	var got []string
+	for _, rel := range list {
+		got = append(got, rel.Version)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong order, want %v, got %v", want, got)
+	}
+}
+
+func TestMisorderedReleases(t *testing.T) {
+	var tests = []struct {
+		dirs []string
+		want []string
+	}{
+		{
+			dirs: []string{"1.2.0_2024-03-01", "1.1.0_2024-02-01", "1.1.1_2024-02-01", "1.0.0_2024-01-01"},
+		},
+		{
+			// mistyped version
+			dirs: []string{"1.2.0_2024-03-01", "11.0.0_2024-02-01", "1.0.0_2024-01-01"},
+			want: []string{"11.0.0 1.2.0"},
+		},
+		{
+			// mistyped date
+			dirs: []string{"1.2.0_2024-03-01", "1.1.0_2024-02-01", "1.3.0_2023-01-01"},
+			want: []string{"1.3.0 1.2.0"},
+		},
+	}
+
+	for _, test := range tests {
+		var list changelog.ReleaseSlice
+		for _, dir := range test.dirs {
+			rel, err := opts.ParseReleaseDir(filepath.Join("changelog", dir))
+			if err != nil {
+				t.Fatal(err)
+			}
+			list = append(list, rel)
+		}
+		sort.Sort(list)
+
+		var got []string
+		for _, rel := range misorderedReleases(list) {
+			got = append(got, rel.Version+" "+rel.Later.Version)
+		}
+
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: want %v, got %v", test.dirs, test.want, got)
+		}
+	}
+}
+
+func TestCollapsePrereleases(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"1.3.0_2024-03-01/issue-3":      "Bugfix: Fix restore\n\nhttps://github.com/restic/restic/issues/3\n",
+		"1.3.0-rc.2_2024-02-15/issue-2": "Bugfix: Fix backup\n\nhttps://github.com/restic/restic/issues/2\n",
+		"1.3.0-rc.1_2024-02-01/issue-1": "Bugfix: Fix crash\n\nhttps://github.com/restic/restic/issues/1\n",
+		"1.4.0-rc.1_2024-04-01/issue-4": "Bugfix: Fix prune\n\nhttps://github.com/restic/restic/issues/4\n",
+	} {
+		err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(filepath.Join(dir, filepath.FromSlash(name)), []byte(data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	defer func() {
+		opts.CollapsePrereleases = false
+		opts.KeepPrereleases = false
+	}()
+
+	var tests = []struct {
+		keep bool
+		want map[string][]int64
+	}{
+		{
+			want: map[string][]int64{
+				"1.4.0-rc.1": {4},
+				"1.3.0":      {1, 2, 3},
+			},
+		},
+		{
+			keep: true,
+			want: map[string][]int64{
+				"1.4.0-rc.1": {4},
+				"1.3.0":      {1, 2, 3},
+				"1.3.0-rc.2": {2},
+				"1.3.0-rc.1": {1},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		opts.CollapsePrereleases = true
+		opts.KeepPrereleases = test.keep
+
+		got := make(map[string][]int64)
+		for version, entries := range readEntries(selectReleases(readReleases(dir))) {
+			for _, e := range entries {
+				got[version] = append(got[version], e.PrimaryID)
+			}
+		}
+
+		if diff := deep.Equal(test.want, got); diff != nil {
+			t.Errorf("keep %v: %v", test.keep, diff)
+		}
+	}
+}
+
+func TestSelectReleasesLatest(t *testing.T) {
+	var releases []changelog.Release
+	for _, dir := range []string{"unreleased", "1.2.0_2024-03-01", "1.1.0_2024-02-01"} {
+		rel := changelog.Release{Version: "unreleased"}
+		if dir != "unreleased" {
+			var err error
+			rel, err = opts.ParseReleaseDir(filepath.Join("changelog", dir))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		releases = append(releases, rel)
+	}
+
+	defer func() {
+		opts.Latest = false
+	}()
+	opts.Latest = true
+
+	selected := selectReleases(releases)
+	if len(selected) != 1 || selected[0].Version != "1.2.0" {
+		t.Errorf("wrong releases selected: %v", selected)
+	}
+
+	if selected := selectReleases(releases[:1]); len(selected) != 0 {
+		t.Errorf("releases selected without release: %v", selected)
+	}
+}
+
+func TestSelectReleasesSince(t *testing.T) {
+	var releases []changelog.Release
+	for _, dir := range []string{"unreleased", "0.16.0_2024-03-01", "0.14.3_2024-02-15", "0.15.1_2024-02-01", "0.15.0_2024-01-01", "0.14.2_2023-12-01"} {
+		rel := changelog.Release{Version: "unreleased"}
+		if dir != "unreleased" {
+			var err error
+			rel, err = opts.ParseReleaseDir(filepath.Join("changelog", dir))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		releases = append(releases, rel)
+	}
+
+	var got []string
+	for _, rel := range sinceReleases(releases, "v0.15") {
+		got = append(got, rel.Version)
+	}
+
+	// the backport 0.14.3 was released later, but is not newer
+	want := []string{"unreleased", "0.16.0", "0.15.1"}
+	if diff := deep.Equal(want, got); diff != nil {
+		t.Error(diff)
+	}
+
+	// versions used verbatim are compared by position
+	verbatim := []changelog.Release{{Version: "unreleased"}, {Version: "v2-beta"}, {Version: "v1-final"}, {Version: "v1-rc"}}
+	got = nil
+	for _, rel := range sinceReleases(verbatim, "v1-final") {
+		got = append(got, rel.Version)
+	}
+
+	want = []string{"unreleased", "v2-beta"}
+	if diff := deep.Equal(want, got); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestSelectReleasesDateRange(t *testing.T) {
+	var releases []changelog.Release
+	for _, dir := range []string{"unreleased", "1.3.0_2024-01-02", "1.2.0_2023-12-31", "1.1.0_2023-06-01", "1.0.0_2023-01-01", "0.9.0_2022-12-31"} {
+		rel := changelog.Release{Version: "unreleased"}
+		if dir != "unreleased" {
+			var err error
+			rel, err = opts.ParseReleaseDir(filepath.Join("changelog", dir))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		releases = append(releases, rel)
+	}
+
+	defer func() {
+		dateFrom, dateTo = time.Time{}, time.Time{}
+	}()
+
+	var tests = []struct {
+		from, to string
+		want     []string
+	}{
+		{"2023-01-01", "2023-12-31", []string{"1.2.0", "1.1.0", "1.0.0"}},
+		{"2023-06-01", "", []string{"1.3.0", "1.2.0", "1.1.0"}},
+		{"", "2022-12-31", []string{"0.9.0"}},
+		{"2025-01-01", "", nil},
+	}
+
+	for _, test := range tests {
+		dateFrom, dateTo = time.Time{}, time.Time{}
+		if test.from != "" {
+			dateFrom, _ = time.Parse("2006-01-02", test.from)
+		}
+		if test.to != "" {
+			dateTo, _ = time.Parse("2006-01-02", test.to)
+		}
+
+		var got []string
+		for _, rel := range selectReleases(releases) {
+			got = append(got, rel.Version)
+		}
+
+		if diff := deep.Equal(test.want, got); diff != nil {
+			t.Errorf("%v..%v: %v", test.from, test.to, diff)
+		}
+	}
+}
+
+func TestSelectReleasesUnreleasedOnly(t *testing.T) {
+	releases := []changelog.Release{{Version: "unreleased"}, {Version: "1.2.0"}, {Version: "1.1.0"}}
+
+	defer func() {
+		opts.UnreleasedOnly = false
+	}()
+	opts.UnreleasedOnly = true
+
+	selected := selectReleases(releases)
+	if len(selected) != 1 || selected[0].Version != "unreleased" {
+		t.Errorf("wrong releases selected: %v", selected)
+	}
+}
+
+func TestVersionScheme(t *testing.T) {
+	defer func() {
+		opts.Versioning = "semver"
+		changelog.RegisterVersionScheme("even", nil)
+	}()
+
+	opts.Versioning = "ordinal"
+	rel, err := opts.ParseVersion("007")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rel.Version != "7" {
+		t.Errorf("wrong version %v", rel.Version)
+	}
+	if _, err := opts.ParseVersion("1.2"); err == nil {
+		t.Error("expected error for invalid release number")
+	}
+
+	opts.Versioning = "even"
+	if _, err := opts.ParseVersion("2"); err == nil {
+		t.Error("expected error for unknown scheme")
+	}
+
+	changelog.RegisterVersionScheme("even", evenScheme{})
+
+	dir := t.TempDir()
+	for _, name := range []string{"2_2024-01-01", "4_2024-01-01", "8_2024-01-01"} {
+		err := os.Mkdir(filepath.Join(dir, name), 0750)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var versions []string
+	for _, rel := range readReleases(dir) {
+		versions = append(versions, rel.Version)
+	}
+
+	if diff := deep.Equal([]string{"2", "4", "8"}, versions); diff != nil {
+		t.Error(diff)
+	}
+
+	if _, err := opts.ParseVersion("3"); err == nil {
+		t.Error("expected error for odd version")
+	}
+}
+
+func TestMarker(t *testing.T) {
+	defer func(format string) {
+		opts.MarkerFormat = format
+	}(opts.MarkerFormat)
+
+	if m := marker("start", "0.17.0"); m != "<!-- calens:start:0.17.0 -->" {
+		t.Errorf("wrong default marker %q", m)
+	}
+
+	opts.MarkerFormat = ".. {kind} of {version}"
+	if m := marker("end", "0.17.0"); m != ".. end of 0.17.0" {
+		t.Errorf("wrong marker %q", m)
+	}
+}
+
+func TestNewMeta(t *testing.T) {
+	err := os.Setenv("SOURCE_DATE_EPOCH", "1719792000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.Unsetenv("SOURCE_DATE_EPOCH")
+		opts.Set = nil
+	}()
+
+	opts.Set = []string{"tagline=fast, secure, efficient", "empty="}
+	m, err := newMeta()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !m.Generated.Equal(time.Date(2024, time.July, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("wrong generation time %v", m.Generated)
+	}
+
+	want := map[string]string{"tagline": "fast, secure, efficient", "empty": ""}
+	if diff := deep.Equal(want, m.Vars); diff != nil {
+		t.Error(diff)
+	}
+
+	opts.Set = []string{"novalue"}
+	if _, err := newMeta(); err == nil {
+		t.Error("expected error for invalid variable")
+	}
+}
+
+func TestMilestoneIssues(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/repos/restic/restic/milestones":
+			_, _ = w.Write([]byte(`[{"number": 3, "title": "v0.16.0"}, {"number": 4, "title": "v0.17.0"}]`))
+		case r.URL.Path == "/repos/restic/restic/issues" && r.URL.Query().Get("milestone") == "4" && r.URL.Query().Get("page") == "1":
+			_, _ = w.Write([]byte(`[{"number": 12, "title": "foo"}, {"number": 13, "title": "bar", "pull_request": {}}]`))
+		default:
+			_, _ = w.Write([]byte(`[]`))
+		}
+	}))
+	defer srv.Close()
+
+	defer func(api string) {
+		githubAPI = api
+	}(githubAPI)
+	githubAPI = srv.URL
+
+	issues, err := milestoneIssues("restic/restic", "v0.17.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(issues) != 2 || issues[0].Number != 12 || issues[1].PullRequest == nil {
+		t.Errorf("wrong issues returned: %v", issues)
+	}
+
+	if _, err := milestoneIssues("restic/restic", "v1.0.0"); err == nil {
+		t.Error("expected error for unknown milestone")
+	}
+
+	if ref := githubRef(parseURL(t, "https://github.com/Restic/restic/pull/13")); ref != "restic/restic#13" {
+		t.Errorf("wrong reference %q", ref)
+	}
+}
+
+func TestUnreleasedReferences(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"unreleased/issue-12":       "Bugfix: Fix crash\n\nhttps://github.com/restic/restic/issues/12\n",
+		"unreleased/draft-pull-13":  "Bugfix: Fix restore\n\nhttps://github.com/restic/restic/pull/13\n",
+		"0.16.0_2023-07-31/issue-1": "Bugfix: Fix backup\n\nhttps://github.com/restic/restic/issues/1\n",
+	})
+
+	want := map[string]struct{}{"restic/restic#12": {}}
+	if diff := deep.Equal(want, unreleasedReferences(dir)); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestChecksumFor(t *testing.T) {
+	sums := []byte("0123abcd  calens_0.4.0_linux_amd64.bz2\n4567ef01 *calens_0.4.0_darwin_arm64.bz2\n")
+
+	for name, want := range map[string]string{
+		"calens_0.4.0_linux_amd64.bz2":  "0123abcd",
+		"calens_0.4.0_darwin_arm64.bz2": "4567ef01",
+	} {
+		sum, ok := checksumFor(sums, name)
+		if !ok || sum != want {
+			t.Errorf("wrong checksum for %v: %v %v", name, sum, ok)
+		}
+	}
+
+	if _, ok := checksumFor(sums, "calens_0.4.0_windows_amd64.bz2"); ok {
+		t.Error("found checksum for missing file")
+	}
+}
+
+func TestSignedBy(t *testing.T) {
+	const key = "CF8F18F2844575973F79D4E191A6868BD3F7A907"
+
+	var tests = []struct {
+		Status string
+		Valid  bool
+	}{
+		{"[GNUPG:] NEWSIG\n[GNUPG:] GOODSIG 91A6868BD3F7A907 Alexander Neumann\n[GNUPG:] VALIDSIG CF8F18F2844575973F79D4E191A6868BD3F7A907 2024-01-02 1704200000 0 4 0 1 10 00 CF8F18F2844575973F79D4E191A6868BD3F7A907\n", true},
+		{"[GNUPG:] VALIDSIG 1111222233334444555566667777888899990000 2024-01-02 1704200000 0 4 0 1 10 00 CF8F18F2844575973F79D4E191A6868BD3F7A907\n", true},
+		{"[GNUPG:] VALIDSIG 1111222233334444555566667777888899990000 2024-01-02 1704200000 0 4 0 1 10 00 1111222233334444555566667777888899990000\n", false},
+		{"[GNUPG:] BADSIG 91A6868BD3F7A907 Alexander Neumann\n", false},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		if signedBy([]byte(test.Status), key) != test.Valid {
+			t.Errorf("%q: want %v", test.Status, test.Valid)
+		}
+	}
+}
+
+func TestFixTitle(t *testing.T) {
+	var tests = []struct {
+		Line     string
+		Fixed    string
+		Problems int
+	}{
+		{"Bugfix: Restore old behavior", "Bugfix: Restore old behavior", 0},
+		{"bugfix: Restore old behavior.", "Bugfix: Restore old behavior", 2},
+		{"Enhancement: Support foo!?", "Enhancement: Support foo", 1},
+		{"Change: " + strings.Repeat("x", 80), "Change: " + strings.Repeat("x", 80), 1},
+	}
+
+	for _, test := range tests {
+		t.Run("", func(t *testing.T) {
+			fixed, problems := fixTitle(test.Line)
+			if fixed != test.Fixed {
+				t.Errorf("wrong fixed title, want %q, got %q", test.Fixed, fixed)
+			}
+
+			if len(problems) != test.Problems {
+				t.Errorf("wrong number of problems, want %d, got %v", test.Problems, problems)
+			}
+		})
+	}
+}
+
+// writeTree creates the files in dir, the keys of files are slash-separated
+// paths relative to dir.
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	for name, data := range files {
+		err := os.MkdirAll(filepath.Join(dir, filepath.Dir(filepath.FromSlash(name))), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(filepath.Join(dir, filepath.FromSlash(name)), []byte(data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRunRelease(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"unreleased/issue-1":       "Bugfix: Fix crash\n\nhttps://github.com/restic/restic/issues/1\n",
+		"unreleased/draft-issue-2": "Bugfix: Fix backup\n\nhttps://github.com/restic/restic/issues/2\n",
+		"0.9.0_2023-01-02/issue-3": "Bugfix: Fix restore\n\nhttps://github.com/restic/restic/issues/3\n",
+	})
+
+	defer func(input string) {
+		opts.InputDir = input
+	}(opts.InputDir)
+	opts.InputDir = dir
+
+	runRelease([]string{"v1.0"})
+
+	dst := filepath.Join(dir, "1.0.0_"+now().Format("2006-01-02"))
+	if diff := deep.Equal([]string{filepath.Join(dst, "issue-1")}, files(dst)); diff != nil {
+		t.Errorf("release dir: %v", diff)
+	}
+
+	src := filepath.Join(dir, "unreleased")
+	if diff := deep.Equal([]string{filepath.Join(src, "draft-issue-2")}, files(src)); diff != nil {
+		t.Errorf("unreleased dir: %v", diff)
+	}
+
+	var versions []string
+	for _, rel := range readReleases(dir) {
+		versions = append(versions, rel.Version)
+	}
+	if diff := deep.Equal([]string{"unreleased", "1.0.0", "0.9.0"}, versions); diff != nil {
+		t.Errorf("releases: %v", diff)
+	}
+}
+
+func TestReviewEntries(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"unreleased/issue-1": "Bugfix: Fix crash\n\nhttps://github.com/restic/restic/issues/1\n",
+		"unreleased/issue-2": "Bugfix: Fix backup\n\nhttps://github.com/restic/restic/issues/2\n",
+	})
+
+	defer func(input string) {
+		opts.InputDir = input
+	}(opts.InputDir)
+	opts.InputDir = dir
+
+	// retype and accept the first entry, defer the second one
+	answers := "r\nfoo\nr\nenhancement\na\nd\n"
+	if !reviewEntries(filepath.Join(dir, "unreleased"), bufio.NewReader(strings.NewReader(answers))) {
+		t.Fatal("review aborted")
+	}
+
+	buf, err := ioutil.ReadFile(filepath.Join(dir, "unreleased", "issue-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Enhancement: Fix crash\n\nhttps://github.com/restic/restic/issues/1\n"; string(buf) != want {
+		t.Errorf("wrong retyped entry, want %q, got %q", want, buf)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "unreleased-next", "issue-2")); err != nil {
+		t.Errorf("entry not deferred: %v", err)
+	}
+
+	if reviewEntries(filepath.Join(dir, "unreleased"), bufio.NewReader(strings.NewReader("q\n"))) {
+		t.Error("review not aborted")
+	}
+}
+
+func TestRunDefer(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"unreleased/issue-1": "Bugfix: Fix crash\n\nhttps://github.com/restic/restic/issues/1\n",
+	})
+
+	defer func(input, to string) {
+		opts.InputDir, opts.DeferTo = input, to
+	}(opts.InputDir, opts.DeferTo)
+	opts.InputDir = dir
+	opts.DeferTo = "next-major"
+
+	src := filepath.Join(dir, "unreleased", "issue-1")
+	dst := filepath.Join(dir, "unreleased-next-major", "issue-1")
+	runDefer([]string{src})
+
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Errorf("entry not moved: %v", err)
+	}
+	if _, err := os.Stat(dst); err != nil {
+		t.Errorf("entry not found in bucket: %v", err)
+	}
+
+	// move it back to the next release
+	opts.DeferTo = "unreleased"
+	runDefer([]string{dst})
+
+	buf, err := ioutil.ReadFile(filepath.Join(dir, "deferred.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	date := now().Format("2006-01-02")
+	want := fmt.Sprintf("%v %v -> %v\n%v %v -> %v\n",
+		date, filepath.ToSlash(src), filepath.ToSlash(dst),
+		date, filepath.ToSlash(dst), filepath.ToSlash(src))
+	if string(buf) != want {
+		t.Errorf("wrong defer log, want %q, got %q", want, buf)
+	}
+}
+
+func TestRunBadge(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"unreleased/issue-2":       "Bugfix: Fix backup\n\nhttps://github.com/restic/restic/issues/2\n",
+		"unreleased/issue-3":       "Bugfix: Fix restore\n\nhttps://github.com/restic/restic/issues/3\n",
+		"1.0.0_2023-09-07/issue-1": "Bugfix: Fix crash\n\nhttps://github.com/restic/restic/issues/1\n",
+	})
+
+	defer func(input, output string) {
+		opts.InputDir, opts.Output = input, output
+	}(opts.InputDir, opts.Output)
+	opts.InputDir = dir
+	opts.Output = filepath.Join(t.TempDir(), "badge.json")
+
+	var tests = []struct {
+		args []string
+		want string
+	}{
+		{nil, `{
+  "schemaVersion": 1,
+  "label": "next release",
+  "message": "2 changes pending",
+  "color": "orange"
+}
+`},
+		{[]string{"latest"}, `{
+  "schemaVersion": 1,
+  "label": "release",
+  "message": "1.0.0 (2023-09-07)",
+  "color": "blue"
+}
+`},
+	}
+
+	for _, test := range tests {
+		runBadge(test.args)
+
+		buf, err := ioutil.ReadFile(opts.Output)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(buf) != test.want {
+			t.Errorf("%v: want %q, got %q", test.args, test.want, buf)
+		}
+	}
+}
+
+func TestWrapIndent(t *testing.T) {
+	var tests = []struct {
+		In     string
+		Width  int
+		Indent int
+		Out    string
+	}{
+		{"Example string", 80, 4, "Example string"},
+		{"Lorem ipsum dolor sit amet, consectetur adipiscing elit, sed do eiusmod tempor incididunt ut labore et dolore magna aliqua.", 70, 3, "Lorem ipsum dolor sit amet, consectetur adipiscing elit, sed do\n   eiusmod tempor incididunt ut labore et dolore magna aliqua."},
+		{"Lorem ipsum dolor sit amet, consectetur adipiscing elit, sed do eiusmod tempor incididunt ut labore et dolore magna aliqua.", 55, 2, "Lorem ipsum dolor sit amet, consectetur adipiscing\n  elit, sed do eiusmod tempor incididunt ut labore et\n  dolore magna aliqua."},
+		{"```\nexample\n   with\n       random spaces\n```", 10, 3, "```\n   example\n      with\n          random spaces\n   ```"},
+		{"The error was:\n> unable to open repository at /srv/restic-repo: permission denied\n>\n> exit status 1", 30, 2, "The error was:\n  > unable to open repository at\n  > /srv/restic-repo: permission\n  > denied\n  >\n  > exit status 1"},
+		{"| Option | Default |\n| --- | --- |\n| `--pack-size` | 16 MiB |", 10, 2, "| Option | Default |\n  | --- | --- |\n  | `--pack-size` | 16 MiB |"},
+		{"See https://github.com/restic/restic/issues/1234#issuecomment-1234567890 for details", 30, 2, "See\n  https://github.com/restic/restic/issues/1234#issuecomment-1234567890\n  for details"},
+		{"Unterstützt jetzt Umlaute äöü in Beschreibungen", 20, 2, "Unterstützt jetzt\n  Umlaute äöü in\n  Beschreibungen"},
+		{"バックアップ 中に クラッシュ する 問題 を 修正", 16, 0, "バックアップ\n中に クラッシュ\nする 問題 を\n修正"},
+		{"Options:\n- first option with a long description\n  - nested\n1. numbered", 20, 2, "Options:\n  - first option with\n    a long description\n    - nested\n  1. numbered"},
+	}
+
+	for _, test := range tests {
+		t.Run("", func(t *testing.T) {
+			res, err := wrapIndent(test.In, test.Width, test.Indent)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := deep.Equal(res, test.Out); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestWrapIndentPrefix(t *testing.T) {
+	var tests = []struct {
+		In     string
+		Prefix string
+		Out    string
+	}{
+		{"Lorem ipsum dolor sit amet, consectetur adipiscing elit", "* ", "* Lorem ipsum dolor sit\n  amet, consectetur\n  adipiscing elit"},
+		{"Lorem ipsum dolor sit amet", "", "Lorem ipsum dolor sit\n  amet"},
+		{"https://github.com/restic/restic/issues/1234", "  * ", "  * https://github.com/restic/restic/issues/1234"},
+	}
+
+	for _, test := range tests {
+		res, err := wrapIndent(test.In, 24, 2, test.Prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if res != test.Out {
+			t.Errorf("%q: want %q, got %q", test.Prefix, test.Out, res)
+		}
+	}
+}
+
+func TestWrapIndentLongURL(t *testing.T) {
+	defer func() {
+		opts.URLOwnLine = false
+	}()
+
+	text := "See https://github.com/restic/restic/issues/1234#issuecomment-1234567890 for details, https://example.com is short"
+
+	want := map[bool]string{
+		false: "See\n  https://github.com/restic/restic/issues/1234#issuecomment-1234567890\n  for details,\n  https://example.com is short",
+		true:  "See\n  https://github.com/restic/restic/issues/1234#issuecomment-1234567890\n  for details,\n  https://example.com\n  is short",
+	}
+
+	for _, ownLine := range []bool{false, true} {
+		opts.URLOwnLine = ownLine
+		res, err := wrapIndent(text, 30, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if res != want[ownLine] {
+			t.Errorf("%v: want %q, got %q", ownLine, want[ownLine], res)
+		}
+	}
+}
+
+func TestInjectRegion(t *testing.T) {
+	var tests = []struct {
+		Text    string
+		Content string
+		Result  string
+		Err     bool
+	}{
+		{
+			"# Project\n\n<!-- calens:start:0.16.0 -->\nold\n<!-- calens:end:0.16.0 -->\n\nmore text\n",
+			"<!-- calens:start:0.17.0 -->\nnew\n<!-- calens:end:0.17.0 -->\n",
+			"# Project\n\n<!-- calens:start:0.17.0 -->\nnew\n<!-- calens:end:0.17.0 -->\n\nmore text\n",
+			false,
+		},
+		{
+			"<!-- calens:start:0.16.1 -->\na\n<!-- calens:end:0.16.1 -->\n\n<!-- calens:start:0.16.0 -->\nb\n<!-- calens:end:0.16.0 -->\nmore text",
+			"<!-- calens:start:0.17.0 -->\nnew\n<!-- calens:end:0.17.0 -->\n",
+			"<!-- calens:start:0.17.0 -->\nnew\n<!-- calens:end:0.17.0 -->\nmore text",
+			false,
+		},
+		{
+			"<!-- calens:start:latest --><!-- calens:end:latest -->",
+			"<!-- calens:start:0.17.0 -->\nnew\n<!-- calens:end:0.17.0 -->\n",
+			"<!-- calens:start:0.17.0 -->\nnew\n<!-- calens:end:0.17.0 -->",
+			false,
+		},
+		{"<!-- calens:start:0.16.0 -->\nold\n", "new", "", true},
+		{"<!-- calens:end:0.16.0 -->\n<!-- calens:start:0.16.0 -->\n", "new", "", true},
+		{"no markers", "new", "", true},
+	}
+
+	for _, test := range tests {
+		t.Run("", func(t *testing.T) {
+			res, err := injectRegion(test.Text, test.Content)
+			if test.Err {
+				if err == nil {
+					t.Fatalf("expected error, got %q", res)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res != test.Result {
+				t.Errorf("want:\n%q\ngot:\n%q", test.Result, res)
+			}
+		})
+	}
+}
+
+func TestRenderChangelog(t *testing.T) {
+	defer func() {
+		opts.Markers = false
+	}()
+
+	templ := template.Must(template.New("").Parse("{{ range . }}# {{ .Version }}\n\n{{ end }}"))
+	changes := Changelog{{Version: "0.17.0"}, {Version: "0.16.0"}}
+
+	var tests = []struct {
+		Markers bool
+		Output  string
+	}{
+		{false, "# 0.17.0\n\n# 0.16.0\n\n"},
+		{true, "<!-- calens:start:0.17.0 -->\n# 0.17.0\n<!-- calens:end:0.17.0 -->\n\n<!-- calens:start:0.16.0 -->\n# 0.16.0\n<!-- calens:end:0.16.0 -->\n"},
+	}
+
+	for _, test := range tests {
+		opts.Markers = test.Markers
+
+		var buf bytes.Buffer
+		err := renderChangelog(&buf, templ, changes)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if buf.String() != test.Output {
+			t.Errorf("markers %v: want %q, got %q", test.Markers, test.Output, buf.String())
+		}
+	}
+}
+
+func TestReadTemplate(t *testing.T) {
+	const tmpl = "{{ range . }}{{ .Version }}{{ end }}\n"
+	hash := sha256.Sum256([]byte(tmpl))
+	sum := hex.EncodeToString(hash[:])
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, tmpl)
+	}))
+	defer srv.Close()
+
+	filename := filepath.Join(t.TempDir(), "CHANGELOG.tmpl")
+	err := ioutil.WriteFile(filename, []byte(tmpl), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{filename, srv.URL + "/CHANGELOG.tmpl"} {
+		for _, s := range []string{"", sum, strings.ToUpper(sum)} {
+			buf, err := readTemplate(name, s)
+			if err != nil {
+				t.Fatalf("%v: %v", name, err)
+			}
+			if string(buf) != tmpl {
+				t.Errorf("%v: wrong content %q", name, buf)
+			}
+		}
+
+		_, err := readTemplate(name, strings.Repeat("0", 64))
+		if err == nil {
+			t.Errorf("%v: expected checksum mismatch", name)
+		}
+	}
+}
+
+func TestWalkEntries(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"unreleased/issue-3":        "Enhancement: Add new feature\n\nhttps://github.com/restic/restic/issues/3\n",
+		"1.0.0_2023-09-07/issue-1":  "Bugfix: Fix crash\n\nhttps://github.com/restic/restic/issues/1\n",
+		"1.0.0_2023-09-07/pull-2":   "Change: Update docs\n\nhttps://github.com/restic/restic/pull/2\n",
+		"0.9.0_2023-01-02/issue-99": "Bugfix: Invalid title.\n\nhttps://github.com/restic/restic/issues/99\n",
+	} {
+		err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(filepath.Join(dir, filepath.FromSlash(name)), []byte(data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var visited []string
+	stop := errors.New("stop")
+	err := opts.WalkEntries(dir, func(rel changelog.Release, e changelog.Entry) error {
+		visited = append(visited, fmt.Sprintf("%v %d", rel.Version, e.PrimaryID))
+		if e.PrimaryID == 2 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Fatalf("expected error from callback, got %v", err)
+	}
+
+	if diff := deep.Equal([]string{"unreleased 3", "1.0.0 1", "1.0.0 2"}, visited); diff != nil {
+		t.Error(diff)
+	}
+
+	err = opts.WalkEntries(dir, func(rel changelog.Release, e changelog.Entry) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "issue-99") {
+		t.Errorf("expected error for invalid entry, got %v", err)
+	}
+}
+
+func TestReadFileTitleContinuation(t *testing.T) {
+	e, err := opts.ParseFile(writeEntry(t, "Bugfix: fix crash when the repository is locked by\n  another process\n\tduring prune\n\nThe first paragraph.\n  Still the first paragraph.\n\nhttps://github.com/restic/restic/issues/1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.Type != "Bugfix" || e.Title != "Fix crash when the repository is locked by another process during prune" {
+		t.Errorf("wrong entry: type %q, title %q", e.Type, e.Title)
+	}
+
+	if diff := deep.Equal([]string{"The first paragraph. Still the first paragraph."}, e.Paragraphs); diff != nil {
+		t.Error(diff)
+	}
+
+	// the continuation is validated together with the first line
+	_, err = opts.ParseFile(writeEntry(t, "Bugfix: Fix crash\n  during prune.\n\nhttps://github.com/restic/restic/issues/1\n"))
+	var titleErr *changelog.ErrInvalidTitle
+	if !errors.As(err, &titleErr) {
+		t.Errorf("wrong error %v", err)
+	}
+}
+
+func TestParseEntryLineEndings(t *testing.T) {
+	text := "---\ncomponents: [backup]\n---\nBugfix: Fix crash\n\nFirst paragraph\nwhich continues.\n\n```\ncode\n```\n\nhttps://github.com/restic/restic/issues/1\n"
+
+	want, err := opts.ParseEntry("issue-1", strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = map[string]string{
+		"crlf":     strings.Replace(text, "\n", "\r\n", -1),
+		"cr":       strings.Replace(text, "\n", "\r", -1),
+		"bom":      "\ufeff" + text,
+		"bom+crlf": "\ufeff" + strings.Replace(text, "\n", "\r\n", -1),
+	}
+
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			e, err := opts.ParseEntry("issue-1", strings.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := deep.Equal(want, e); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestParseEntryEncoding(t *testing.T) {
+	defer func() {
+		opts.Latin1 = false
+	}()
+
+	data := "Bugfix: Fix crash\n\nThanks to Andr\xe9 for reporting.\n\nhttps://github.com/restic/restic/issues/1\n"
+
+	_, err := opts.ParseEntry("issue-1", strings.NewReader(data))
+	var encErr *changelog.ErrInvalidEncoding
+	if !errors.As(err, &encErr) {
+		t.Fatalf("wrong error %v", err)
+	}
+	if encErr.File != "issue-1" || encErr.Line != 3 || encErr.Offset != 33 {
+		t.Errorf("wrong position: file %q, line %d, offset %d", encErr.File, encErr.Line, encErr.Offset)
+	}
+
+	if line, _ := errorPosition("issue-1", err); line != 3 {
+		t.Errorf("wrong line %d", line)
+	}
+
+	opts.Latin1 = true
+	e, err := opts.ParseEntry("issue-1", strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal([]string{"Thanks to Andr\u00e9 for reporting."}, e.Paragraphs); diff != nil {
+		t.Error(diff)
+	}
+
+	// valid UTF-8 is not transcoded
+	e, err = opts.ParseEntry("issue-1", strings.NewReader("Bugfix: Fix crash for Andr\u00e9\n\nhttps://github.com/restic/restic/issues/1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Title != "Fix crash for Andr\u00e9" {
+		t.Errorf("wrong title %q", e.Title)
+	}
+}
+
+func TestLintFixLineEndings(t *testing.T) {
+	defer func() {
+		opts.Fix = false
+	}()
+	opts.Fix = true
+
+	filename := writeEntry(t, "\ufeffbugfix: Fix crash.\r\n\r\nhttps://github.com/restic/restic/issues/1\r\n")
+	lintFile(filename)
+
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "\ufeffBugfix: Fix crash\r\n\r\nhttps://github.com/restic/restic/issues/1\r\n"
+	if string(buf) != want {
+		t.Errorf("want %q, got %q", want, buf)
+	}
+}
+
+func TestLintMissingTitle(t *testing.T) {
+	for _, data := range []string{"---\nbreaking: true\n---", "---\nbreaking: true\n---\n"} {
+		filename := writeEntry(t, data)
+		if lintFile(filename) {
+			t.Errorf("%q: file without title line accepted", data)
+		}
+	}
+}
+
+func TestRetypeFile(t *testing.T) {
+	var tests = []struct {
+		data, want string
+	}{
+		{
+			"Bugfix: Fix crash\n\nhttps://github.com/restic/restic/issues/1\n",
+			"Enhancement: Fix crash\n\nhttps://github.com/restic/restic/issues/1\n",
+		},
+		{
+			"---\nbreaking: true\n---\nBugfix: Fix crash\n\nhttps://github.com/restic/restic/issues/1\n",
+			"---\nbreaking: true\n---\nEnhancement: Fix crash\n\nhttps://github.com/restic/restic/issues/1\n",
+		},
+		{
+			"Bugfix(backup)!: Fix crash\n\nhttps://github.com/restic/restic/issues/1\n",
+			"Enhancement(backup)!: Fix crash\n\nhttps://github.com/restic/restic/issues/1\n",
+		},
+		{
+			"Bugfix!: Fix crash\r\n\r\nhttps://github.com/restic/restic/issues/1\r\n",
+			"Enhancement!: Fix crash\r\n\r\nhttps://github.com/restic/restic/issues/1\r\n",
+		},
+		{
+			"Fix crash\n\nhttps://github.com/restic/restic/issues/1\n",
+			"Enhancement: Fix crash\n\nhttps://github.com/restic/restic/issues/1\n",
+		},
+	}
+
+	for _, test := range tests {
+		filename := writeEntry(t, test.data)
+		retypeFile(filename, "Enhancement")
+
+		buf, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(buf) != test.want {
+			t.Errorf("%q: want %q, got %q", test.data, test.want, buf)
+		}
+	}
+}
+
+func TestReadFileTypeAlias(t *testing.T) {
+	defer func(aliases map[string]string) {
+		changelog.EntryTypeAliases = aliases
+		opts.TypeAlias = nil
+	}(changelog.EntryTypeAliases)
+
+	var tests = []struct {
+		title, typ, short string
+		breaking          bool
+	}{
+		{"fix: handle symlinks", "Bugfix", "Fix", false},
+		{"Bug: handle symlinks", "Bugfix", "Fix", false},
+		{"feat(backup)!: handle symlinks", "Enhancement", "Enh", true},
+		{"sec: handle symlinks", "Security", "Sec", false},
+		{"perf: handle symlinks", "Enhancement", "Enh", false},
+	}
+
+	changelog.EntryTypeAliases = map[string]string{"fix": "Bugfix", "bug": "Bugfix", "feat": "Enhancement", "sec": "Security"}
+	opts.TypeAlias = map[string]string{"Perf": "enhancement"}
+	err := applyTypeOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			e, err := opts.ParseFile(writeEntry(t, test.title+"\n\nhttps://github.com/restic/restic/issues/1\n"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if e.Type != test.typ || e.TypeShort != test.short || e.Breaking != test.breaking || e.Title != "Handle symlinks" {
+				t.Errorf("wrong entry: type %q (%q), breaking %v, title %q", e.Type, e.TypeShort, e.Breaking, e.Title)
+			}
+
+			if _, problems := fixTitle(test.title); len(problems) != 0 {
+				t.Errorf("unexpected problems: %v", problems)
+			}
+		})
+	}
+
+	opts.TypeAlias = map[string]string{"docs": "Documentation"}
+	if err := applyTypeOptions(); err == nil {
+		t.Error("expected error for alias of unknown type")
+	}
+}
+
+func TestParseFileErrors(t *testing.T) {
+	var tests = []struct {
+		Data  string
+		Check func(error) bool
+	}{
+		{"Bugfix: Fix crash.\n\nhttps://github.com/restic/restic/issues/1\n", func(err error) bool {
+			var e *changelog.ErrInvalidTitle
+			return errors.As(err, &e) && e.Line == 1 && e.File != ""
+		}},
+		{"no prefix\n\nhttps://github.com/restic/restic/issues/1\n", func(err error) bool {
+			var e *changelog.ErrInvalidTitle
+			return errors.As(err, &e)
+		}},
+		{"Feature: Add something\n\nhttps://github.com/restic/restic/issues/1\n", func(err error) bool {
+			var e *changelog.ErrUnknownType
+			return errors.As(err, &e) && e.Type == "Feature" && e.Line == 1 && e.File != ""
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run("", func(t *testing.T) {
+			_, err := opts.ParseFile(writeEntry(t, test.Data))
+			if err == nil || !test.Check(err) {
+				t.Errorf("wrong error %#v", err)
+			}
+		})
+	}
+}
+
+func TestParseReleaseDir(t *testing.T) {
+	rel, err := opts.ParseReleaseDir(filepath.Join("changelog", "1.2.3_2023-01-02"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rel.Version != "1.2.3" || rel.Date == nil {
+		t.Errorf("wrong release %v", rel)
+	}
+
+	for _, name := range []string{"foo_bar_baz", "1.2.x", "1.2.3_2023-13-02"} {
+		_, err := opts.ParseReleaseDir(filepath.Join("changelog", name))
+		var e *changelog.ErrBadReleaseDir
+		if !errors.As(err, &e) {
+			t.Errorf("%v: wrong error %#v", name, err)
+		}
+	}
+
+	// calendar versions are read leniently, like semver does, lint warns
+	// about them
+	var list []changelog.Release
+	for _, name := range []string{"2024.05_2024-05-02", "2024.5_2024-05-02", "2024.5.0_2024-05-01", "1.2.0_2024-05-01"} {
+		rel, err = opts.ParseReleaseDir(filepath.Join("changelog", name))
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		list = append(list, rel)
+	}
+
+	for _, rel := range list[:3] {
+		if rel.Version != "2024.5.0" {
+			t.Errorf("%v: wrong version %v", rel.Path, rel.Version)
+		}
+	}
+
+	if diff := deep.Equal(list[:3], calendarVersionReleases(list)); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestLSP(t *testing.T) {
+	dir := t.TempDir()
+	defer func(input string) {
+		opts.InputDir = input
+	}(opts.InputDir)
+	opts.InputDir = dir
+
+	uri := "file://" + filepath.ToSlash(filepath.Join(dir, "unreleased", "issue-1"))
+
+	var in bytes.Buffer
+	for _, msg := range []string{
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`,
+		`{"jsonrpc":"2.0","method":"initialized","params":{}}`,
+		`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":"` + uri + `","text":"Bugfix: Fix crash.\n\nhttps://github.com/restic/restic/issues/1\n"}}}`,
+		`{"jsonrpc":"2.0","method":"textDocument/didChange","params":{"textDocument":{"uri":"` + uri + `"},"contentChanges":[{"text":"Bugfix: Fix crash\n\nhttps://github.com/restic/restic/issues/1\n"}]}}`,
+		`{"jsonrpc":"2.0","id":2,"method":"textDocument/completion","params":{"textDocument":{"uri":"` + uri + `"},"position":{"line":0,"character":0}}}`,
+		`{"jsonrpc":"2.0","id":3,"method":"textDocument/hover","params":{}}`,
+		`{"jsonrpc":"2.0","id":4,"method":"shutdown"}`,
+		`{"jsonrpc":"2.0","method":"exit"}`,
+	} {
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(msg), msg)
+	}
+
+	var out bytes.Buffer
+	srv := &lspServer{rd: bufio.NewReader(&in), wr: &out, docs: make(map[string]string)}
+	err := srv.serve()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var msgs []map[string]interface{}
+	rd := bufio.NewReader(&out)
+	for {
+		_, err := rd.Peek(1)
+		if err == io.EOF {
+			break
+		}
+
+		var length int
+		_, err = fmt.Fscanf(rd, "Content-Length: %d\r\n\r\n", &length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, length)
+		_, err = io.ReadFull(rd, buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var msg map[string]interface{}
+		err = json.Unmarshal(buf, &msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+
+	if len(msgs) != 6 {
+		t.Fatalf("expected 6 messages, got %d: %v", len(msgs), msgs)
+	}
+
+	diagnostics := msgs[1]["params"].(map[string]interface{})["diagnostics"].([]interface{})
+	if len(diagnostics) != 1 || !strings.Contains(diagnostics[0].(map[string]interface{})["message"].(string), "punctuation") {
+		t.Errorf("wrong diagnostics for invalid entry: %v", diagnostics)
+	}
+
+	diagnostics = msgs[2]["params"].(map[string]interface{})["diagnostics"].([]interface{})
+	if len(diagnostics) != 0 {
+		t.Errorf("unexpected diagnostics for valid entry: %v", diagnostics)
+	}
+
+	items := msgs[3]["result"].([]interface{})
+	if len(items) != len(changelog.EntryTypes()) {
+		t.Errorf("wrong completion items: %v", items)
+	}
+
+	if _, ok := msgs[4]["error"]; !ok {
+		t.Errorf("expected error for unsupported method, got %v", msgs[4])
+	}
+}
+
+func TestLSPComplete(t *testing.T) {
+	var tests = []struct {
+		text      string
+		character int
+		complete  bool
+	}{
+		{"Bugfix: Fix crash", 3, true},
+		{"Bugfix: Fix crash", 7, false},
+		{"é: Fix crash", 1, true},
+		{"é: Fix crash", 2, false},
+		{"😀: Fix crash", 2, true},
+		{"😀: Fix crash", 3, false},
+		{"😀: Fix crash", 20, false},
+	}
+
+	for _, test := range tests {
+		srv := &lspServer{docs: map[string]string{"file:///issue-1": test.text}}
+		items := srv.complete("file:///issue-1", lspPosition{Line: 0, Character: test.character})
+		if complete := len(items) > 0; complete != test.complete {
+			t.Errorf("%q at %d: want completion %v, got %v", test.text, test.character, test.complete, complete)
+		}
+	}
+}
+
+func TestEscapeAnnotation(t *testing.T) {
+	if s := escapeAnnotation("100% done\nnext: a, b", false); s != "100%25 done%0Anext: a, b" {
+		t.Errorf("wrong message %q", s)
+	}
+
+	if s := escapeAnnotation("changelog/unreleased/a:b,c", true); s != "changelog/unreleased/a%3Ab%2Cc" {
+		t.Errorf("wrong property %q", s)
+	}
+}
+
+func TestReadFileShorthand(t *testing.T) {
+	opts.RepoURL = "https://github.com/restic/restic"
+	defer func() {
+		opts.RepoURL = ""
+	}()
+
+	e := readFile(writeEntry(t, "Bugfix: Fix crash\n\n#1234 GH-42\nhttps://github.com/restic/restic/pull/99\n"))
+
+	want := []string{
+		"https://github.com/restic/restic/issues/1234",
+		"https://github.com/restic/restic/issues/42",
+		"https://github.com/restic/restic/pull/99",
+	}
+	var got []string
+	for _, u := range e.URLs {
+		got = append(got, u.String())
+	}
+	if diff := deep.Equal(want, got); diff != nil {
+		t.Error(diff)
+	}
+
+	if diff := deep.Equal([]string{"1234", "42"}, e.Issues); diff != nil {
+		t.Error(diff)
+	}
+	if e.PrimaryID != 1234 {
+		t.Errorf("wrong primary ID %v", e.PrimaryID)
+	}
+}
+
+func TestReadFileCVEs(t *testing.T) {
+	e := readFile(writeEntry(t, "Security: Fix CVE-2024-12345 in the REST backend\n\nhttps://github.com/restic/restic/issues/1\n"))
+
+	want := []changelog.CVE{{
+		ID:       "CVE-2024-12345",
+		NVDURL:   "https://nvd.nist.gov/vuln/detail/CVE-2024-12345",
+		MITREURL: "https://www.cve.org/CVERecord?id=CVE-2024-12345",
+	}}
+	if diff := deep.Equal(want, e.CVEs); diff != nil {
+		t.Error(diff)
+	}
+
+	e = readFile(writeEntry(t, "Bugfix: Fix CVE-2024-12345 in the REST backend\n\nhttps://github.com/restic/restic/issues/1\n"))
+	if e.CVEs != nil {
+		t.Errorf("unexpected CVEs for Bugfix entry: %v", e.CVEs)
+	}
+}
+
+func TestReadFileCoAuthors(t *testing.T) {
+	e := readFile(writeEntry(t, "Bugfix: Fix crash\n\nWe've fixed the crash.\n\nhttps://github.com/restic/restic/issues/1\n\nCo-authored-by: Jane Doe <jane@example.com>\nco-authored-by: John Roe\n"))
+
+	if diff := deep.Equal([]string{"Jane Doe", "John Roe"}, e.Authors); diff != nil {
+		t.Error(diff)
+	}
+
+	if diff := deep.Equal([]string{"We've fixed the crash."}, e.Paragraphs); diff != nil {
+		t.Error(diff)
+	}
+
+	if len(e.URLs) != 1 || e.PrimaryID != 1 {
+		t.Errorf("wrong links %v", e.URLs)
+	}
+}
+
+func TestReadFileAdvisories(t *testing.T) {
+	e := readFile(writeEntry(t, "Security: Fix leak\n\nhttps://github.com/restic/restic/issues/1\nhttps://github.com/restic/restic/security/advisories/GHSA-r5gh-p3vq-w6x2\n"))
+
+	if diff := deep.Equal([]string{"GHSA-r5gh-p3vq-w6x2"}, e.Advisories); diff != nil {
+		t.Error(diff)
+	}
+
+	if len(e.AdvisoryURLs) != 1 || len(e.OtherURLs) != 0 {
+		t.Errorf("wrong links: advisories %v, other %v", e.AdvisoryURLs, e.OtherURLs)
+	}
+}
+
+func TestFilterEntries(t *testing.T) {
+	defer func() {
+		opts.Preset = "technical"
+		opts.Internal = false
+		opts.Audience = ""
+		InternalComponents = map[string]bool{}
+	}()
+	InternalComponents = map[string]bool{"ci": true}
+
+	entries := []changelog.Entry{
+		{Type: "Bugfix", Title: "issue", Issues: []string{"1"}},
+		{Type: "Bugfix", Title: "issue and pr", Issues: []string{"2"}, PRs: []string{"3"}},
+		{Type: "Enhancement", Title: "pr only", PRs: []string{"4"}},
+		{Type: "Change", Component: "CI", Title: "internal", Issues: []string{"5"}},
+		{Type: "Change", Title: "no links"},
+		{Type: "Change", Title: "hidden", Issues: []string{"6"}, Visibility: "internal"},
+		{Type: "Change", Title: "for users", Issues: []string{"7"}, Audience: "user"},
+		{Type: "Change", Title: "for developers", Issues: []string{"8"}, Audience: "developer"},
+		{Type: "Change", Title: "draft", Issues: []string{"9"}, Draft: true},
+	}
+
+	var tests = []struct {
+		Preset   string
+		Internal bool
+		Audience string
+		Titles   []string
+	}{
+		{"technical", false, "", []string{"issue", "issue and pr", "pr only", "internal", "no links", "for users", "for developers"}},
+		{"technical", true, "", []string{"issue", "issue and pr", "pr only", "internal", "no links", "hidden", "for users", "for developers"}},
+		{"user-facing", false, "", []string{"issue", "issue and pr", "no links", "for users", "for developers"}},
+		{"technical", false, "User", []string{"issue", "issue and pr", "pr only", "internal", "no links", "for users"}},
+		{"technical", false, "developer", []string{"issue", "issue and pr", "pr only", "internal", "no links", "for developers"}},
+	}
+
+	for _, test := range tests {
+		opts.Preset = test.Preset
+		opts.Internal = test.Internal
+		opts.Audience = test.Audience
+
+		var titles []string
+		for _, e := range filterEntries(entries) {
+			titles = append(titles, e.Title)
+		}
+
+		if diff := deep.Equal(test.Titles, titles); diff != nil {
+			t.Errorf("%v: %v", test.Preset, diff)
+		}
+	}
+}
+
+func TestReadFileVisibility(t *testing.T) {
+	e := readFile(writeEntry(t, "Change: Refactor the index\n\nVisibility: internal\n\nhttps://github.com/restic/restic/pull/1\n"))
+	if e.Visibility != "internal" {
+		t.Errorf("wrong visibility %q", e.Visibility)
+	}
+
+	if len(e.Paragraphs) != 0 || len(e.PRs) != 1 {
+		t.Errorf("visibility line not removed: %v %v", e.Paragraphs, e.URLs)
+	}
+
+	_, err := opts.ParseFile(writeEntry(t, "Change: Refactor the index\n\nvisibility: secret\n\nhttps://github.com/restic/restic/pull/1\n"))
+	if err == nil {
+		t.Error("expected error for invalid visibility")
+	}
+}
+
+func TestReadFileList(t *testing.T) {
+	e := readFile(writeEntry(t, "Enhancement: Add options\n\nThe new options are:\n- `--foo` enables\n  foo\n  * `--bar` nested\n1. numbered\n\nSee the docs.\n\nhttps://github.com/restic/restic/issues/1\n"))
+
+	want := []string{"The new options are:\n- `--foo` enables foo\n  * `--bar` nested\n1. numbered", "See the docs."}
+	if diff := deep.Equal(want, e.Paragraphs); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestReadFileBlocks(t *testing.T) {
+	e := readFile(writeEntry(t, "Bugfix: Fix permission error\n\nThe error was:\n> unable to open repository\ncontinued here\n> exit status 1\n\n| Option | Default |\n| --- | --- |\n| `--pack-size` | 16 MiB |\nafter the table\n\nhttps://github.com/restic/restic/issues/1\n"))
+
+	want := []string{
+		"The error was:\n> unable to open repository continued here\n> exit status 1",
+		"| Option | Default |\n| --- | --- |\n| `--pack-size` | 16 MiB |\nafter the table",
+	}
+	if diff := deep.Equal(want, e.Paragraphs); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestReadFileDraft(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"draft-issue-1": "Bugfix: Fix crash\n\nhttps://github.com/restic/restic/issues/1\n",
+		"issue-2":       "---\ndraft: true\n---\nBugfix: Fix leak\n\nhttps://github.com/restic/restic/issues/2\n",
+		"issue-3":       "Bugfix: Fix hang\n\nhttps://github.com/restic/restic/issues/3\n",
+	} {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		e := readFile(filepath.Join(dir, name))
+		if e.Draft != (name != "issue-3") {
+			t.Errorf("%v: wrong draft flag %v", name, e.Draft)
+		}
+	}
+}
+
+func TestReadFileAudience(t *testing.T) {
+	e := readFile(writeEntry(t, "Change: Rename the Go API\n\nAudience: Developer\n\nhttps://github.com/restic/restic/pull/1\n"))
+	if e.Audience != "developer" {
+		t.Errorf("wrong audience %q", e.Audience)
+	}
+
+	if len(e.Paragraphs) != 0 {
+		t.Errorf("audience line not removed: %v", e.Paragraphs)
+	}
+}
+
+func TestReadFileFrontMatter(t *testing.T) {
+	e := readFile(writeEntry(t, "---\nauthor: Jane Doe\ncomponent: backup\nbreaking: true\nseverity: high\naudience: admins\n---\nChange: Remove the old index format\n\nhttps://github.com/restic/restic/issues/1\n"))
+
+	if diff := deep.Equal([]string{"Jane Doe"}, e.Authors); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal([]string{"backup"}, e.Components); diff != nil {
+		t.Error(diff)
+	}
+	if !e.Breaking || e.Severity != "high" || e.Audience != "admins" {
+		t.Errorf("wrong metadata %v %v %v", e.Breaking, e.Severity, e.Audience)
+	}
+	if e.Type != "Change" || e.Title != "Remove the old index format" || e.PrimaryID != 1 {
+		t.Errorf("wrong entry %v: %v (%v)", e.Type, e.Title, e.PrimaryID)
+	}
+
+	for _, data := range []string{
+		"---\nseverity: high\nChange: Unterminated\n",
+		"---\nauthr: Jane Doe\n---\nChange: Typo in key\n\nhttps://github.com/restic/restic/issues/1\n",
+	} {
+		if _, err := opts.ParseFile(writeEntry(t, data)); err == nil {
+			t.Errorf("expected error for %q", data)
+		}
+	}
+
+	_, err := opts.ParseFile(writeEntry(t, "---\nseverity: high\n---\nChange: Title.\n\nhttps://github.com/restic/restic/issues/1\n"))
+	var titleErr *changelog.ErrInvalidTitle
+	if !errors.As(err, &titleErr) || titleErr.Line != 4 {
+		t.Errorf("wrong error %v", err)
+	}
+}
+
+func TestAffectedEvents(t *testing.T) {
+	e := readFile(writeEntry(t, "Security: Fix path traversal\n\nhttps://github.com/restic/restic/issues/1\n"))
+	want := []map[string]string{{"introduced": "0"}, {"fixed": "0.16.1"}}
+	if diff := deep.Equal(want, affectedEvents(e, "0.16.1")); diff != nil {
+		t.Error(diff)
+	}
+
+	e = readFile(writeEntry(t, "---\naffected:\n  - introduced: 0.15.0\n    fixed: 0.15.3\n  - introduced: 0.16.0\n---\nSecurity: Fix path traversal\n\nhttps://github.com/restic/restic/issues/1\n"))
+	want = []map[string]string{{"introduced": "0.15.0"}, {"fixed": "0.15.3"}, {"introduced": "0.16.0"}, {"fixed": "0.16.1"}}
+	if diff := deep.Equal(want, affectedEvents(e, "0.16.1")); diff != nil {
+		t.Error(diff)
+	}
+
+	_, err := opts.ParseFile(writeEntry(t, "---\naffected:\n  - fixed: 0.15.3\n---\nSecurity: Fix path traversal\n\nhttps://github.com/restic/restic/issues/1\n"))
+	if err == nil {
+		t.Error("expected error for affected range without introduced version")
+	}
+}
+
+func TestNextVersion(t *testing.T) {
+	now := time.Date(2024, time.June, 3, 0, 0, 0, 0, time.UTC)
+
+	var tests = []struct {
+		Versioning string
+		Dirs       []string
+		Entry      string
+		Next       string
+	}{
+		{"semver", []string{"1.2.3_2024-01-01", "1.2.0_2023-01-01"}, "Bugfix: Fix crash", "1.2.4"},
+		{"semver", []string{"1.2.3_2024-01-01"}, "Enhancement: Add feature", "1.3.0"},
+		{"semver", []string{"1.2.3_2024-01-01"}, "---\nbreaking: true\n---\nBugfix: Fix crash", "2.0.0"},
+		{"semver", nil, "Bugfix: Fix crash", "0.1.0"},
+		{"calver", []string{"2024.05_2024-05-01"}, "Bugfix: Fix crash", "2024.06"},
+		{"calver", []string{"2024.06_2024-06-01"}, "Bugfix: Fix crash", "2024.06.1"},
+		{"calver", []string{"2024.06.1_2024-06-02", "2024.06_2024-06-01"}, "Bugfix: Fix crash", "2024.06.2"},
+		{"ordinal", []string{"41_2024-05-01", "9_2023-05-01"}, "Bugfix: Fix crash", "42"},
+		{"ordinal", nil, "Bugfix: Fix crash", "1"},
+	}
+
+	defer func() {
+		opts.Versioning = "semver"
+	}()
+
+	for _, test := range tests {
+		t.Run("", func(t *testing.T) {
+			opts.Versioning = test.Versioning
+
+			dir := t.TempDir()
+			for _, name := range append(test.Dirs, "unreleased") {
+				err := os.Mkdir(filepath.Join(dir, name), 0755)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := ioutil.WriteFile(filepath.Join(dir, "unreleased", "issue-1"), []byte(test.Entry+"\n\nhttps://github.com/restic/restic/issues/1\n"), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			next, err := nextVersion(readReleases(dir), now)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if next != test.Next {
+				t.Errorf("want %v, got %v", test.Next, next)
+			}
+		})
+	}
+}
+
+func TestPendingSkipsDrafts(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"1.2.3_2024-01-01/issue-1": "Bugfix: Fix crash\n\nhttps://github.com/restic/restic/issues/1\n",
+		"unreleased/issue-2":       "Bugfix: Fix backup\n\nhttps://github.com/restic/restic/issues/2\n",
+		"unreleased/draft-issue-3": "---\nbreaking: true\n---\nChange: Remove option\n\nhttps://github.com/restic/restic/issues/3\n",
+		"unreleased/issue-4":       "Enhancement: Add internal API\n\nVisibility: internal\n\nhttps://github.com/restic/restic/issues/4\n",
+	} {
+		err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(filepath.Join(dir, filepath.FromSlash(name)), []byte(data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	releases := readReleases(dir)
+
+	next, err := nextVersion(releases, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next != "1.2.4" {
+		t.Errorf("wrong next version %v, drafts or internal entries were counted", next)
+	}
+
+	if badge := pendingBadge(releases); badge.Message != "1 change pending" {
+		t.Errorf("wrong badge message %q", badge.Message)
+	}
+}
+
+func TestBumpFiles(t *testing.T) {
+	defer func() {
+		bumpRules = nil
+	}()
+
+	dir := t.TempDir()
+	versionFile := filepath.Join(dir, "VERSION")
+	goFile := filepath.Join(dir, "version.go")
+
+	for name, data := range map[string]string{
+		versionFile: "0.9.0\n",
+		goFile:      "package main\n\nvar version = \"0.9.0-dev\"\n",
+	} {
+		err := ioutil.WriteFile(name, []byte(data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := Config{Bump: []BumpConfig{
+		{File: versionFile, Replace: "{{ .Version }}"},
+		{File: goFile, Pattern: `(var version = )"[^"]*"`, Replace: `$1"{{ .Version }}"`},
+	}}
+	err := cfg.apply()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = bumpFiles("1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{
+		versionFile: "1.0.0\n",
+		goFile:      "package main\n\nvar version = \"1.0.0\"\n",
+	} {
+		buf, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf) != want {
+			t.Errorf("%v: want %q, got %q", name, want, buf)
+		}
+	}
+
+	bumpRules = nil
+	cfg = Config{Bump: []BumpConfig{{File: versionFile, Pattern: "no match", Replace: "x"}}}
+	err = cfg.apply()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bumpFiles("1.0.1"); err == nil {
+		t.Error("expected error for pattern without match")
+	}
+}
+
+func TestReadFileComponent(t *testing.T) {
+	e := readFile(writeEntry(t, "bugfix(backup): handle symlinks\n\nhttps://github.com/restic/restic/issues/1\n"))
+
+	if e.Type != "Bugfix" || e.TypeShort != "Fix" || e.Component != "backup" || e.Title != "Handle symlinks" {
+		t.Errorf("wrong entry: type %q (%q), component %q, title %q", e.Type, e.TypeShort, e.Component, e.Title)
+	}
+
+	if diff := deep.Equal([]string{"backup"}, e.Components); diff != nil {
+		t.Error(diff)
+	}
+
+	_, err := opts.ParseFile(writeEntry(t, "Feature(backup): Handle symlinks\n\nhttps://github.com/restic/restic/issues/1\n"))
+	var typeErr *changelog.ErrUnknownType
+	if !errors.As(err, &typeErr) || typeErr.Type != "Feature" {
+		t.Errorf("wrong error %v", err)
+	}
+}
+
+func TestBuildChangesBreaking(t *testing.T) {
+	dir := t.TempDir()
+	err := os.Mkdir(filepath.Join(dir, "1.0.0_2023-11-01"), 0750)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range map[string]string{
+		"issue-1": "Change!: Remove the old index format\n\nhttps://github.com/restic/restic/issues/1\n",
+		"issue-2": "Bugfix(backup)!: Reject invalid paths\n\nhttps://github.com/restic/restic/issues/2\n",
+		"issue-3": "Bugfix: Fix crash\n\nhttps://github.com/restic/restic/issues/3\n",
+	} {
+		err := ioutil.WriteFile(filepath.Join(dir, "1.0.0_2023-11-01", name), []byte(data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	changes := buildChanges(readReleases(dir))
+	if len(changes) != 1 || len(changes[0].Entries) != 3 {
+		t.Fatalf("wrong changes %v", changes)
+	}
+
+	var breaking []string
+	for _, e := range changes[0].Breaking {
+		breaking = append(breaking, fmt.Sprintf("%v(%v) %v", e.Type, e.Component, e.Title))
+	}
+
+	want := []string{"Bugfix(backup) Reject invalid paths", "Change() Remove the old index format"}
+	if diff := deep.Equal(want, breaking); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestChecklist(t *testing.T) {
+	defer func() {
+		checklistSteps = nil
+	}()
+
+	cfg := Config{Checklist: []ChecklistConfig{
+		{Step: "Tag {{ .Version }}"},
+		{Step: "Notify the distributions", If: `hasType .Entries "Security"`},
+		{Step: "Announce the breaking changes", If: ".Breaking"},
+	}}
+	err := cfg.apply()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vc := VersionChanges{
+		Version: "0.17.0",
+		Entries: []changelog.Entry{{Type: "Security", Title: "Fix leak"}},
+	}
+
+	list, err := checklist(vc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"Tag 0.17.0", "Notify the distributions"}
+	if diff := deep.Equal(want, list); diff != nil {
+		t.Error(diff)
+	}
+
+	if _, err := newChecklistStep(ChecklistConfig{Step: "foo", If: "{{"}); err == nil {
+		t.Error("expected error for invalid condition")
+	}
+}
+
+func TestContributors(t *testing.T) {
+	dir := t.TempDir()
+	err := os.Mkdir(filepath.Join(dir, "1.0.0_2023-11-01"), 0750)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range map[string]string{
+		"issue-1": "Bugfix: Fix crash\n\nThanks to @alice and @bob for the report, also @carol.\n\nhttps://github.com/restic/restic/issues/1\n\nCo-authored-by: Dave <dave@example.com>\n",
+		"issue-2": "Bugfix: Fix leak\n\nMany thanks goes to @Alice, @erin & @frank!\n\nhttps://github.com/restic/restic/issues/2\n",
+	} {
+		err := ioutil.WriteFile(filepath.Join(dir, "1.0.0_2023-11-01", name), []byte(data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	changes := buildChanges(readReleases(dir))
+	if len(changes) != 1 {
+		t.Fatalf("wrong changes %v", changes)
+	}
+
+	want := []string{"Dave", "@alice", "@bob", "@erin", "@frank"}
+	if diff := deep.Equal(want, changes[0].Contributors); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestCompressOutput(t *testing.T) {
+	defer func() {
+		opts.Output = ""
+		opts.Compress = "auto"
+	}()
+
+	var tests = []struct {
+		output, compress string
+		decompress       func(io.Reader) (io.Reader, error)
+	}{
+		{"CHANGELOG.md", "auto", func(rd io.Reader) (io.Reader, error) { return rd, nil }},
+		{"CHANGELOG.md.gz", "auto", func(rd io.Reader) (io.Reader, error) { return gzip.NewReader(rd) }},
+		{"CHANGELOG.md.zst", "auto", func(rd io.Reader) (io.Reader, error) { return zstd.NewReader(rd) }},
+		{"", "gzip", func(rd io.Reader) (io.Reader, error) { return gzip.NewReader(rd) }},
+		{"CHANGELOG.md.gz", "none", func(rd io.Reader) (io.Reader, error) { return rd, nil }},
+	}
+
+	for _, test := range tests {
+		opts.Output = test.output
+		opts.Compress = test.compress
+
+		var buf bytes.Buffer
+		err := compressOutput(&buf, func(wr io.Writer) error {
+			_, err := io.WriteString(wr, "# Changelog\n")
+			return err
+		})
+		if err != nil {
+			t.Fatalf("%v %v: %v", test.output, test.compress, err)
+		}
+
+		rd, err := test.decompress(&buf)
+		if err != nil {
+			t.Fatalf("%v %v: %v", test.output, test.compress, err)
+		}
+
+		data, err := ioutil.ReadAll(rd)
+		if err != nil {
+			t.Fatalf("%v %v: %v", test.output, test.compress, err)
+		}
+
+		if string(data) != "# Changelog\n" {
+			t.Errorf("%v %v: wrong output %q", test.output, test.compress, data)
+		}
+	}
+
+	opts.Compress = "bzip2"
+	if err := compressOutput(ioutil.Discard, func(io.Writer) error { return nil }); err == nil {
+		t.Error("expected error for unknown compression")
+	}
+}
+
+func TestChangedFiles(t *testing.T) {
+	dir, run, write := gitRepo(t)
+
+	err := os.Mkdir(filepath.Join(dir, "unreleased"), 0750)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write(filepath.Join("unreleased", "issue-1"))
+	write(filepath.Join("unreleased", "issue-2"))
+	run("add", ".")
+	run("commit", "-q", "-m", "add entries")
+	run("branch", "base")
+
+	write(filepath.Join("unreleased", "issue-3"))
+	run("add", ".")
+	run("commit", "-q", "-m", "add issue-3")
+	err = ioutil.WriteFile(filepath.Join(dir, "unreleased", "issue-2"), []byte("Bugfix: Fix crash\n\nhttps://github.com/restic/restic/issues/2\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(filepath.Join("unreleased", "issue-4"))
+
+	opts.InputDir = dir
+	opts.ChangedSince = "base"
+	defer func() {
+		opts.InputDir = "changelog"
+		opts.ChangedSince = ""
+		changedSince = nil
+	}()
+
+	var names []string
+	err = opts.WalkEntries(dir, func(_ changelog.Release, e changelog.Entry) error {
+		names = append(names, filepath.Base(e.File))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := deep.Equal([]string{"issue-2", "issue-3", "issue-4"}, names); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	filename := writeEntry(t, "---\ndraft: true\n---\nbugfix(backup): fix crash\n\nThe crash happened on start.\n\nhttps://github.com/restic/restic/issues/12\nhttps://github.com/restic/restic/pull/34\nhttps://example.com/report\n")
+
+	var buf bytes.Buffer
+	err := explain(&buf, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "file:       " + filename + `
+type:       Bugfix
+component:  backup
+title:      Fix crash
+paragraphs: 1
+   1: The crash happened on start.
+links:      3
+  https://github.com/restic/restic/issues/12: issue 12
+  https://github.com/restic/restic/pull/34: pr 34
+  https://example.com/report: other
+primary:    12 (https://github.com/restic/restic/issues/12)
+warnings:
+  entry type "bugfix(backup)" is not capitalized
+  entry is a draft and not rendered
+`
+	if diff := deep.Equal(want, buf.String()); diff != nil {
+		t.Error(diff)
+		t.Log(buf.String())
+	}
+
+	if err := explain(ioutil.Discard, writeEntry(t, "Bugfix Fix crash\n")); err == nil {
+		t.Error("expected error for invalid entry")
+	}
+}
+
+func TestPreviewEntry(t *testing.T) {
+	dir := t.TempDir()
+	templ := filepath.Join(dir, "CHANGELOG.tmpl")
+	err := ioutil.WriteFile(templ, []byte("{{ range . }}# {{ .Version }}\n{{ range .Entries }}* {{ .TypeShort }} #{{ .PrimaryID }}: {{ .Title }}\n{{ end }}{{ end }}"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(templateFile string) {
+		opts.TemplateFile = templateFile
+		opts.Output = ""
+	}(opts.TemplateFile)
+	opts.TemplateFile = templ
+	opts.Output = filepath.Join(dir, "preview.md")
+
+	runPreviewEntry([]string{writeEntry(t, "---\ndraft: true\n---\nBugfix: Fix crash\n\nhttps://github.com/restic/restic/issues/12\n")})
+
+	buf, err := ioutil.ReadFile(opts.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "# unreleased\n* Fix #12: Fix crash\n"; string(buf) != want {
+		t.Errorf("want %q, got %q", want, buf)
+	}
+}
+
+func TestNowTimezone(t *testing.T) {
+	defer func() {
+		releaseTimezone = time.Local
+	}()
+
+	releaseTimezone = time.FixedZone("UTC+14", 14*3600)
+	if _, offset := now().Zone(); offset != 14*3600 {
+		t.Errorf("wrong offset %v", offset)
+	}
+
+	releaseTimezone = time.FixedZone("UTC-12", -12*3600)
+	if _, offset := now().Zone(); offset != -12*3600 {
+		t.Errorf("wrong offset %v", offset)
+	}
+}
+
+func TestStatsQuality(t *testing.T) {
+	dir := t.TempDir()
+	err := os.Mkdir(filepath.Join(dir, "1.0.0_2024-01-01"), 0750)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"issue-1": "Bugfix: Fix crash on startup\n\nThe crash happened when the config was empty.\n\nhttps://github.com/restic/restic/issues/1\n",
+		"issue-2": "bugfix: fix leak\n\nhttps://github.com/restic/restic/issues/2\n",
+	}
+	for name, data := range files {
+		err := ioutil.WriteFile(filepath.Join(dir, "1.0.0_2024-01-01", name), []byte(data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	defer func() {
+		opts.Quality = false
+	}()
+	opts.Quality = true
+
+	changes := buildChanges(readReleases(dir))
+	if len(changes) != 1 {
+		t.Fatalf("wrong changes %v", changes)
+	}
+
+	var buf bytes.Buffer
+	err = writeStats(&buf, changes[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "1.0.0 (2024-01-01): 2 entries (Bugfix: 2), quality 62%\n" +
+		"   25%  " + filepath.Join(dir, "1.0.0_2024-01-01", "issue-2") + ": no body, title style, title too short\n"
+	if buf.String() != want {
+		t.Errorf("want %q, got %q", want, buf.String())
+	}
+}
+
+func TestPostProcess(t *testing.T) {
+	if _, err := exec.LookPath("sed"); err != nil {
+		t.Skip("sed not found")
+	}
+
+	defer func() {
+		postProcessors = nil
+	}()
+
+	cfg := Config{
+		PostProcess: []PostProcessConfig{
+			{Command: "tr a-z A-Z"},
+			{Command: "sed -e s/CHANGELOG/Changes/ {file}", Output: "*.md"},
+			{Command: "sed -e s/#/<h1>/", Output: "*.html"},
+		},
+	}
+
+	err := cfg.apply()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		output, want string
+	}{
+		{"CHANGELOG.md", "# Changes\n"},
+		{"", "# CHANGELOG\n"},
+		{"changelog.html", "<h1> CHANGELOG\n"},
+	}
+
+	for _, test := range tests {
+		buf, err := postProcess(test.output, []byte("# Changelog\n"))
+		if err != nil {
+			t.Fatalf("%v: %v", test.output, err)
+		}
+
+		if string(buf) != test.want {
+			t.Errorf("%v: want %q, got %q", test.output, test.want, buf)
+		}
+	}
+
+	postProcessors = nil
+	cfg = Config{PostProcess: []PostProcessConfig{{Command: "sed -e s/"}}}
+	err = cfg.apply()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := postProcess("", []byte("# Changelog\n")); err == nil {
+		t.Error("expected error for failing post-processor")
+	}
+
+	cfg = Config{PostProcess: []PostProcessConfig{{Command: " "}}}
+	if err := cfg.apply(); err == nil {
+		t.Error("expected error for empty command")
+	}
+}
+
+func TestHistoryLock(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"unreleased/issue-3":       "Enhancement: Add new feature\n\nhttps://github.com/restic/restic/issues/3\n",
+		"1.0.0_2023-09-07/issue-1": "Bugfix: Fix crash\n\nhttps://github.com/restic/restic/issues/1\n",
+		"0.9.0_2023-01-02/pull-2":  "Change: Update docs\n\nhttps://github.com/restic/restic/pull/2\n",
+	} {
+		err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(filepath.Join(dir, filepath.FromSlash(name)), []byte(data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	defer func() {
+		opts.InputDir = "changelog"
+		opts.AllowHistoryEdit = false
+	}()
+	opts.InputDir = dir
+
+	// without a lock file, nothing is checked
+	if !lintHistory() {
+		t.Error("unexpected problems without lock file")
+	}
+
+	runLock(nil)
+
+	checksums, err := readHistoryLock(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checksums) != 2 || checksums["1.0.0"] == "" || checksums["0.9.0"] == "" {
+		t.Fatalf("wrong checksums %v", checksums)
+	}
+
+	if !lintHistory() {
+		t.Error("unexpected problems for unchanged releases")
+	}
+
+	// changing unreleased entries is fine
+	err = ioutil.WriteFile(filepath.Join(dir, "unreleased", "issue-4"), []byte("Bugfix: Fix another crash\n\nhttps://github.com/restic/restic/issues/4\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lintHistory() {
+		t.Error("unexpected problems for changed unreleased entries")
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "1.0.0_2023-09-07", "issue-1"), []byte("Bugfix: Fix the crash\n\nhttps://github.com/restic/restic/issues/1\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lintHistory() {
+		t.Error("changed release not reported")
+	}
+
+	opts.AllowHistoryEdit = true
+	if !lintHistory() {
+		t.Error("changed release reported with --allow-history-edit")
+	}
+	opts.AllowHistoryEdit = false
+
+	err = os.Rename(filepath.Join(dir, "unreleased"), filepath.Join(dir, "1.1.0_2023-10-01"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel, err := opts.ParseReleaseDir(filepath.Join(dir, "1.1.0_2023-10-01"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lockRelease(rel); err != nil {
+		t.Fatal(err)
+	}
+
+	checksums, err = readHistoryLock(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checksums) != 3 || checksums["1.1.0"] == "" {
+		t.Fatalf("new release not locked: %v", checksums)
+	}
+
+	// the changed release is still reported, until it is locked again
+	if lintHistory() {
+		t.Error("changed release not reported")
+	}
+	runLock(nil)
+	if !lintHistory() {
+		t.Error("unexpected problems after locking again")
+	}
+
+	err = os.RemoveAll(filepath.Join(dir, "0.9.0_2023-01-02"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lintHistory() {
+		t.Error("removed release not reported")
+	}
+}
+
+func TestMulti(t *testing.T) {
+	// the pending entries are only found with the config of the repository
+	remote, run, write := gitRepo(t)
+	for _, name := range []string{"changelog/0.1.0_2023-01-02", "changelog/0.2.0_2023-03-04", "changelog/unreleased-0.3.x"} {
+		err := os.MkdirAll(filepath.Join(remote, filepath.FromSlash(name)), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join("changelog", "0.1.0_2023-01-02", "issue-1"))
+	write(filepath.Join("changelog", "0.2.0_2023-03-04", "issue-2"))
+	write(filepath.Join("changelog", "unreleased-0.3.x", "issue-3"))
+	write(filepath.Join("changelog", "unreleased-0.3.x", "issue-4"))
+	err := ioutil.WriteFile(filepath.Join(remote, ".calens.yml"), []byte("unreleased: 0.3.x\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run("add", ".")
+	run("commit", "-q", "-m", "add changelog")
+
+	// the releases are read from the tags of the clone
+	tagged, run, write := gitRepo(t)
+	err = os.Mkdir(filepath.Join(tagged, "changelog"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(tagged, "changelog", "config.yml"), []byte("git-tags: v*\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(filepath.Join("changelog", "issue-6"))
+	run("add", ".")
+	run("commit", "-q", "-m", "add changelog")
+	tag := exec.Command("git", "-C", tagged, "-c", "user.name=test", "-c", "user.email=test@example.com", "tag", "-a", "-m", "v2.0.0", "v2.0.0")
+	tag.Env = append(os.Environ(), "GIT_COMMITTER_DATE=2023-07-08T12:00:00Z")
+	if out, err := tag.CombinedOutput(); err != nil {
+		t.Fatalf("git tag: %v\n%s", err, out)
+	}
+	write(filepath.Join("changelog", "issue-7"))
+	run("add", ".")
+	run("commit", "-q", "-m", "add issue-7")
+
+	dir := t.TempDir()
+	for _, name := range []string{"local/docs/1.0.0_2023-05-06", "local/docs/unreleased"} {
+		err := os.MkdirAll(filepath.Join(dir, filepath.FromSlash(name)), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, data := range map[string]string{
+		"local/docs/1.0.0_2023-05-06/issue-5": "Bugfix: Fix crash\n\nhttps://github.com/restic/restic/issues/5\n",
+		"broken/changelog/unreleased/issue-8": "Feature: Add rclone\n\nhttps://github.com/restic/restic/issues/8\n",
+	} {
+		err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(filepath.Join(dir, filepath.FromSlash(name)), []byte(data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reposFile := filepath.Join(dir, "repos.yml")
+	err = ioutil.WriteFile(reposFile, []byte("- path: local\n  changelog: docs\n- name: remote\n  url: "+remote+"\n- path: broken\n- name: tagged\n  url: "+tagged+"\n- name: missing\n  url: "+filepath.Join(dir, "missing")+"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		opts.Repos = ""
+		opts.Output = ""
+	}()
+	opts.Repos = reposFile
+	opts.Output = filepath.Join(dir, "index.md")
+
+	runMulti(nil)
+
+	buf, err := ioutil.ReadFile(opts.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"| Repository | Latest release | Date | Pending changes |",
+		"|---|---|---|---|",
+		"| local | 1.0.0 | 2023-05-06 | 0 |",
+		"| remote | 0.2.0 | 2023-03-04 | 2 |",
+		"| broken | error: ",
+		"| tagged | 2.0.0 | 2023-07-08 | 1 |",
+		"| missing | error: unable to clone ",
+	}
+	got := strings.Split(strings.TrimSuffix(string(buf), "\n"), "\n")
+	if len(got) != len(want) {
+		t.Fatalf("wrong index:\n%s", buf)
+	}
+	for i := range want {
+		if !strings.HasPrefix(got[i], want[i]) {
+			t.Errorf("wrong line %d, want %q, got %q", i, want[i], got[i])
+		}
+	}
+	if !strings.Contains(got[4], `entry type "Feature" is invalid`) {
+		t.Errorf("wrong error for broken repository: %q", got[4])
+	}
+
+	if opts.Unreleased != "unreleased" || opts.GitTags != "" {
+		t.Errorf("options of the repositories not restored: %q %q", opts.Unreleased, opts.GitTags)
+	}
+
+	err = ioutil.WriteFile(reposFile, []byte("- name: invalid\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readRepos(reposFile); err == nil {
+		t.Error("expected error for repository without path or url")
+	}
+}
+
+func TestReadReleasesSymlinks(t *testing.T) {
+	shared := t.TempDir()
+	for name, data := range map[string]string{
+		"1.0.0/issue-1":         "Bugfix: Fix crash\n\nhttps://github.com/restic/restic/issues/1\n",
+		"1.0.0/backup/issue-2":  "Bugfix: Fix backup\n\nhttps://github.com/restic/restic/issues/2\n",
+		"1.0.0/restore/issue-3": "Bugfix: Fix restore\n\nhttps://github.com/restic/restic/issues/3\n",
+	} {
+		err := os.MkdirAll(filepath.Join(shared, filepath.Dir(name)), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(filepath.Join(shared, filepath.FromSlash(name)), []byte(data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir := t.TempDir()
+	err := os.Symlink(filepath.Join(shared, "1.0.0"), filepath.Join(dir, "1.0.0_2023-09-07"))
+	if err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	// a loop back to the release directory
+	err = os.Symlink(filepath.Join(shared, "1.0.0"), filepath.Join(shared, "1.0.0", "restore", "loop"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		opts.Recursive = false
+	}()
+
+	for _, test := range []struct {
+		recursive bool
+		want      []string
+	}{
+		{false, []string{"issue-1"}},
+		{true, []string{"backup/issue-2", "issue-1", "restore/issue-3"}},
+	} {
+		opts.Recursive = test.recursive
+
+		rels := readReleases(dir)
+		if len(rels) != 1 || rels[0].Version != "1.0.0" {
+			t.Fatalf("wrong releases %v", rels)
+		}
+
+		var names []string
+		for _, file := range files(rels[0].Path) {
+			name, err := filepath.Rel(rels[0].Path, file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			names = append(names, filepath.ToSlash(name))
+		}
+
+		if diff := deep.Equal(test.want, names); diff != nil {
+			t.Errorf("recursive %v: %v", test.recursive, diff)
+		}
+	}
+}
+
+func TestEntrySliceSortBy(t *testing.T) {
+	defer func() {
+		opts.SortBy = "id"
+	}()
+
+	list := []changelog.Entry{
+		{Type: "Enhancement", Title: "Add zstd", PrimaryID: 5, File: "issue-5"},
+		{Type: "Bugfix", Title: "fix restore", PrimaryID: 3, File: "issue-3"},
+		{Type: "Bugfix", Title: "Fix backup", PrimaryID: 7, File: "issue-7"},
+		{Type: "Bugfix", Title: "Fix crash", PrimaryID: 1, File: "pull-1"},
+		{Type: "Bugfix", Title: "Fix prune", PrimaryID: 9, File: "02_issue-9", Order: 2},
+		{Type: "Bugfix", Title: "Fix check", PrimaryID: 8, File: "01_issue-8", Order: 1},
+	}
+
+	var tests = []struct {
+		sortBy string
+		want   []string
+	}{
+		{"id", []string{"01_issue-8", "02_issue-9", "pull-1", "issue-3", "issue-7", "issue-5"}},
+		{"title", []string{"01_issue-8", "02_issue-9", "issue-7", "pull-1", "issue-3", "issue-5"}},
+		{"file", []string{"01_issue-8", "02_issue-9", "issue-3", "issue-7", "pull-1", "issue-5"}},
+	}
+
+	for _, test := range tests {
+		opts.SortBy = test.sortBy
+
+		sorted := append([]changelog.Entry{}, list...)
+		opts.SortEntries(sorted)
+
+		var files []string
+		for _, e := range sorted {
+			files = append(files, e.File)
+		}
+
+		if diff := deep.Equal(test.want, files); diff != nil {
+			t.Errorf("%v: %v", test.sortBy, diff)
+		}
 	}
 }
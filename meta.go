@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// version is the version of calens, it can be set at build time with
+// -ldflags "-X main.version=x.y.z".
+var version = ""
+
+// calensVersion returns the version of calens, taken from the build
+// information if it wasn't set at build time.
+func calensVersion() string {
+	if version != "" {
+		return version
+	}
+
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+
+	return "unknown"
+}
+
+// Meta contains information about the current run of calens.
+type Meta struct {
+	// Generated is the time the changelog was generated, taken from
+	// $SOURCE_DATE_EPOCH if set, for reproducible builds.
+	Generated time.Time
+
+	// Version is the version of calens.
+	Version string
+
+	// RepoURL is the URL of the repository set with --repo-url.
+	RepoURL string
+
+	// Vars contains the variables set with --set.
+	Vars map[string]string
+}
+
+// runMeta is the meta information for this run, it is created on first use.
+var runMeta *Meta
+
+// newMeta collects the meta information for this run.
+func newMeta() (*Meta, error) {
+	m := &Meta{
+		Generated: time.Now(),
+		Version:   calensVersion(),
+		RepoURL:   opts.RepoURL,
+		Vars:      make(map[string]string),
+	}
+
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		sec, err := strconv.ParseInt(epoch, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SOURCE_DATE_EPOCH %q: %v", epoch, err)
+		}
+		m.Generated = time.Unix(sec, 0).UTC()
+	}
+
+	for _, v := range opts.Set {
+		data := strings.SplitN(v, "=", 2)
+		if len(data) != 2 || data[0] == "" {
+			return nil, fmt.Errorf("invalid variable %q, expected name=value", v)
+		}
+		m.Vars[data[0]] = data[1]
+	}
+
+	return m, nil
+}
+
+// Changelog is the data passed to the template: the list of all releases to
+// render.
+type Changelog []VersionChanges
+
+// Meta returns the meta information for this run, available in templates as
+// .Meta (or $.Meta inside a range).
+func (c Changelog) Meta() *Meta {
+	if runMeta == nil {
+		m, err := newMeta()
+		if err != nil {
+			die("%v", err)
+		}
+		runMeta = m
+	}
+
+	return runMeta
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/restic/calens/changelog"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// MultiRepo is a repository listed in the file given with --repos. Either
+// Path (a local checkout) or URL (cloned with git) must be set. Changelog is
+// the changelog dir relative to the repository, by default "changelog".
+type MultiRepo struct {
+	Name      string `yaml:"name"`
+	Path      string `yaml:"path"`
+	URL       string `yaml:"url"`
+	Changelog string `yaml:"changelog"`
+}
+
+// MultiStatus is the release status of a repository. Err is set if the
+// repository could not be read.
+type MultiStatus struct {
+	Name    string
+	Latest  string
+	Date    string
+	Pending int
+	Err     error
+}
+
+// readRepos reads the list of repositories from filename.
+func readRepos(filename string) ([]MultiRepo, error) {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var repos []MultiRepo
+	err = yaml.Unmarshal(buf, &repos)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %v: %v", filename, err)
+	}
+
+	for i, repo := range repos {
+		if (repo.Path == "") == (repo.URL == "") {
+			return nil, fmt.Errorf("%v: repository %d: set either path or url", filename, i+1)
+		}
+
+		if repo.Name == "" {
+			name := repo.Path
+			if name == "" {
+				name = repo.URL
+			}
+			repos[i].Name = strings.TrimSuffix(filepath.Base(name), ".git")
+		}
+
+		if repo.Changelog == "" {
+			repos[i].Changelog = "changelog"
+		}
+
+		// relative paths are resolved relative to the file
+		if repo.Path != "" && !filepath.IsAbs(repo.Path) {
+			repos[i].Path = filepath.Join(filepath.Dir(filename), repo.Path)
+		}
+	}
+
+	return repos, nil
+}
+
+// restoreCommandLine restores the options as given on the command line,
+// before the config file was read. It is set in init.
+var restoreCommandLine func()
+
+// saveOptions returns a function which restores opts, the flags set so far
+// and the registries changed by the config file to their current state.
+func saveOptions() (restore func()) {
+	saved := opts
+	changed := make(map[string]bool)
+	pflag.VisitAll(func(f *pflag.Flag) {
+		changed[f.Name] = f.Changed
+	})
+
+	priority := make(map[string]int)
+	for typ, prio := range changelog.EntryTypePriority {
+		priority[typ] = prio
+	}
+	abbreviation := copyStrings(changelog.EntryTypeAbbreviation)
+	emoji := copyStrings(changelog.EntryTypeEmoji)
+	aliases := copyStrings(changelog.EntryTypeAliases)
+	requiredLinks := make(map[string][]string)
+	for typ, kinds := range changelog.RequiredLinks {
+		requiredLinks[typ] = kinds
+	}
+	internal := make(map[string]bool)
+	for component := range InternalComponents {
+		internal[component] = true
+	}
+	bump, steps, post, configs := bumpRules, checklistSteps, postProcessors, loadedConfigs
+
+	return func() {
+		opts = saved
+		// the flags write to the maps, so each restore needs new ones
+		opts.TypeAbbreviation = copyStrings(saved.TypeAbbreviation)
+		opts.TypeEmoji = copyStrings(saved.TypeEmoji)
+		opts.TypeAlias = copyStrings(saved.TypeAlias)
+		pflag.VisitAll(func(f *pflag.Flag) {
+			f.Changed = changed[f.Name]
+		})
+
+		changelog.EntryTypePriority = make(map[string]int)
+		for typ, prio := range priority {
+			changelog.EntryTypePriority[typ] = prio
+		}
+		changelog.EntryTypeAbbreviation = copyStrings(abbreviation)
+		changelog.EntryTypeEmoji = copyStrings(emoji)
+		changelog.EntryTypeAliases = copyStrings(aliases)
+		changelog.RequiredLinks = make(map[string][]string)
+		for typ, kinds := range requiredLinks {
+			changelog.RequiredLinks[typ] = kinds
+		}
+		InternalComponents = make(map[string]bool)
+		for component := range internal {
+			InternalComponents[component] = true
+		}
+		bumpRules, checklistSteps, postProcessors, loadedConfigs = bump, steps, post, configs
+		changedSince = nil
+	}
+}
+
+// copyStrings returns a copy of m, which is never nil.
+func copyStrings(m map[string]string) map[string]string {
+	result := make(map[string]string, len(m))
+	for k, v := range m {
+		result[k] = v
+	}
+	return result
+}
+
+// loadRepoConfig sets up the options for the repository in root with the
+// changelog in dir: the options given on the command line are kept, all
+// others are read from the first of root/.calens.yml and dir/config.yml
+// which exists.
+func loadRepoConfig(root, dir string) error {
+	restoreCommandLine()
+
+	filename := findConfig([]string{filepath.Join(root, ".calens.yml"), filepath.Join(dir, "config.yml")})
+	if filename != "" {
+		err := applyConfig(filename)
+		if err != nil {
+			return err
+		}
+	}
+	opts.InputDir = dir
+
+	return applyOptions()
+}
+
+// repoStatus returns the latest release and the number of unreleased entries
+// of the changelog in dir, read with the current options.
+func repoStatus(name, dir string) MultiStatus {
+	status := MultiStatus{Name: name}
+
+	releases, err := loadReleases(dir)
+	if err != nil {
+		status.Err = err
+		return status
+	}
+
+	for _, rel := range releases {
+		if rel.Version == "unreleased" {
+			continue
+		}
+
+		status.Latest = rel.Version
+		if rel.Date != nil {
+			status.Date = rel.Date.Format("2006-01-02")
+		}
+		break
+	}
+
+	entries, err := opts.ReadEntries(releases)
+	if err != nil {
+		status.Err = err
+		return status
+	}
+	status.Pending = len(filterEntries(entries["unreleased"]))
+
+	return status
+}
+
+// runMulti implements the "multi" command: it reads the changelogs of the
+// repositories listed in the file given with --repos, cloning the ones with
+// a URL, and writes a Markdown index with the latest release and the number
+// of pending entries for each repository. Each repository is read with its
+// own config file, see loadRepoConfig. Repositories which cannot be cloned
+// or read are listed with the error.
+func runMulti(args []string) {
+	if len(args) != 0 || opts.Repos == "" {
+		die("usage: calens multi --repos FILE")
+	}
+
+	repos, err := readRepos(opts.Repos)
+	if err != nil {
+		die("%v", err)
+	}
+
+	if len(repos) == 0 {
+		die("no repositories found in %v", opts.Repos)
+	}
+
+	tempdir, err := ioutil.TempDir("", "calens-multi-")
+	if err != nil {
+		die("%v", err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tempdir)
+	}()
+
+	restore := saveOptions()
+
+	var list []MultiStatus
+	for i, repo := range repos {
+		list = append(list, multiStatus(repo, filepath.Join(tempdir, fmt.Sprintf("%d", i))))
+	}
+
+	restore()
+
+	writeOutput(func(wr io.Writer) error {
+		return writeMulti(wr, list)
+	})
+}
+
+// multiStatus returns the status of repo. Repositories with a URL are cloned
+// to dir, with the full history and all tags so that releases can be read
+// from git tags.
+func multiStatus(repo MultiRepo, dir string) MultiStatus {
+	if repo.URL == "" {
+		dir = repo.Path
+	} else {
+		_, err := git(filepath.Dir(dir), "clone", "--quiet", repo.URL, dir)
+		if err != nil {
+			return MultiStatus{Name: repo.Name, Err: fmt.Errorf("unable to clone %v: %v", repo.URL, err)}
+		}
+	}
+
+	changelogDir := filepath.Join(dir, repo.Changelog)
+	err := loadRepoConfig(dir, changelogDir)
+	if err != nil {
+		return MultiStatus{Name: repo.Name, Err: err}
+	}
+
+	return repoStatus(repo.Name, changelogDir)
+}
+
+// writeMulti writes the index for the repositories in list as a Markdown
+// table.
+func writeMulti(wr io.Writer, list []MultiStatus) error {
+	_, err := fmt.Fprintf(wr, "| Repository | Latest release | Date | Pending changes |\n|---|---|---|---|\n")
+	if err != nil {
+		return err
+	}
+
+	for _, status := range list {
+		if status.Err != nil {
+			msg := strings.Replace(strings.TrimSpace(status.Err.Error()), "\n", " ", -1)
+			_, err = fmt.Fprintf(wr, "| %v | error: %v | - | - |\n", status.Name, strings.Replace(msg, "|", "\\|", -1))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		latest, date := status.Latest, status.Date
+		if latest == "" {
+			latest = "-"
+		}
+		if date == "" {
+			date = "-"
+		}
+
+		_, err = fmt.Fprintf(wr, "| %v | %v | %v | %d |\n", status.Name, latest, date, status.Pending)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
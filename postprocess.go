@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// postProcessor is a command from the post-process section of the config,
+// see PostProcessConfig.
+type postProcessor struct {
+	output string
+	args   []string
+}
+
+// postProcessors are the commands from the post-process section of the
+// config, in the order they are run.
+var postProcessors []postProcessor
+
+// fileArg is replaced with the name of a temporary file containing the
+// output in the arguments of a post-processor.
+const fileArg = "{file}"
+
+// newPostProcessor checks a command from the post-process section.
+func newPostProcessor(cfg PostProcessConfig) (postProcessor, error) {
+	var p postProcessor
+
+	p.args = strings.Fields(cfg.Command)
+	if len(p.args) == 0 {
+		return p, errors.New("post-process: empty command")
+	}
+
+	if cfg.Output != "" {
+		if _, err := filepath.Match(cfg.Output, ""); err != nil {
+			return p, fmt.Errorf("post-process: invalid output pattern %q: %v", cfg.Output, err)
+		}
+	}
+	p.output = cfg.Output
+
+	return p, nil
+}
+
+// applies reports whether p is run for the output file name (empty for
+// stdout).
+func (p postProcessor) applies(name string) bool {
+	if p.output == "" {
+		return true
+	}
+
+	ok, _ := filepath.Match(p.output, filepath.Base(name))
+	return ok
+}
+
+// run runs the command with buf, either on stdin or in a temporary file if
+// an argument is fileArg, and returns what the command printed to stdout.
+// The temporary file has the same extension as name, so that tools like
+// prettier can detect the format.
+func (p postProcessor) run(name string, buf []byte) ([]byte, error) {
+	args := make([]string, len(p.args))
+	copy(args, p.args)
+
+	stdin := bytes.NewReader(buf)
+	for i, arg := range args {
+		if arg != fileArg {
+			continue
+		}
+
+		f, err := ioutil.TempFile("", "calens-*"+filepath.Ext(name))
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(f.Name())
+
+		_, err = f.Write(buf)
+		if err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+
+		err = f.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		args[i] = f.Name()
+		stdin = bytes.NewReader(nil)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		command := strings.Join(p.args, " ")
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("post-processor %q failed: %v: %v", command, err, msg)
+		}
+		return nil, fmt.Errorf("post-processor %q failed: %v", command, err)
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// postProcess runs the post-processors which apply to the output file name
+// (empty for stdout) in order, each one getting the output of the previous
+// one.
+func postProcess(name string, buf []byte) ([]byte, error) {
+	for _, p := range postProcessors {
+		if !p.applies(name) {
+			continue
+		}
+
+		var err error
+		buf, err = p.run(name, buf)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return buf, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/restic/calens/changelog"
+)
+
+// InternalComponents contains the components marked as internal in the
+// config (in lower case). Entries for these components are only rendered
+// with the "technical" preset.
+var InternalComponents = map[string]bool{}
+
+// keepEntry reports whether e is rendered with the preset set with --preset.
+// The "technical" preset renders all entries. The "user-facing" preset drops
+// entries which only reference pull requests (e.g. refactorings without an
+// issue reported by a user) and entries for internal components. Entries with
+// visibility internal are only rendered with --internal. With --audience,
+// entries for other audiences are dropped. Drafts are never rendered.
+func keepEntry(e changelog.Entry) bool {
+	if e.Draft {
+		return false
+	}
+
+	if e.Visibility == "internal" && !opts.Internal {
+		return false
+	}
+
+	if opts.Audience != "" && e.Audience != "" && !strings.EqualFold(e.Audience, opts.Audience) {
+		return false
+	}
+
+	if opts.Preset != "user-facing" {
+		return true
+	}
+
+	if len(e.Issues) == 0 && len(e.PRs) > 0 {
+		return false
+	}
+
+	return !InternalComponents[strings.ToLower(e.Component)]
+}
+
+// filterEntries returns the entries in list which are rendered with the
+// preset set with --preset.
+func filterEntries(list []changelog.Entry) (result []changelog.Entry) {
+	for _, e := range list {
+		if keepEntry(e) {
+			result = append(result, e)
+		}
+	}
+	return result
+}
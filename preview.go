@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/restic/calens/changelog"
+)
+
+// runPreviewEntry implements the "preview-entry" command: it renders the
+// entry file given in args with the template as the only entry of an
+// unreleased version, e.g. to paste it into the description of a pull
+// request. Drafts are rendered as well.
+func runPreviewEntry(args []string) {
+	if len(args) != 1 {
+		die("usage: calens preview-entry FILE")
+	}
+
+	templ := loadTemplate()
+
+	e := readFile(args[0])
+	e.Draft = false
+	if !keepEntry(e) {
+		die("%v is not rendered with the current options, see calens explain %v", args[0], args[0])
+	}
+
+	changes := Changelog{versionChanges(changelog.Release{Version: "unreleased"}, []changelog.Entry{e})}
+	changes.Meta()
+
+	writeOutput(func(wr io.Writer) error {
+		err := templ.Execute(wr, changes)
+		if err != nil {
+			return fmt.Errorf("error executing template: %v", err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/restic/calens/changelog"
+)
+
+// ProvenanceRelease lists the origin of all entries in a release.
+type ProvenanceRelease struct {
+	Version string             `json:"version"`
+	Date    string             `json:"date"`
+	Entries []ProvenanceRecord `json:"entries"`
+}
+
+// ProvenanceRecord describes where an entry comes from.
+type ProvenanceRecord struct {
+	Type        string `json:"type"`
+	Title       string `json:"title"`
+	File        string `json:"file"`
+	Commit      string `json:"commit,omitempty"`
+	Author      string `json:"author,omitempty"`
+	AuthorEmail string `json:"author_email,omitempty"`
+	CommitDate  string `json:"commit_date,omitempty"`
+	PullRequest string `json:"pull_request,omitempty"`
+}
+
+var (
+	squashPullRequestRegexp = regexp.MustCompile(`\(#(\d+)\)$`)
+	mergePullRequestRegexp  = regexp.MustCompile(`^Merge pull request #(\d+)`)
+)
+
+// runProvenance implements the "provenance" command: it writes a JSON report
+// which lists the file, the commit which added it and the pull request the
+// commit was merged in for each entry of the selected releases.
+func runProvenance(args []string) {
+	if len(args) != 0 {
+		die("usage: calens provenance [--version VERSION]")
+	}
+
+	report := []ProvenanceRelease{}
+	for _, vc := range buildChanges(selectReleases(readReleases(opts.InputDir))) {
+		rel := ProvenanceRelease{
+			Version: vc.Version,
+			Date:    vc.Date,
+		}
+
+		for _, e := range vc.Entries {
+			rel.Entries = append(rel.Entries, provenance(e))
+		}
+
+		report = append(report, rel)
+	}
+
+	writeOutput(func(wr io.Writer) error {
+		enc := json.NewEncoder(wr)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	})
+}
+
+// provenance finds the commit which added the file of the entry and the pull
+// request it was merged in. Files which are not committed yet only have the
+// file name set.
+func provenance(e changelog.Entry) ProvenanceRecord {
+	rec := ProvenanceRecord{
+		Type:  e.Type,
+		Title: e.Title,
+		File:  filepath.ToSlash(e.File),
+	}
+
+	dir, name := filepath.Dir(e.File), filepath.Base(e.File)
+	// follow renames, so that entries moved into a release directory are
+	// credited to the commit which added them originally
+	out, err := git(dir, "log", "--follow", "--diff-filter=A", "--format=%H%x00%an%x00%ae%x00%aI%x00%s", "--", name)
+	if err != nil {
+		die("unable to find commit for %v: %v", e.File, err)
+	}
+
+	commits := lines(out)
+	if len(commits) == 0 {
+		return rec
+	}
+
+	// the oldest commit which added the file is listed last
+	fields := strings.SplitN(commits[len(commits)-1], "\x00", 5)
+	if len(fields) != 5 {
+		return rec
+	}
+	rec.Commit, rec.Author, rec.AuthorEmail, rec.CommitDate = fields[0], fields[1], fields[2], fields[3]
+
+	// squash merges reference the pull request in the subject
+	if m := squashPullRequestRegexp.FindStringSubmatch(fields[4]); m != nil {
+		rec.PullRequest = m[1]
+		return rec
+	}
+
+	// otherwise look for the first merge commit which contains the commit
+	out, err = git(dir, "log", "--merges", "--ancestry-path", "--reverse", "--format=%s", rec.Commit+"..HEAD")
+	if err != nil {
+		die("unable to find merge for %v: %v", e.File, err)
+	}
+
+	for _, subject := range lines(out) {
+		if m := mergePullRequestRegexp.FindStringSubmatch(subject); m != nil {
+			rec.PullRequest = m[1]
+			break
+		}
+	}
+
+	return rec
+}
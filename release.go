@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/restic/calens/changelog"
+)
+
+// releaseTimezone is the time zone set with --timezone, the current date in
+// this time zone is used for new releases.
+var releaseTimezone = time.Local
+
+// now returns the current time in the time zone set with --timezone.
+func now() time.Time {
+	return time.Now().In(releaseTimezone)
+}
+
+// runRelease implements the "release" command: it promotes the unreleased
+// directory to a release directory for the version given in args, stamped
+// with today's date. With --review, each entry is presented to the user
+// first. Drafts stay in the unreleased directory. Afterwards, the new
+// release is added to the releases file and the history lock file if there
+// are such files, and the files configured in the bump section of the config
+// are updated to the new version.
+func runRelease(args []string) {
+	if len(args) != 1 {
+		die("usage: calens release [--review] VERSION")
+	}
+
+	rel, err := opts.ParseVersion(args[0])
+	if err != nil {
+		die("invalid version %v: %v", args[0], err)
+	}
+
+	src := bucketDir(opts.Unreleased)
+	dst := filepath.Join(opts.InputDir, rel.Version+"_"+now().Format("2006-01-02"))
+
+	if _, err := os.Stat(dst); err == nil {
+		die("release directory %v already exists", dst)
+	}
+
+	if opts.Review {
+		if !reviewEntries(src, bufio.NewReader(os.Stdin)) {
+			die("review aborted, nothing released")
+		}
+	}
+
+	// make sure all entries are valid before anything is moved
+	var drafts []string
+	for _, file := range files(src) {
+		if readFile(file).Draft {
+			// drafts may be in subdirectories with --recursive
+			name, err := filepath.Rel(src, file)
+			if err != nil {
+				die("%v", err)
+			}
+			drafts = append(drafts, name)
+		}
+	}
+
+	err = os.Rename(src, dst)
+	if err != nil {
+		die("unable to rename %v: %v", src, err)
+	}
+
+	err = os.Mkdir(src, 0755)
+	if err != nil {
+		die("unable to create %v: %v", src, err)
+	}
+
+	for _, name := range drafts {
+		err = os.MkdirAll(filepath.Dir(filepath.Join(src, name)), 0755)
+		if err != nil {
+			die("unable to keep draft %v: %v", name, err)
+		}
+
+		err = os.Rename(filepath.Join(dst, name), filepath.Join(src, name))
+		if err != nil {
+			die("unable to keep draft %v: %v", name, err)
+		}
+	}
+
+	fmt.Printf("released %v as %v\n", src, dst)
+
+	err = addToReleasesFile(opts.InputDir, rel.Version, now().Format("2006-01-02"))
+	if err != nil {
+		die("unable to update %v: %v", changelog.ReleasesFile, err)
+	}
+
+	released, err := opts.ParseReleaseDir(dst)
+	if err != nil {
+		die("%v", err)
+	}
+
+	err = lockRelease(released)
+	if err != nil {
+		die("unable to update lock file: %v", err)
+	}
+
+	err = bumpFiles(rel.Version)
+	if err != nil {
+		die("%v", err)
+	}
+}
+
+// addToReleasesFile lists the new version with its date in the releases index
+// file in dir, if there is one. The version is inserted before the first
+// released version, so that the file stays sorted newest first.
+func addToReleasesFile(dir, version, date string) error {
+	filename := filepath.Join(dir, changelog.ReleasesFile)
+	buf, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	lines := strings.SplitAfter(string(buf), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	idx := len(lines)
+	for i, line := range lines {
+		text := strings.TrimSpace(line)
+		if text == "" || strings.HasPrefix(text, "#") || strings.Fields(text)[0] == "unreleased" {
+			continue
+		}
+		idx = i
+		break
+	}
+
+	// make sure the previous line is terminated
+	if idx == len(lines) && idx > 0 && !strings.HasSuffix(lines[idx-1], "\n") {
+		lines[idx-1] += "\n"
+	}
+
+	line := version + " " + date + "\n"
+	lines = append(lines[:idx], append([]string{line}, lines[idx:]...)...)
+
+	return ioutil.WriteFile(filename, []byte(strings.Join(lines, "")), 0644)
+}
+
+// reviewEntries steps through all entries in dir and asks the user what to
+// do with each of them. It returns false if the user aborted the review.
+func reviewEntries(dir string, rd *bufio.Reader) bool {
+	list := files(dir)
+	for i, file := range list {
+		for done := false; !done; {
+			fmt.Printf("\n[%d/%d] %v\n", i+1, len(list), file)
+
+			e, err := opts.ParseFile(file)
+			if err != nil {
+				fmt.Printf("  error: %v\n", err)
+			} else {
+				fmt.Printf("  %v: %v\n", e.Type, e.Title)
+				for _, par := range e.Paragraphs {
+					text, _ := wrapIndent(par, 76, 4)
+					fmt.Printf("\n    %v\n", text)
+				}
+			}
+
+			answer := prompt(rd, "\n[a]ccept, [e]dit, [r]etype, [d]efer or [q]uit? ")
+			switch answer {
+			case "a", "accept":
+				if err != nil {
+					fmt.Println("invalid entries cannot be accepted, edit or defer it instead")
+					continue
+				}
+				done = true
+			case "e", "edit":
+				editFile(file)
+			case "r", "retype":
+				typ := changelog.Capitalize(prompt(rd, "new type: "))
+				if _, ok := changelog.EntryTypePriority[typ]; !ok {
+					fmt.Printf("entry type %q is invalid, valid types: %v\n", typ, strings.Join(changelog.EntryTypes(), ", "))
+					continue
+				}
+				retypeFile(file, typ)
+			case "d", "defer":
+				deferFile(file, opts.DeferTo)
+				done = true
+			case "q", "quit":
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// prompt prints msg and returns the next line the user entered, without
+// surrounding white space.
+func prompt(rd *bufio.Reader, msg string) string {
+	fmt.Print(msg)
+	line, err := rd.ReadString('\n')
+	if err != nil && line == "" {
+		die("unable to read answer: %v", err)
+	}
+	return strings.TrimSpace(line)
+}
+
+// editFile opens filename in the editor configured in $VISUAL or $EDITOR.
+func editFile(filename string) {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+
+	args := strings.Fields(editor)
+	cmd := exec.Command(args[0], append(args[1:], filename)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	err := cmd.Run()
+	if err != nil {
+		fmt.Printf("editor returned error: %v\n", err)
+	}
+}
+
+// retypeFile replaces the type in the title line of filename with typ. The
+// component and the marker for breaking changes (like in "Change(backup)!")
+// are kept.
+func retypeFile(filename, typ string) {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		die("unable to read %v: %v", filename, err)
+	}
+
+	lines := strings.Split(string(changelog.NormalizeText(buf)), "\n")
+	idx := changelog.TitleLineIndex(lines)
+	if idx >= len(lines) {
+		die("unable to retype %v: missing title line", filename)
+	}
+
+	prefix, title := "", lines[idx]
+	if data := strings.SplitN(title, ": ", 2); len(data) == 2 {
+		prefix, title = strings.TrimSpace(data[0]), data[1]
+	}
+
+	suffix := ""
+	_, component, breaking := changelog.SplitTypePrefix(prefix)
+	if component != "" {
+		suffix = "(" + component + ")"
+	}
+	if breaking {
+		suffix += "!"
+	}
+	lines[idx] = typ + suffix + ": " + title
+
+	err = ioutil.WriteFile(filename, restoreLineEndings(buf, strings.Join(lines, "\n")), 0644)
+	if err != nil {
+		die("unable to write %v: %v", filename, err)
+	}
+}
+
+// runDefer implements the "defer" command: it moves the entry files in args
+// to the pending bucket selected with --defer-to.
+func runDefer(args []string) {
+	if len(args) == 0 {
+		die("usage: calens defer [--defer-to BUCKET] FILE...")
+	}
+
+	for _, file := range args {
+		fi, err := os.Stat(file)
+		if err != nil {
+			die("unable to defer %v: %v", file, err)
+		}
+		if !fi.Mode().IsRegular() {
+			die("unable to defer %v: not a regular file", file)
+		}
+
+		deferFile(file, opts.DeferTo)
+	}
+}
+
+// bucketDir returns the directory for the pending bucket name. The bucket
+// "unreleased" is the directory for the next release, all other buckets are
+// directories for deferred entries or for release branches. The name may
+// also be given with the directory prefix, like "unreleased-next".
+func bucketDir(name string) string {
+	name = strings.TrimPrefix(name, changelog.DeferredPrefix)
+	if name == "unreleased" {
+		return filepath.Join(opts.InputDir, name)
+	}
+	return filepath.Join(opts.InputDir, changelog.DeferredPrefix+name)
+}
+
+// deferFile moves filename to the directory of the pending bucket name and
+// records the move in the defer log.
+func deferFile(filename, name string) {
+	dir := bucketDir(name)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		die("unable to create %v: %v", dir, err)
+	}
+
+	dst := filepath.Join(dir, filepath.Base(filename))
+	if _, err := os.Stat(dst); err == nil {
+		die("unable to defer %v: %v already exists", filename, dst)
+	}
+
+	err = os.Rename(filename, dst)
+	if err != nil {
+		die("unable to move %v: %v", filename, err)
+	}
+
+	logname := filepath.Join(opts.InputDir, changelog.DeferLog)
+	f, err := os.OpenFile(logname, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		die("unable to open %v: %v", logname, err)
+	}
+
+	_, err = fmt.Fprintf(f, "%v %v -> %v\n", now().Format("2006-01-02"), filepath.ToSlash(filename), filepath.ToSlash(dst))
+	if err != nil {
+		_ = f.Close()
+		die("unable to write %v: %v", logname, err)
+	}
+
+	err = f.Close()
+	if err != nil {
+		die("unable to close %v: %v", logname, err)
+	}
+
+	fmt.Printf("deferred %v to %v\n", filename, dir)
+}
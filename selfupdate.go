@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// selfUpdateRepo is the GitHub repository calens is released from.
+const selfUpdateRepo = "restic/calens"
+
+// releaseKeyFingerprint is the fingerprint of the PGP key the checksums of
+// the releases are signed with. Signatures made with other keys are
+// rejected, even if gpg knows and trusts them.
+const releaseKeyFingerprint = "CF8F18F2844575973F79D4E191A6868BD3F7A907"
+
+// githubRelease is a release returned by the GitHub API.
+type githubRelease struct {
+	TagName string `json:"tag_name"`
+	Assets  []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+// assetURL returns the download URL for the asset name.
+func (r githubRelease) assetURL(name string) (string, bool) {
+	for _, asset := range r.Assets {
+		if asset.Name == name {
+			return asset.URL, true
+		}
+	}
+	return "", false
+}
+
+// download returns the content of the URL.
+func download(url string) ([]byte, error) {
+	res, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		_ = res.Body.Close()
+		return nil, err
+	}
+
+	err = res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %v returned %v", url, res.Status)
+	}
+
+	return buf, nil
+}
+
+// runSelfUpdate implements the "self-update" command: it downloads the
+// latest release of calens for the current platform, verifies the signature
+// of the checksums file and the checksum and replaces the running binary.
+// Without a valid signature by the release key, the binary is not replaced
+// unless --insecure is given.
+func runSelfUpdate(args []string) {
+	if len(args) != 0 {
+		die("usage: calens self-update")
+	}
+
+	var rel githubRelease
+	err := githubGet(fmt.Sprintf("/repos/%v/releases/latest", selfUpdateRepo), &rel)
+	if err != nil {
+		die("unable to find latest release: %v", err)
+	}
+
+	latest := strings.TrimPrefix(rel.TagName, "v")
+	if latest == strings.TrimPrefix(calensVersion(), "v") {
+		fmt.Printf("calens %v is up to date\n", latest)
+		return
+	}
+
+	name := fmt.Sprintf("calens_%v_%v_%v.bz2", latest, runtime.GOOS, runtime.GOARCH)
+	binURL, ok := rel.assetURL(name)
+	if !ok {
+		die("release %v has no binary %v for this platform", rel.TagName, name)
+	}
+
+	sumsURL, ok := rel.assetURL("SHA256SUMS")
+	if !ok {
+		die("release %v has no SHA256SUMS file, refusing to update", rel.TagName)
+	}
+
+	sums, err := download(sumsURL)
+	if err != nil {
+		die("unable to download checksums: %v", err)
+	}
+
+	if opts.Insecure {
+		fmt.Fprintf(os.Stderr, "warning: not verifying the signature of SHA256SUMS because of --insecure\n")
+	} else {
+		sigURL, ok := rel.assetURL("SHA256SUMS.asc")
+		if !ok {
+			die("release %v has no signature SHA256SUMS.asc, refusing to update (use --insecure to update anyway)", rel.TagName)
+		}
+
+		err = verifySignature(sums, sigURL)
+		if err != nil {
+			die("%v, refusing to update (use --insecure to update anyway)", err)
+		}
+	}
+
+	want, ok := checksumFor(sums, name)
+	if !ok {
+		die("no checksum for %v found in SHA256SUMS", name)
+	}
+
+	buf, err := download(binURL)
+	if err != nil {
+		die("unable to download %v: %v", name, err)
+	}
+
+	hash := sha256.Sum256(buf)
+	if hex.EncodeToString(hash[:]) != want {
+		die("checksum mismatch for %v, refusing to update", name)
+	}
+
+	bin, err := ioutil.ReadAll(bzip2.NewReader(bytes.NewReader(buf)))
+	if err != nil {
+		die("unable to decompress %v: %v", name, err)
+	}
+
+	replaceExecutable(bin)
+	fmt.Printf("updated calens to %v\n", latest)
+}
+
+// checksumFor returns the SHA256 checksum for the file name listed in sums,
+// which is in the format produced by sha256sum.
+func checksumFor(sums []byte, name string) (string, bool) {
+	sc := bufio.NewScanner(bytes.NewReader(sums))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return fields[0], true
+		}
+	}
+	return "", false
+}
+
+// verifySignature checks with gpg that the signature downloaded from sigURL
+// is a valid signature for sums made with the release key (see
+// releaseKeyFingerprint). The key must be in the keyring of gpg.
+func verifySignature(sums []byte, sigURL string) error {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		return errors.New("gpg not found, unable to verify the signature of SHA256SUMS")
+	}
+
+	sig, err := download(sigURL)
+	if err != nil {
+		return fmt.Errorf("unable to download signature: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "calens-update-")
+	if err != nil {
+		return fmt.Errorf("unable to create temp dir: %v", err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	for name, data := range map[string][]byte{"SHA256SUMS": sums, "SHA256SUMS.asc": sig} {
+		err = ioutil.WriteFile(filepath.Join(dir, name), data, 0600)
+		if err != nil {
+			return fmt.Errorf("unable to write %v: %v", name, err)
+		}
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("gpg", "--status-fd", "1", "--verify", filepath.Join(dir, "SHA256SUMS.asc"), filepath.Join(dir, "SHA256SUMS"))
+	cmd.Stderr = &stderr
+	status, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("signature verification failed (import the release key with gpg --recv-keys %v): %v\n%s", releaseKeyFingerprint, err, stderr.Bytes())
+	}
+
+	if !signedBy(status, releaseKeyFingerprint) {
+		return fmt.Errorf("SHA256SUMS is not signed by the release key %v", releaseKeyFingerprint)
+	}
+
+	return nil
+}
+
+// signedBy reports whether the status output of "gpg --status-fd --verify"
+// contains a valid signature made by the key with fingerprint (or one of its
+// subkeys).
+func signedBy(status []byte, fingerprint string) bool {
+	sc := bufio.NewScanner(bytes.NewReader(status))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 3 || fields[0] != "[GNUPG:]" || fields[1] != "VALIDSIG" {
+			continue
+		}
+
+		// the fingerprint of the signing key is followed by the one of the
+		// primary key as the last field
+		if strings.EqualFold(fields[2], fingerprint) || strings.EqualFold(fields[len(fields)-1], fingerprint) {
+			return true
+		}
+	}
+	return false
+}
+
+// replaceExecutable atomically replaces the running binary with bin.
+func replaceExecutable(bin []byte) {
+	exe, err := os.Executable()
+	if err != nil {
+		die("unable to find executable: %v", err)
+	}
+
+	exe, err = filepath.EvalSymlinks(exe)
+	if err != nil {
+		die("unable to resolve executable: %v", err)
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(exe), ".calens-update-")
+	if err != nil {
+		die("unable to create temp file: %v", err)
+	}
+
+	_, err = f.Write(bin)
+	if err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		die("unable to write new binary: %v", err)
+	}
+
+	err = f.Close()
+	if err != nil {
+		_ = os.Remove(f.Name())
+		die("unable to write new binary: %v", err)
+	}
+
+	err = os.Chmod(f.Name(), 0755)
+	if err != nil {
+		_ = os.Remove(f.Name())
+		die("unable to make new binary executable: %v", err)
+	}
+
+	err = os.Rename(f.Name(), exe)
+	if err != nil {
+		_ = os.Remove(f.Name())
+		die("unable to replace %v: %v", exe, err)
+	}
+}
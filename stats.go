@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/restic/calens/changelog"
+)
+
+// qualityCheck is a criterion for the quality score of an entry.
+type qualityCheck struct {
+	// problem describes an entry which does not meet the criterion
+	problem string
+	ok      func(changelog.Entry) bool
+}
+
+// qualityChecks are the criteria for the quality score, each one met adds
+// the same share to the score.
+var qualityChecks = []qualityCheck{
+	{"no body", func(e changelog.Entry) bool {
+		return len(e.Paragraphs) > 0
+	}},
+	{"no issue or pull request", func(e changelog.Entry) bool {
+		return len(e.Issues)+len(e.PRs) > 0
+	}},
+	{"title style", titleStyleOK},
+	{"title too short", func(e changelog.Entry) bool {
+		return len(strings.Fields(e.Title)) >= 3
+	}},
+}
+
+// titleStyleOK reports whether the title line in the file of e has none of
+// the problems reported by lint. The parser accepts (and fixes) some of them.
+func titleStyleOK(e changelog.Entry) bool {
+	buf, err := ioutil.ReadFile(e.File)
+	if err != nil {
+		return true
+	}
+
+	lines := strings.Split(string(changelog.NormalizeText(buf)), "\n")
+	line := strings.TrimSpace(lines[changelog.TitleLineIndex(lines)])
+	if _, problems := fixTitle(line); len(problems) > 0 {
+		return false
+	}
+
+	title := line[strings.Index(line, ": ")+2:]
+	return title == changelog.Capitalize(title)
+}
+
+// quality returns the quality score of e from 0 to 100 and the problems
+// found.
+func quality(e changelog.Entry) (score int, problems []string) {
+	passed := 0
+	for _, check := range qualityChecks {
+		if check.ok(e) {
+			passed++
+			continue
+		}
+		problems = append(problems, check.problem)
+	}
+
+	return passed * 100 / len(qualityChecks), problems
+}
+
+// runStats implements the "stats" command: it prints the number of entries
+// per type for each release. With --quality, the quality score of each
+// release (the average score of its entries) and the entries with problems
+// are printed as well.
+func runStats(args []string) {
+	if len(args) != 0 {
+		die("usage: calens stats [--quality] [--version VERSION]")
+	}
+
+	changes := buildChanges(selectReleases(readReleases(opts.InputDir)))
+
+	writeOutput(func(wr io.Writer) error {
+		for _, vc := range changes {
+			err := writeStats(wr, vc)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// writeStats writes the statistics for one release to wr.
+func writeStats(wr io.Writer, vc VersionChanges) error {
+	var types []string
+	for _, typ := range changelog.EntryTypes() {
+		if n := countType(vc.Entries, typ); n > 0 {
+			types = append(types, fmt.Sprintf("%v: %d", typ, n))
+		}
+	}
+
+	line := fmt.Sprintf("%v (%v): %d entries", vc.Version, vc.Date, len(vc.Entries))
+	if len(types) > 0 {
+		line += " (" + strings.Join(types, ", ") + ")"
+	}
+
+	if !opts.Quality || len(vc.Entries) == 0 {
+		_, err := fmt.Fprintln(wr, line)
+		return err
+	}
+
+	var total int
+	var details []string
+	for _, e := range vc.Entries {
+		score, problems := quality(e)
+		total += score
+		if len(problems) > 0 {
+			details = append(details, fmt.Sprintf("  %3d%%  %v: %v", score, e.File, strings.Join(problems, ", ")))
+		}
+	}
+
+	_, err := fmt.Fprintf(wr, "%v, quality %d%%\n", line, total/len(vc.Entries))
+	if err != nil {
+		return err
+	}
+
+	for _, d := range details {
+		_, err = fmt.Fprintln(wr, d)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// SkipWarning describes a file or directory which was ignored.
+type SkipWarning struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// warnSkipped reports that path was ignored for reason, if enabled with
+// --warn-skipped. The warning is printed to stderr, either as text or as one
+// JSON object per line (with --warn-format json).
+func warnSkipped(path, reason string) {
+	if !opts.WarnSkipped {
+		return
+	}
+
+	w := SkipWarning{Path: filepath.ToSlash(path), Reason: reason}
+
+	switch opts.WarnFormat {
+	case "json":
+		buf, err := json.Marshal(w)
+		if err != nil {
+			die("unable to encode warning: %v", err)
+		}
+		fmt.Fprintf(os.Stderr, "%s\n", buf)
+	default:
+		fmt.Fprintf(os.Stderr, "warning: skipped %v: %v\n", w.Path, w.Reason)
+	}
+}